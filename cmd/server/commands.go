@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rockbot-adserver/internal/config"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/rollup"
+	"rockbot-adserver/internal/service"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"time"
+)
+
+// runCommand executes an admin subcommand, e.g. `adserver create-user -username alice`
+func runCommand(db *store.Store, cfg config.Config, name string, args []string) error {
+	switch name {
+	case "create-user":
+		return createUserCommand(db, args)
+	case "rebuild-rollups":
+		return rebuildRollupsCommand(db, cfg, args)
+	case "purge-deleted":
+		return purgeDeletedCommand(db, cfg, args)
+	case "import-campaigns":
+		return importCampaignsCommand(db, cfg, args)
+	case "export-campaigns":
+		return exportCampaignsCommand(db, cfg, args)
+	default:
+		return fmt.Errorf("unknown command %q (available: create-user, rebuild-rollups, purge-deleted, import-campaigns, export-campaigns)", name)
+	}
+}
+
+// rebuildRollupsCommand recomputes the report rollups from every impression.
+// It is safe to run while the server is up; the server's aggregator simply
+// continues from the rebuilt state.
+func rebuildRollupsCommand(db *store.Store, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("rebuild-rollups", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start := time.Now()
+	aggregator := &rollup.Aggregator{Store: db, Grace: cfg.Reporting.RollupGrace}
+	if err := aggregator.Rebuild(start); err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilt rollups in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// purgeDeletedCommand hard-deletes campaigns and ads that were deleted
+// longer ago than the retention window. Their impressions are kept.
+func purgeDeletedCommand(db *store.Store, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("purge-deleted", flag.ContinueOnError)
+	days := fs.Int("days", cfg.Campaigns.DeletedRetentionDays, "purge what was deleted more than this many days ago")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *days < 0 {
+		return fmt.Errorf("-days must not be negative")
+	}
+	cutoff := time.Now().AddDate(0, 0, -*days)
+	campaigns, ads, err := db.PurgeDeleted(cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d campaigns and %d ads deleted before %s\n", campaigns, ads, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+// importCampaignsCommand bulk-imports campaigns from a CSV or JSON file, as
+// POST /api/campaigns/import does, for every advertiser
+func importCampaignsCommand(db *store.Store, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("import-campaigns", flag.ContinueOnError)
+	format := fs.String("format", "", "csv or json (default from the file extension)")
+	dryRun := fs.Bool("dry-run", false, "check every row without writing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: import-campaigns [-format csv|json] [-dry-run] FILE")
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = campaignFileFormat(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	svc := service.NewAdService(db, service.RateLimit{MaxSeconds: cfg.Serving.RateLimitSeconds, Window: cfg.Serving.RateLimitWindow}, cfg.Serving.TrackingBaseURL)
+	result, err := svc.ImportCampaigns(f, *format, *dryRun, models.AllTenants, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(os.Stderr, "row %d: %s\n", e.Row, e.Message)
+	}
+	verb := "Imported"
+	if *dryRun || len(result.Errors) > 0 {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d rows: %d created, %d updated, %d unchanged\n", verb, result.Rows, result.Created, result.Updated, result.Unchanged)
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d rows have errors; nothing was imported", len(result.Errors))
+	}
+	return nil
+}
+
+// exportCampaignsCommand writes every campaign to a CSV or JSON file (or
+// stdout) that import-campaigns reads back
+func exportCampaignsCommand(db *store.Store, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet("export-campaigns", flag.ContinueOnError)
+	format := fs.String("format", "", "csv or json (default from the -o extension, else json)")
+	output := fs.String("o", "", "file to write (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = campaignFileFormat(*output)
+	}
+
+	svc := service.NewAdService(db, service.RateLimit{MaxSeconds: cfg.Serving.RateLimitSeconds, Window: cfg.Serving.RateLimitWindow}, cfg.Serving.TrackingBaseURL)
+	records, err := svc.ExportCampaigns(models.AllTenants)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return service.WriteCampaignRecords(os.Stdout, *format, records)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := service.WriteCampaignRecords(f, *format, records); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d campaigns to %s\n", len(records), *output)
+	return nil
+}
+
+// campaignFileFormat picks csv for .csv files and json otherwise
+func campaignFileFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return service.CampaignFormatCSV
+	}
+	return service.CampaignFormatJSON
+}
+
+// createUserCommand adds a login, or with -reset changes an existing user's
+// password. The password is read from stdin unless -password is given, so it
+// doesn't end up in shell history.
+func createUserCommand(db *store.Store, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	username := fs.String("username", "", "login name of the user")
+	password := fs.String("password", "", "password (read from stdin if omitted)")
+	role := fs.String("role", models.RoleReadOnly, "role of the new user: "+strings.Join(models.Roles, ", "))
+	tenant := fs.String("tenant", "", "ID of the agency or advertiser the user belongs to (empty for platform staff)")
+	reset := fs.Bool("reset", false, "change the password of an existing user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	auth := service.NewAuthService(db, time.Hour)
+	if *reset {
+		if err := auth.SetPassword(*username, *password); err != nil {
+			return err
+		}
+		fmt.Printf("Password changed for %s\n", *username)
+		return nil
+	}
+
+	user, err := auth.CreateUser(*username, *password, *role, *tenant)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s user %s (%s)\n", user.Role, user.Username, user.ID)
+	return nil
+}
@@ -1,107 +1,346 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"rockbot-adserver/internal/api"
+	"rockbot-adserver/internal/blob"
+	"rockbot-adserver/internal/config"
+	"rockbot-adserver/internal/logging"
+	"rockbot-adserver/internal/metrics"
 	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/reqlog"
+	"rockbot-adserver/internal/rollup"
 	"rockbot-adserver/internal/service"
 	"rockbot-adserver/internal/store"
+	"rockbot-adserver/internal/tlsreload"
+	"rockbot-adserver/internal/tracing"
 	"strings"
+	"syscall"
+	"time"
+)
 
-	"github.com/google/uuid"
+// Set at build time with
+// -ldflags "-X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	commit    = "unknown"
+	buildTime = "unknown"
 )
 
 func main() {
-	// Get database path from environment variable or use default
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "adserver.db"
+	slog.SetDefault(logging.New(os.Stdout, slog.LevelInfo))
+
+	// Settings come from defaults, an optional YAML/TOML file, the
+	// environment and flags, in that order of precedence
+	cfg, args, printed, err := config.Load(os.Args[1:], os.Getenv, os.Stdout)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(2)
+	}
+	if printed {
+		return
 	}
+	// Admin subcommands don't serve VAST, so they don't need the public URLs
+	if len(args) == 0 {
+		if err := cfg.ValidateServing(); err != nil {
+			slog.Error("Invalid configuration", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	// JSON logs on stdout at the configured level
+	logLevel, _ := logging.ParseLevel(cfg.Logging.Level)
+	slog.SetDefault(logging.New(os.Stdout, logLevel))
 
 	// Initialize Store
-	db, err := store.NewStore(dbPath)
+	db, err := store.NewStore(cfg.Database.Path)
+	if err != nil {
+		fatal("Failed to init store", "error", err)
+	}
+
+	// Admin subcommands (e.g. create-user) run against the same database and exit
+	if len(args) > 0 {
+		if err := runCommand(db, cfg, args[0], args[1:]); err != nil {
+			fatal("Command failed", "command", args[0], "error", err)
+		}
+		return
+	}
+
+	// Creative files live on local disk next to the database unless configured otherwise
+	blobs, err := blob.NewLocalStore(cfg.Media.Dir, cfg.Media.BaseURL)
 	if err != nil {
-		log.Fatalf("Failed to init store: %v", err)
-	}
-
-	// Seed available ads on startup
-	availableAds := []models.Ad{
-		{
-			ID:              uuid.New().String(),
-			MediaURL:        "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerBlazes.mp4",
-			DurationSeconds: 15,
-			CreativeID:      "creative-1",
-		},
-		{
-			ID:              uuid.New().String(),
-			MediaURL:        "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerEscapes.mp4",
-			DurationSeconds: 15,
-			CreativeID:      "creative-2",
-		},
-		{
-			ID:              uuid.New().String(),
-			MediaURL:        "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerFun.mp4",
-			DurationSeconds: 15,
-			CreativeID:      "creative-3",
-		},
-	}
-	if err := db.SeedAvailableAds(availableAds); err != nil {
-		log.Fatalf("Failed to seed available ads: %v", err)
-	}
-
-	// Initialize Service
-	svc := service.NewAdService(db)
-
-	// Initialize Handlers
-	h := api.NewHandler(svc, db)
-
-	// Create logging middleware
-	loggingMiddleware := api.LoggingMiddleware(db)
+		fatal("Failed to init media storage", "error", err)
+	}
+
+	// Seed the sample creatives on startup so a fresh install has something to traffic
+	sampleCreatives := make([]models.CreativeAsset, len(cfg.Creatives.Seed))
+	for i, seed := range cfg.Creatives.Seed {
+		sampleCreatives[i] = models.CreativeAsset{
+			ID:              seed.ID,
+			Name:            seed.Name,
+			MediaURL:        seed.MediaURL,
+			MimeType:        seed.MimeType,
+			DurationSeconds: seed.DurationSeconds,
+			Status:          models.CreativeStatusActive,
+			CreatedAt:       time.Now(),
+		}
+	}
+	if err := db.SeedCreatives(sampleCreatives); err != nil {
+		fatal("Failed to seed creatives", "error", err)
+	}
+
+	// Initialize Services
+	svc := service.NewAdService(db, service.RateLimit{MaxSeconds: cfg.Serving.RateLimitSeconds, Window: cfg.Serving.RateLimitWindow}, cfg.Serving.TrackingBaseURL)
+	creativeRules := service.DefaultCreativeRules
+	if len(cfg.Creatives.AllowedCodecs) > 0 {
+		creativeRules.AllowedCodecs = cfg.Creatives.AllowedCodecs
+	}
+	creativeSvc := service.NewCreativeService(db, blobs, creativeRules)
+	authSvc := service.NewAuthService(db, cfg.Auth.SessionTTL)
+	deviceSvc := service.NewDeviceService(db, cfg.Auth.DeviceSignatureWindow)
+	tenantSvc := service.NewTenantService(db)
+	reportSvc := service.NewReportService(db)
+
+	// Initialize Handlers. Session cookies are Secure unless running plain HTTP for development.
+	h := api.NewHandler(svc, creativeSvc, authSvc, deviceSvc, tenantSvc, reportSvc, db, cfg.Auth.CookieSecure)
+
+	// Create logging middleware. Configured redaction rules are added to
+	// the defaults, never replace them.
+	logPolicy := reqlog.Policy{Redaction: reqlog.DefaultRedaction, MaxBodyBytes: cfg.Logging.MaxBodyBytes}
+	logPolicy.Redaction.Headers = append(logPolicy.Redaction.Headers, cfg.Logging.RedactHeaders...)
+	logPolicy.Redaction.Fields = append(logPolicy.Redaction.Fields, cfg.Logging.RedactFields...)
+	logPolicy.Redaction.JSONPaths = append(logPolicy.Redaction.JSONPaths, cfg.Logging.RedactJSONPaths...)
+	logPolicy.Sampling, _ = reqlog.ParseSampling(cfg.Logging.SampleRates)
+	// Request logs are written in batches off the request path. Logs that
+	// don't fit in the queue are dropped and counted.
+	logWriter := reqlog.NewWriter(db, cfg.Logging.QueueSize, cfg.Logging.BatchSize, cfg.Logging.FlushInterval)
+	loggingMiddleware := api.LoggingMiddleware(logWriter, logPolicy)
+
+	// OpenTelemetry tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{Exporter: cfg.Tracing.Exporter, SampleRatio: cfg.Tracing.SampleRatio})
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+
+	// Prometheus metrics
+	registry := metrics.NewRegistry()
+	httpMetrics := &api.HTTPMetrics{
+		Requests: registry.NewCounterVec("adserver_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code"),
+		Duration: registry.NewHistogramVec("adserver_http_request_duration_seconds", "HTTP request latency by route.", metrics.DefaultBuckets, "route"),
+	}
+	svc.SetMetrics(&service.ServingMetrics{
+		Responses: registry.NewCounterVec("adserver_vast_responses_total", "VAST responses by result: filled, empty, rate_limited or error.", "result"),
+		AdsServed: registry.NewCounterVec("adserver_ads_served_total", "Ads served by campaign.", "campaign_id"),
+	})
+	queryDuration := registry.NewHistogramVec("adserver_db_query_duration_seconds", "Latency of database queries on the serving and auth paths.", metrics.DefaultBuckets, "query")
+	db.SetQueryObserver(func(query string, d time.Duration) { queryDuration.Observe(d.Seconds(), query) })
+	registry.NewCounterFunc("adserver_request_logs_dropped_total", "Request logs dropped because the log queue was full.", func() float64 { return float64(logWriter.Dropped()) })
+	registry.NewGaugeFunc("adserver_request_logs_queued", "Request logs waiting to be written.", func() float64 { return float64(logWriter.Queued()) })
+
+	// handle registers a route with request metrics labelled by its pattern
+	// and a request ID for log correlation
+	handle := func(pattern string, h http.Handler) {
+		http.Handle(pattern, httpMetrics.Middleware(pattern)(api.TracingMiddleware(pattern)(api.RequestIDMiddleware(h))))
+	}
+
+	// Background jobs run until shutdown closes stopJobs, and close their
+	// channel in jobsDone once they have stopped.
+	// Expire old request logs, archiving them first if LOG_ARCHIVE_DIR is set
+	stopJobs := make(chan struct{})
+	var jobsDone []<-chan struct{}
+	if cfg.Logging.RetentionDays > 0 {
+		retention := &reqlog.Retention{
+			Store:      db,
+			MaxAge:     time.Duration(cfg.Logging.RetentionDays) * 24 * time.Hour,
+			ArchiveDir: cfg.Logging.ArchiveDir,
+			Interval:   time.Hour,
+		}
+		jobsDone = append(jobsDone, retention.Start(stopJobs))
+	}
+
+	// Keep the report rollups up to date. Ad requests are tallied in memory
+	// and written out by the aggregator, not on every /vast response.
+	requestCounts := &rollup.RequestCounts{Store: db}
+	svc.SetRequestCounts(requestCounts)
+	aggregator := &rollup.Aggregator{Store: db, Interval: cfg.Reporting.RollupInterval, Grace: cfg.Reporting.RollupGrace, Requests: requestCounts}
+	jobsDone = append(jobsDone, aggregator.Start(stopJobs))
+
+	authMiddleware := api.AuthMiddleware(authSvc)
 
 	// Routes with logging middleware
-	http.Handle("/login", loggingMiddleware(http.HandlerFunc(h.Login)))
+	handle("/login", loggingMiddleware(http.HandlerFunc(h.Login)))
+	handle("/logout", loggingMiddleware(http.HandlerFunc(h.Logout)))
 
 	// Protected UI Routes
-	http.Handle("/", loggingMiddleware(api.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	handle("/", loggingMiddleware(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
 	})))
-	http.Handle("/campaigns", loggingMiddleware(api.AuthMiddleware(h.ListCampaigns)))
-	http.Handle("/campaigns/create", loggingMiddleware(api.AuthMiddleware(h.CreateCampaign)))
+	handle("/campaigns", loggingMiddleware(authMiddleware(api.Require(api.PermViewCampaigns, h.ListCampaigns))))
+	handle("/campaigns/create", loggingMiddleware(authMiddleware(api.Require(api.PermEditCampaigns, h.CreateCampaign))))
+	handle("/campaigns/templates", loggingMiddleware(authMiddleware(api.RequireByMethod(api.PermViewCampaigns, api.PermEditCampaigns, h.CampaignTemplates))))
+	handle("/campaigns/templates/", loggingMiddleware(authMiddleware(api.Require(api.PermEditCampaigns, h.DeleteCampaignTemplate))))
 
-	// Campaign edit routes (dynamic paths)
-	http.Handle("/campaigns/", loggingMiddleware(api.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	// Campaign edit and history routes (dynamic paths)
+	handle("/campaigns/", loggingMiddleware(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
 		if strings.HasSuffix(path, "/edit") {
-			h.EditCampaign(w, r)
+			api.Require(api.PermEditCampaigns, h.EditCampaign)(w, r)
 		} else if strings.HasSuffix(path, "/update") {
-			h.UpdateCampaign(w, r)
+			api.Require(api.PermEditCampaigns, h.UpdateCampaign)(w, r)
+		} else if strings.HasSuffix(path, "/history") {
+			api.Require(api.PermViewCampaigns, h.CampaignHistory)(w, r)
+		} else if strings.HasSuffix(path, "/rollback") {
+			api.Require(api.PermEditCampaigns, h.RollbackCampaign)(w, r)
+		} else if strings.HasSuffix(path, "/status") {
+			api.Require(api.PermEditCampaigns, h.ChangeCampaignStatus)(w, r)
+		} else if strings.HasSuffix(path, "/delete") {
+			api.Require(api.PermEditCampaigns, h.DeleteCampaign)(w, r)
+		} else if strings.HasSuffix(path, "/clone") {
+			api.Require(api.PermEditCampaigns, h.CloneCampaign)(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
 	})))
 
 	// REST API routes for campaigns
-	http.Handle("/api/campaigns/", loggingMiddleware(api.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	handle("/api/campaigns/", loggingMiddleware(authMiddleware(api.RequireByMethod(api.PermViewCampaigns, api.PermEditCampaigns, func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
+		rest := strings.Trim(strings.TrimPrefix(path, "/api/campaigns/"), "/")
 		// Check if it's a specific campaign ID (not just /api/campaigns)
-		if len(strings.TrimPrefix(path, "/api/campaigns/")) > 0 {
+		if rest == "import" {
+			h.ImportCampaignsAPI(w, r)
+		} else if rest == "export" {
+			h.ExportCampaignsAPI(w, r)
+		} else if strings.Contains(rest, "/ads") {
+			h.CampaignAdsAPI(w, r)
+		} else if strings.Contains(rest, "/revisions") {
+			h.CampaignRevisionsAPI(w, r)
+		} else if strings.HasSuffix(rest, "/clone") {
+			h.CloneCampaignAPI(w, r)
+		} else if strings.Count(rest, "/") == 1 {
+			h.CampaignStatusAPI(w, r)
+		} else if len(rest) > 0 && !strings.Contains(rest, "/") {
 			h.UpdateCampaignAPI(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
-	})))
+	}))))
+
+	campaignTemplatesAPI := api.RequireByMethod(api.PermViewCampaigns, api.PermEditCampaigns, h.CampaignTemplatesAPI)
+	handle("/api/campaign-templates", loggingMiddleware(authMiddleware(campaignTemplatesAPI)))
+	handle("/api/campaign-templates/", loggingMiddleware(authMiddleware(campaignTemplatesAPI)))
+
+	// Creative library
+	handle("/creatives", loggingMiddleware(authMiddleware(api.Require(api.PermViewCreatives, h.ListCreatives))))
+	handle("/creatives/upload", loggingMiddleware(authMiddleware(api.Require(api.PermManageCreatives, h.UploadCreative))))
+	handle("/creatives/", loggingMiddleware(authMiddleware(api.Require(api.PermManageCreatives, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/retire") {
+			h.RetireCreative(w, r)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))))
+	creativesAPI := api.RequireByMethod(api.PermViewCreatives, api.PermManageCreatives, h.CreativesAPI)
+	handle("/api/creatives", loggingMiddleware(authMiddleware(creativesAPI)))
+	handle("/api/creatives/", loggingMiddleware(authMiddleware(creativesAPI)))
+	// Uploaded media is fetched directly by devices, so it is public and not
+	// logged. Only exact blob keys are served; there are no directory listings.
+	handle("/media/", http.StripPrefix("/media/", http.FileServer(blobs.FileSystem())))
+
+	// User administration
+	usersAPI := api.Require(api.PermManageUsers, h.UsersAPI)
+	handle("/api/users", loggingMiddleware(authMiddleware(usersAPI)))
+	handle("/api/users/", loggingMiddleware(authMiddleware(usersAPI)))
+
+	// Agencies and advertisers
+	tenantsAPI := api.Require(api.PermManageTenants, h.TenantsAPI)
+	handle("/api/tenants", loggingMiddleware(authMiddleware(tenantsAPI)))
+	handle("/api/tenants/", loggingMiddleware(authMiddleware(tenantsAPI)))
+
+	// Device credentials for /vast
+	devicesAPI := api.Require(api.PermManageDevices, h.DevicesAPI)
+	handle("/api/devices", loggingMiddleware(authMiddleware(devicesAPI)))
+	handle("/api/devices/", loggingMiddleware(authMiddleware(devicesAPI)))
 
-	http.Handle("/client", loggingMiddleware(api.AuthMiddleware(h.ClientDemo)))
-	// http.Handle("/logs", loggingMiddleware(api.AuthMiddleware(h.ListRequestLogs)))
-	http.Handle("/api/logs", loggingMiddleware(api.AuthMiddleware(h.QueryRequestLogs)))
-	http.Handle("/vast", loggingMiddleware(api.AuthMiddleware(h.ServeAds)))
-	// Public API
-	// http.Handle("/vast", loggingMiddleware(http.HandlerFunc(h.ServeAds)))
+	// Delivery reporting
+	handle("/api/reports/delivery", loggingMiddleware(authMiddleware(api.Require(api.PermViewReports, h.DeliveryReport))))
+	handle("/reports", loggingMiddleware(authMiddleware(api.Require(api.PermViewReports, h.ReportsPage))))
 
-	log.Println("Server starting on :8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	handle("/client", loggingMiddleware(authMiddleware(api.Require(api.PermPreviewAds, h.ClientDemo))))
+	// Client budgets: support can inspect them, admins reset them
+	handle("/clients/budget", loggingMiddleware(authMiddleware(api.Require(api.PermViewBudgets, h.ClientBudgetPage))))
+	handle("/clients/budget/reset", loggingMiddleware(authMiddleware(api.Require(api.PermResetBudgets, h.ResetClientBudget))))
+	handle("/api/clients/", loggingMiddleware(authMiddleware(api.RequireByMethod(api.PermViewBudgets, api.PermResetBudgets, h.ClientsAPI))))
+	// http.Handle("/logs", loggingMiddleware(authMiddleware(h.ListRequestLogs)))
+	handle("/api/logs", loggingMiddleware(authMiddleware(api.Require(api.PermViewLogs, h.QueryRequestLogs))))
+	// Probes for Docker and orchestrators; not logged and open to all
+	health := api.NewHealth(db, api.BuildInfo{Commit: commit, BuildTime: buildTime})
+	http.HandleFunc("/healthz", health.Healthz)
+	http.HandleFunc("/readyz", health.Readyz)
+	http.HandleFunc("/version", health.Version)
+	// Scraped by Prometheus; not logged, and open unless METRICS_TOKEN is set
+	http.Handle("/metrics", api.MetricsHandler(registry, cfg.Metrics.Token))
+	// Ad requests: devices authenticate with API keys or signed URLs, users of the Client Demo with their session
+	handle("/vast", loggingMiddleware(api.VASTAuthMiddleware(deviceSvc, authSvc)(h.ServeAds)))
+	// Tracking pixels fired by players, linked from VAST responses; no credentials
+	handle("/vast/events", loggingMiddleware(http.HandlerFunc(h.TrackEvent)))
+
+	var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if cfg.Server.TLS() {
+		certs, err := tlsreload.New(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		if err != nil {
+			fatal("Failed to load TLS certificate", "error", err)
+		}
+		getCertificate = certs.GetCertificate
 	}
+	srv := newServer(cfg.Server, http.DefaultServeMux, getCertificate)
+	// Once connections have drained, stop the jobs, wait for a refresh or
+	// archive in progress so it isn't cut off by the database closing, and
+	// write out what the last requests queued
+	srv.onShutdown("background jobs", func(ctx context.Context) error {
+		close(stopJobs)
+		for _, done := range jobsDone {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return fmt.Errorf("jobs still running: %w", ctx.Err())
+			}
+		}
+		return nil
+	})
+	srv.onShutdown("ad request counts", func(context.Context) error { return requestCounts.Flush() })
+	srv.onShutdown("request logs", func(context.Context) error {
+		logWriter.Close()
+		return nil
+	})
+	srv.onShutdown("traces", shutdownTracing)
+	srv.onShutdown("database", func(context.Context) error { return db.Close() })
+
+	ln, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		fatal("Failed to listen", "addr", cfg.Server.Addr, "error", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A second signal kills the process without waiting
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	slog.Info("Server starting", "addr", cfg.Server.Addr, "tls", cfg.Server.TLS())
+	if err := srv.run(ctx, ln); err != nil {
+		fatal("Server failed", "error", err)
+	}
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"rockbot-adserver/internal/config"
+	"time"
+)
+
+// flushTimeout bounds each shutdown step
+const flushTimeout = 5 * time.Second
+
+// shutdownStep is background work that is finished, in order, once the
+// server's connections have drained, e.g. writing out queued request logs
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// server is the HTTP server together with what has to be flushed when it
+// stops
+type server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+	steps           []shutdownStep
+}
+
+// newServer configures an HTTP server from cfg for handler, serving HTTPS
+// with certificates from getCertificate when it is set
+func newServer(cfg config.Server, handler http.Handler, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		Protocols:         new(http.Protocols),
+	}
+	// HTTP/2 is negotiated over TLS; plain HTTP stays HTTP/1.1
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	if getCertificate != nil {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: getCertificate}
+	}
+	return &server{http: srv, shutdownTimeout: cfg.ShutdownTimeout}
+}
+
+// onShutdown adds a step to run after the connections have drained
+func (s *server) onShutdown(name string, run func(ctx context.Context) error) {
+	s.steps = append(s.steps, shutdownStep{name: name, run: run})
+}
+
+// run serves on ln until ctx is done. It then stops accepting connections,
+// gives in-flight requests, and the impressions they record, up to the
+// shutdown timeout to finish, and runs the shutdown steps. It only returns
+// an error if serving fails before ctx is done.
+func (s *server) run(ctx context.Context, ln net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.http.TLSConfig != nil {
+			serveErr <- s.http.ServeTLS(ln, "", "")
+		} else {
+			serveErr <- s.http.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining connections", "timeout", s.shutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(drainCtx); err != nil {
+		slog.Error("Connections did not drain in time", "error", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Server failed while shutting down", "error", err)
+	}
+
+	for _, step := range s.steps {
+		stepCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		if err := step.run(stepCtx); err != nil {
+			slog.Error("Shutdown step failed", "step", step.name, "error", err)
+		}
+		cancel()
+	}
+	slog.Info("Shutdown complete")
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"rockbot-adserver/internal/api"
+	"rockbot-adserver/internal/config"
+	"rockbot-adserver/internal/reqlog"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer runs srv on a local port until the returned cancel is called.
+// run's result arrives on the channel once it returns.
+func startServer(t *testing.T, srv *server) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.run(ctx, ln) }()
+	return "http://" + ln.Addr().String(), cancel, done
+}
+
+type response struct {
+	status int
+	body   string
+	err    error
+}
+
+func get(url string) <-chan response {
+	out := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			out <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		out <- response{status: resp.StatusCode, body: string(body), err: err}
+	}()
+	return out
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	db, err := store.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only Close writes logs out: the flush interval never passes
+	logWriter := reqlog.NewWriter(db, 100, 100, time.Hour)
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	body := strings.Repeat("<VAST/>", 10000)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, body)
+		finished.Store(true)
+	})
+	handler := api.LoggingMiddleware(logWriter, reqlog.Policy{MaxBodyBytes: 100})(slow)
+
+	srv := newServer(config.Server{ShutdownTimeout: 5 * time.Second}, handler, nil)
+	var steps []string
+	srv.onShutdown("request logs", func(context.Context) error {
+		if !finished.Load() {
+			t.Error("request logs flushed before the in-flight request finished")
+		}
+		logWriter.Close()
+		steps = append(steps, "request logs")
+		return nil
+	})
+	srv.onShutdown("other writer", func(context.Context) error {
+		steps = append(steps, "other writer")
+		return nil
+	})
+
+	url, cancel, done := startServer(t, srv)
+	resp := get(url + "/vast")
+	<-started
+	cancel()
+
+	select {
+	case r := <-resp:
+		if r.err != nil {
+			t.Fatalf("in-flight request failed: %v", r.err)
+		}
+		if r.status != http.StatusOK || r.body != body {
+			t.Errorf("got status %d and %d bytes, want 200 and %d bytes", r.status, len(r.body), len(body))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request never completed")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if strings.Join(steps, ", ") != "request logs, other writer" {
+		t.Errorf("shutdown steps ran as %v", steps)
+	}
+	logs, err := db.GetRequestLogs(10, 0, "", "/vast", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].ResponseStatus != http.StatusOK {
+		t.Errorf("got request logs %+v, want the one /vast request", logs)
+	}
+	if _, err := http.Get(url + "/vast"); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+	db.Close()
+}
+
+func TestShutdownFlushesWhenDrainTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	stuck := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	srv := newServer(config.Server{ShutdownTimeout: 100 * time.Millisecond}, stuck, nil)
+	var flushed atomic.Bool
+	srv.onShutdown("request logs", func(context.Context) error {
+		flushed.Store(true)
+		return nil
+	})
+
+	url, cancel, done := startServer(t, srv)
+	get(url)
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run waited past the shutdown timeout")
+	}
+	if !flushed.Load() {
+		t.Error("shutdown steps did not run after the drain timed out")
+	}
+}
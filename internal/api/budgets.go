@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"time"
+)
+
+// ClientsAPI lets support see and reset clients' rate-limit budgets:
+// GET /api/clients/{id}/budget and POST /api/clients/{id}/budget/reset,
+// the latter with an optional {"reason": "..."}
+func (h *Handler) ClientsAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 4 || pathParts[0] != "api" || pathParts[1] != "clients" || pathParts[3] != "budget" {
+		http.NotFound(w, r)
+		return
+	}
+	clientID := pathParts[2]
+
+	switch {
+	case len(pathParts) == 4 && r.Method == "GET":
+		budget, err := h.service.ClientBudget(clientID, time.Now())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, budget)
+	case len(pathParts) == 5 && pathParts[4] == "reset" && r.Method == "POST":
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		reset, err := h.service.ResetClientBudget(clientID, req.Reason, UserFromContext(r.Context()), time.Now())
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, reset)
+	case len(pathParts) == 4 || (len(pathParts) == 5 && pathParts[4] == "reset"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// clientBudgetPageData is what client_budget.html renders
+type clientBudgetPageData struct {
+	ClientID      string
+	Budget        *models.ClientBudget
+	CampaignNames map[string]string
+	Errors        []string
+}
+
+// ClientBudgetPage shows a client's budget for support, looked up by
+// ?client_id=
+func (h *Handler) ClientBudgetPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderClientBudgetPage(w, r, http.StatusOK, strings.TrimSpace(r.URL.Query().Get("client_id")), nil)
+}
+
+// ResetClientBudget handles the reset form on the client budget page
+func (h *Handler) ResetClientBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := strings.TrimSpace(r.FormValue("client_id"))
+	_, err := h.service.ResetClientBudget(clientID, r.FormValue("reason"), UserFromContext(r.Context()), time.Now())
+	if err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		h.renderClientBudgetPage(w, r, http.StatusBadRequest, clientID, errs)
+		return
+	}
+	http.Redirect(w, r, "/clients/budget?"+url.Values{"client_id": {clientID}}.Encode(), http.StatusSeeOther)
+}
+
+func (h *Handler) renderClientBudgetPage(w http.ResponseWriter, r *http.Request, status int, clientID string, errs []string) {
+	data := clientBudgetPageData{ClientID: clientID, Errors: errs}
+	if clientID != "" {
+		var err error
+		if data.Budget, err = h.service.ClientBudget(clientID, time.Now()); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		if data.CampaignNames, err = h.service.CampaignNames(h.scope(r)); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
+	tmpl := h.pageTemplate(r, "client_budget.html")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"rockbot-adserver/internal/service"
+	"strings"
+)
+
+// maxImportBytes caps the size of a bulk campaign import
+const maxImportBytes = 10 << 20
+
+// ImportCampaignsAPI serves POST /api/campaigns/import. The body is CSV or
+// JSON, chosen by format=csv|json or else the Content-Type. With
+// dry_run=true every row is checked and nothing is written. The response
+// lists per-row errors; a real import with any errors writes nothing and
+// answers 400.
+func (h *Handler) ImportCampaignsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = service.CampaignFormatJSON
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = service.CampaignFormatCSV
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	result, err := h.service.ImportCampaigns(body, format, dryRun, h.scope(r), UserFromContext(r.Context()))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	if !dryRun && len(result.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, result)
+}
+
+// ExportCampaignsAPI serves GET /api/campaigns/export?format=json|csv with
+// every campaign in scope, in the format the import reads
+func (h *Handler) ExportCampaignsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = service.CampaignFormatJSON
+	case service.CampaignFormatJSON, service.CampaignFormatCSV:
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	records, err := h.service.ExportCampaigns(h.scope(r))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if format == service.CampaignFormatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="campaigns.csv"`)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	service.WriteCampaignRecords(w, format, records)
+}
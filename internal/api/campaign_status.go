@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"rockbot-adserver/internal/service"
+	"strings"
+)
+
+// CampaignStatusAPI applies a lifecycle action to a campaign:
+// POST /api/campaigns/{id}/{schedule|pause|resume|archive|unarchive}
+func (h *Handler) CampaignStatusAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 4 || pathParts[0] != "api" || pathParts[1] != "campaigns" || !service.IsStatusAction(pathParts[3]) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	campaign, err := h.service.ChangeCampaignStatus(pathParts[2], pathParts[3], h.scope(r), UserFromContext(r.Context()))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaign)
+}
+
+// ChangeCampaignStatus handles the lifecycle buttons on the campaigns page
+// (POST /campaigns/{id}/status with an action field)
+func (h *Handler) ChangeCampaignStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "campaigns" || pathParts[2] != "status" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.ChangeCampaignStatus(pathParts[1], r.FormValue("action"), h.scope(r), UserFromContext(r.Context())); err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		h.renderCampaignPage(w, r, http.StatusBadRequest, nil, nil, errs)
+		return
+	}
+	http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloneCampaignAPI serves POST /api/campaigns/{id}/clone with an optional
+// {"name": "..."} and answers with the new draft
+func (h *Handler) CloneCampaignAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 4 || pathParts[0] != "api" || pathParts[1] != "campaigns" || pathParts[3] != "clone" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	clone, err := h.service.CloneCampaign(pathParts[2], req.Name, h.scope(r), UserFromContext(r.Context()))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, clone)
+}
+
+// CloneCampaign handles the clone button on the campaigns page
+// (POST /campaigns/{id}/clone) and opens the new draft for editing
+func (h *Handler) CloneCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "campaigns" || pathParts[2] != "clone" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	clone, err := h.service.CloneCampaign(pathParts[1], "", h.scope(r), UserFromContext(r.Context()))
+	if err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		h.renderCampaignPage(w, r, http.StatusBadRequest, nil, nil, errs)
+		return
+	}
+	http.Redirect(w, r, "/campaigns/"+clone.ID+"/edit", http.StatusSeeOther)
+}
+
+// CampaignTemplatesAPI manages templates: GET/POST /api/campaign-templates
+// and GET/DELETE /api/campaign-templates/{id}
+func (h *Handler) CampaignTemplatesAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "api" || pathParts[1] != "campaign-templates" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	scope := h.scope(r)
+
+	switch {
+	case len(pathParts) == 2 && r.Method == "GET":
+		templates, err := h.service.ListCampaignTemplates(scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, templates)
+	case len(pathParts) == 2 && r.Method == "POST":
+		var t models.CampaignTemplate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		created, err := h.service.CreateCampaignTemplate(t, scope, UserFromContext(r.Context()))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	case len(pathParts) == 3 && r.Method == "GET":
+		t, err := h.service.GetCampaignTemplate(pathParts[2], scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	case len(pathParts) == 3 && r.Method == "DELETE":
+		if err := h.service.DeleteCampaignTemplate(pathParts[2], scope); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(pathParts) <= 3:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// campaignTemplatesPageData is what campaign_templates.html renders
+type campaignTemplatesPageData struct {
+	Templates    []models.CampaignTemplate
+	Form         templateForm
+	Errors       []string
+	Advertisers  []models.Tenant
+	TenantNames  map[string]string
+	Creatives    []models.CreativeAsset
+	HouseAllowed bool
+}
+
+// templateForm holds the template form fields as submitted
+type templateForm struct {
+	Name           string
+	DurationDays   string
+	TargetDMA      string
+	TenantID       string
+	CreativeID     string
+	ImpressionGoal string
+}
+
+// parse converts the form into a template, collecting fields that don't parse
+func (f templateForm) parse() (models.CampaignTemplate, []string) {
+	var errs []string
+	t := models.CampaignTemplate{Name: f.Name, TargetDMA: f.TargetDMA, TenantID: f.TenantID, CreativeID: f.CreativeID}
+	var err error
+	if t.DurationDays, err = strconv.Atoi(f.DurationDays); err != nil {
+		errs = append(errs, "Flight length must be a whole number of days")
+	}
+	if f.ImpressionGoal != "" {
+		if t.ImpressionGoal, err = strconv.Atoi(f.ImpressionGoal); err != nil {
+			errs = append(errs, "Impression goal must be a whole number")
+		}
+	}
+	return t, errs
+}
+
+// CampaignTemplates serves the templates page: GET lists them, POST saves a
+// new one from the form
+func (h *Handler) CampaignTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.renderCampaignTemplatesPage(w, r, http.StatusOK, templateForm{DurationDays: "30", TargetDMA: "*"}, nil)
+	case "POST":
+		form := templateForm{
+			Name:           strings.TrimSpace(r.FormValue("name")),
+			DurationDays:   strings.TrimSpace(r.FormValue("duration_days")),
+			TargetDMA:      strings.TrimSpace(r.FormValue("target_dma")),
+			TenantID:       r.FormValue("tenant_id"),
+			CreativeID:     r.FormValue("creative_id"),
+			ImpressionGoal: strings.TrimSpace(r.FormValue("impression_goal")),
+		}
+		t, errs := form.parse()
+		if len(errs) == 0 {
+			if _, err := h.service.CreateCampaignTemplate(t, h.scope(r), UserFromContext(r.Context())); err != nil {
+				var ok bool
+				if errs, ok = formErrors(err); !ok {
+					writeServiceError(w, err)
+					return
+				}
+			}
+		}
+		if len(errs) > 0 {
+			h.renderCampaignTemplatesPage(w, r, http.StatusBadRequest, form, errs)
+			return
+		}
+		http.Redirect(w, r, "/campaigns/templates", http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeleteCampaignTemplate handles the delete buttons on the templates page
+// (POST /campaigns/templates/{id}/delete)
+func (h *Handler) DeleteCampaignTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 4 || pathParts[1] != "templates" || pathParts[3] != "delete" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := h.service.DeleteCampaignTemplate(pathParts[2], h.scope(r)); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/campaigns/templates", http.StatusSeeOther)
+}
+
+func (h *Handler) renderCampaignTemplatesPage(w http.ResponseWriter, r *http.Request, status int, form templateForm, errs []string) {
+	scope := h.scope(r)
+	data := campaignTemplatesPageData{Form: form, Errors: errs, HouseAllowed: scope.All}
+	var err error
+	if data.Templates, err = h.service.ListCampaignTemplates(scope); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if data.Advertisers, err = h.tenants.Advertisers(scope); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if data.TenantNames, err = h.tenantNames(scope); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if data.Creatives, err = h.creatives.List(false, scope); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	tmpl := h.pageTemplate(r, "campaign_templates.html")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// campaignFormFromTemplate pre-fills the create form from a template, with
+// the flight starting at the next midnight UTC
+func campaignFormFromTemplate(t *models.CampaignTemplate, now time.Time) campaignForm {
+	start := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	form := campaignForm{
+		StartTime:  start.Format(formTimeLayout),
+		EndTime:    start.AddDate(0, 0, t.DurationDays).Format(formTimeLayout),
+		TargetDMA:  t.TargetDMA,
+		TenantID:   t.TenantID,
+		CreativeID: t.CreativeID,
+	}
+	if t.ImpressionGoal > 0 {
+		form.ImpressionGoal = strconv.Itoa(t.ImpressionGoal)
+	}
+	return form
+}
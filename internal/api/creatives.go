@@ -0,0 +1,210 @@
+package api
+
+import (
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/service"
+	"strconv"
+	"strings"
+)
+
+// maxCreativeUploadBytes caps the size of a single creative upload
+const maxCreativeUploadBytes = 512 << 20
+
+// parseCreativeUpload reads a multipart creative upload. A "file" part is
+// stored in the blob backend; without one, "media_url" registers an external file.
+func parseCreativeUpload(w http.ResponseWriter, r *http.Request) (service.CreativeUpload, func(), error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCreativeUploadBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		return service.CreativeUpload{}, func() {}, &service.ValidationError{Msg: "Invalid upload: " + err.Error()}
+	}
+
+	// duration_seconds may be omitted; it is read from the file
+	var duration int
+	if v := r.FormValue("duration_seconds"); v != "" {
+		var err error
+		if duration, err = strconv.Atoi(v); err != nil {
+			return service.CreativeUpload{}, func() {}, &service.ValidationError{Msg: "duration_seconds must be a number"}
+		}
+	}
+
+	upload := service.CreativeUpload{
+		Name:            r.FormValue("name"),
+		DurationSeconds: duration,
+		MediaURL:        r.FormValue("media_url"),
+		TenantID:        r.FormValue("tenant_id"),
+	}
+	cleanup := func() {}
+	if file, header, err := r.FormFile("file"); err == nil {
+		upload.File = file
+		upload.Filename = header.Filename
+		cleanup = func() { file.Close() }
+	}
+	return upload, cleanup, nil
+}
+
+// creativeForm holds the upload form fields as submitted. The file itself
+// can't be kept, so it has to be chosen again after an error.
+type creativeForm struct {
+	Name            string
+	DurationSeconds string
+	MediaURL        string
+	TenantID        string
+}
+
+// ListCreatives renders the creative library page
+func (h *Handler) ListCreatives(w http.ResponseWriter, r *http.Request) {
+	h.renderCreativesPage(w, r, http.StatusOK, creativeForm{}, nil)
+}
+
+// renderCreativesPage shows the library and the upload form, filled with
+// form and errs after a rejected upload
+func (h *Handler) renderCreativesPage(w http.ResponseWriter, r *http.Request, status int, form creativeForm, errs []string) {
+	showRetired := r.URL.Query().Get("show") == "all"
+	scope := h.scope(r)
+	creatives, err := h.creatives.List(showRetired, scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	advertisers, err := h.tenants.Advertisers(scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tenantNames, err := h.tenantNames(scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Creatives    []models.CreativeAsset
+		ShowRetired  bool
+		Advertisers  []models.Tenant
+		TenantNames  map[string]string
+		HouseAllowed bool
+		Form         creativeForm
+		Errors       []string
+	}{
+		Creatives:    creatives,
+		ShowRetired:  showRetired,
+		Advertisers:  advertisers,
+		TenantNames:  tenantNames,
+		HouseAllowed: scope.All,
+		Form:         form,
+		Errors:       errs,
+	}
+
+	tmpl := h.pageTemplate(r, "creatives.html")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// UploadCreative handles the creative upload form submission. Rejected
+// uploads re-render the form with the errors.
+func (h *Handler) UploadCreative(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	upload, cleanup, err := parseCreativeUpload(w, r)
+	defer cleanup()
+	if err == nil {
+		_, err = h.creatives.Upload(upload, h.scope(r))
+	}
+	if err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		form := creativeForm{
+			Name:            r.FormValue("name"),
+			DurationSeconds: r.FormValue("duration_seconds"),
+			MediaURL:        r.FormValue("media_url"),
+			TenantID:        r.FormValue("tenant_id"),
+		}
+		h.renderCreativesPage(w, r, http.StatusBadRequest, form, errs)
+		return
+	}
+
+	http.Redirect(w, r, "/creatives", http.StatusSeeOther)
+}
+
+// RetireCreative handles the retire button on the creative library page
+func (h *Handler) RetireCreative(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract creative ID from path (e.g., /creatives/123/retire)
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "creatives" || pathParts[2] != "retire" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.creatives.Retire(pathParts[1], h.scope(r)); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/creatives", http.StatusSeeOther)
+}
+
+// CreativesAPI serves the creative library as JSON:
+// GET/POST /api/creatives, GET /api/creatives/{id} and POST /api/creatives/{id}/retire
+func (h *Handler) CreativesAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "api" || pathParts[1] != "creatives" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	scope := h.scope(r)
+
+	switch {
+	case len(pathParts) == 2 && r.Method == "GET":
+		creatives, err := h.creatives.List(r.URL.Query().Get("include_retired") == "true", scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if creatives == nil {
+			creatives = []models.CreativeAsset{}
+		}
+		writeJSON(w, http.StatusOK, creatives)
+	case len(pathParts) == 2 && r.Method == "POST":
+		upload, cleanup, err := parseCreativeUpload(w, r)
+		defer cleanup()
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		creative, err := h.creatives.Upload(upload, scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, creative)
+	case len(pathParts) == 3 && r.Method == "GET":
+		creative, err := h.creatives.Get(pathParts[2], scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, creative)
+	case len(pathParts) == 4 && pathParts[3] == "retire" && r.Method == "POST":
+		if err := h.creatives.Retire(pathParts[2], scope); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(pathParts) <= 4:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/service"
+	"strings"
+	"time"
+)
+
+const deviceContextKey contextKey = "device"
+
+// DeviceFromContext returns the device authenticated by VASTAuthMiddleware,
+// or nil when the request came from a logged-in user previewing ads
+func DeviceFromContext(ctx context.Context) *models.Device {
+	device, _ := ctx.Value(deviceContextKey).(*models.Device)
+	return device
+}
+
+// VASTAuthMiddleware authenticates ad requests. Devices send a bearer API key
+// or an HMAC-signed query string; without either, a logged-in user with the
+// ads:preview permission (the Client Demo page) is let through.
+func VASTAuthMiddleware(devices *service.DeviceService, auth *service.AuthService) func(http.HandlerFunc) http.HandlerFunc {
+	sessionAuth := AuthMiddleware(auth)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		preview := sessionAuth(Require(PermPreviewAds, next))
+		return func(w http.ResponseWriter, r *http.Request) {
+			var device *models.Device
+			var err error
+			switch {
+			case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "):
+				device, err = devices.AuthenticateKey(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+			case r.URL.Query().Get("sig") != "":
+				device, err = devices.VerifySignature(r.Method, r.URL.Path, r.URL.Query(), time.Now())
+			default:
+				// No device credentials: only a browser session can get in
+				if _, err := r.Cookie(sessionCookieName); err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				preview(w, r)
+				return
+			}
+
+			if err != nil {
+				if !errors.Is(err, service.ErrDeviceUnauthorized) {
+					slog.ErrorContext(r.Context(), "Failed to check device credentials", "error", err)
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), deviceContextKey, device)))
+		}
+	}
+}
+
+// DevicesAPI lets admins issue and revoke device credentials:
+// GET/POST /api/devices and POST /api/devices/{id}/revoke
+func (h *Handler) DevicesAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "api" || pathParts[1] != "devices" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(pathParts) == 2 && r.Method == "GET":
+		devices, err := h.devices.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if devices == nil {
+			devices = []models.Device{}
+		}
+		writeJSON(w, http.StatusOK, devices)
+	case len(pathParts) == 2 && r.Method == "POST":
+		var req struct {
+			Name     string `json:"name"`
+			Venue    string `json:"venue"`
+			ClientID string `json:"client_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		device, err := h.devices.Issue(req.Name, req.Venue, req.ClientID)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		// The secret is only ever shown in this response
+		writeJSON(w, http.StatusCreated, struct {
+			*models.Device
+			Secret string `json:"secret"`
+			APIKey string `json:"api_key"`
+		}{
+			Device: device,
+			Secret: device.Secret,
+			APIKey: device.ID + "." + device.Secret,
+		})
+	case len(pathParts) == 4 && pathParts[3] == "revoke" && r.Method == "POST":
+		if err := h.devices.Revoke(pathParts[2]); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(pathParts) <= 4:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
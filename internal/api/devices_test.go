@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/service"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVASTPreviewIsPlatformOnly(t *testing.T) {
+	e := newTestEnv(t)
+	acme := e.advertiser(t, "Acme")
+	globex := e.advertiser(t, "Globex")
+	e.liveCampaign(t, "acme-campaign", acme)
+	e.liveCampaign(t, "globex-campaign", globex)
+	vast := VASTAuthMiddleware(e.devices, e.auth)(e.h.ServeAds)
+
+	serve := func(cookie *http.Cookie, clientID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/vast?dma=501&client_id="+clientID, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		vast(rec, req)
+		return rec
+	}
+
+	tenantUser := e.login(t, "acme-trafficker", models.RoleTrafficker, acme)
+	rec := serve(tenantUser, "lobby")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("tenant trafficker previewing ads: got %d, want 403", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "globex") {
+		t.Error("tenant trafficker was served another advertiser's ad")
+	}
+	used, err := e.db.GetClientImpressionsDuration(context.Background(), "lobby", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 0 {
+		t.Errorf("tenant preview recorded %ds of impressions against the client", used)
+	}
+
+	platformUser := e.login(t, "trafficker", models.RoleTrafficker, "")
+	rec = serve(platformUser, "demo")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("platform trafficker previewing ads: got %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "<Ad ") {
+		t.Errorf("platform trafficker got no ad:\n%s", rec.Body.String())
+	}
+}
+
+func TestVASTDeviceAuth(t *testing.T) {
+	e := newTestEnv(t)
+	e.liveCampaign(t, "house", "")
+	d, err := e.devices.Issue("Lobby screen", "Store 12", "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vast := VASTAuthMiddleware(e.devices, e.auth)(e.h.ServeAds)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		vast(rec, req)
+		return rec
+	}
+	bearer := func(target, key string) *http.Request {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		return req
+	}
+
+	if rec := serve(httptest.NewRequest("GET", "/vast?client_id=lobby", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no credentials: got %d, want 401", rec.Code)
+	}
+	if rec := serve(bearer("/vast?dma=501", d.ID+".wrong")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong key: got %d, want 401", rec.Code)
+	}
+	// The device can't dodge its client's budget with another client_id
+	if rec := serve(bearer("/vast?dma=501&client_id=elsewhere", d.ID+"."+d.Secret)); rec.Code != http.StatusForbidden {
+		t.Errorf("spoofed client_id: got %d, want 403", rec.Code)
+	}
+	if rec := serve(bearer("/vast?dma=501", d.ID+"."+d.Secret)); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<Ad ") {
+		t.Errorf("bearer key: got %d\n%s", rec.Code, rec.Body.String())
+	}
+
+	q := url.Values{"device_id": {d.ID}, "ts": {strconv.FormatInt(time.Now().Unix(), 10)}, "dma": {"501"}}
+	q.Set("sig", service.SignRequest(d.Secret, "GET", "/vast", q))
+	if rec := serve(httptest.NewRequest("GET", "/vast?"+q.Encode(), nil)); rec.Code != http.StatusOK {
+		t.Errorf("signed URL: got %d, want 200", rec.Code)
+	}
+	if rec := serve(httptest.NewRequest("GET", "/vast?"+q.Encode(), nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed signed URL: got %d, want 401", rec.Code)
+	}
+
+	// Both ads were charged to the device's client
+	used, err := e.db.GetClientImpressionsDuration(context.Background(), "lobby", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 30 {
+		t.Errorf("lobby used %ds, want the 30s of the two ads served", used)
+	}
+}
@@ -2,12 +2,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"rockbot-adserver/internal/logging"
 	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/reqlog"
 	"rockbot-adserver/internal/service"
 	"rockbot-adserver/internal/store"
 	"strconv"
@@ -15,15 +19,46 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Handler struct {
-	service *service.AdService
-	store   *store.Store
+	service       *service.AdService
+	creatives     *service.CreativeService
+	auth          *service.AuthService
+	devices       *service.DeviceService
+	tenants       *service.TenantService
+	reports       *service.ReportService
+	store         *store.Store
+	secureCookies bool
 }
 
-func NewHandler(s *service.AdService, st *store.Store) *Handler {
-	return &Handler{service: s, store: st}
+func NewHandler(s *service.AdService, cs *service.CreativeService, auth *service.AuthService, ds *service.DeviceService, ts *service.TenantService, rs *service.ReportService, st *store.Store, secureCookies bool) *Handler {
+	return &Handler{service: s, creatives: cs, auth: auth, devices: ds, tenants: ts, reports: rs, store: st, secureCookies: secureCookies}
+}
+
+// sessionCookieName is the cookie holding the session token
+const sessionCookieName = "session_token"
+
+// csrfFieldName is the hidden form field carrying the CSRF token; scripts may
+// send it in the csrfHeaderName header instead
+const (
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// maxFormBytes caps form bodies read while checking CSRF tokens. It matches
+// the creative upload limit, the largest form we accept.
+const maxFormBytes = maxCreativeUploadBytes
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// UserFromContext returns the logged-in user attached by AuthMiddleware
+func UserFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userContextKey).(*models.User)
+	return user
 }
 
 // responseWriter wraps http.ResponseWriter to capture response body and status
@@ -51,24 +86,65 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
-// LoggingMiddleware captures and logs all requests and responses
-func LoggingMiddleware(store *store.Store) func(http.Handler) http.Handler {
+// requestIDHeader carries the request ID in from proxies and back to clients
+const requestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware gives every request an ID, taken from X-Request-ID
+// when a proxy already assigned one, and echoes it in the response. Log
+// lines written with the request's context carry it, and so does the
+// request log.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts IDs of up to 128 printable ASCII characters, so a
+// client can't inject log lines or bloat every record
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// LoggingMiddleware captures and logs requests and responses. The policy
+// decides which requests are sampled and scrubs credentials before storage;
+// the writer saves logs in the background.
+func LoggingMiddleware(writer *reqlog.Writer, policy reqlog.Policy) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !policy.Sampling.Sampled(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
 			startTime := time.Now()
 
-			// Capture request body
+			// Capture request body. Creative uploads are skipped: they are
+			// large binary files and are only truncated afterwards anyway.
 			var requestBodyBytes []byte
-			if r.Body != nil {
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				requestBodyBytes = []byte("[multipart body omitted]")
+			} else if r.Body != nil {
 				requestBodyBytes, _ = io.ReadAll(r.Body)
 				r.Body = io.NopCloser(bytes.NewBuffer(requestBodyBytes))
 			}
 
-			// Capture request headers
-			requestHeadersBytes, _ := json.Marshal(r.Header)
+			// Capture request headers, minus credentials
+			requestHeadersBytes, _ := json.Marshal(policy.Redaction.Header(r.Header))
 
 			// Capture query parameters
-			queryParams := r.URL.RawQuery
+			queryParams := policy.Redaction.Query(r.URL.RawQuery)
 
 			// Wrap response writer to capture response
 			rw := newResponseWriter(w)
@@ -80,17 +156,17 @@ func LoggingMiddleware(store *store.Store) func(http.Handler) http.Handler {
 			duration := time.Since(startTime)
 
 			// Capture response body (limit size to avoid storing huge responses)
-			responseBody := rw.body.String()
-			maxBodySize := 10000 // 10KB limit
+			responseBody := policy.Redaction.Body(rw.Header().Get("Content-Type"), rw.body.Bytes())
+			maxBodySize := policy.MaxBodyBytes
 			if len(responseBody) > maxBodySize {
 				responseBody = responseBody[:maxBodySize] + "... [truncated]"
 			}
 
 			// Capture response headers
-			responseHeadersBytes, _ := json.Marshal(rw.Header())
+			responseHeadersBytes, _ := json.Marshal(policy.Redaction.Header(rw.Header()))
 
 			// Limit request body size as well
-			requestBody := string(requestBodyBytes)
+			requestBody := policy.Redaction.Body(r.Header.Get("Content-Type"), requestBodyBytes)
 			if len(requestBody) > maxBodySize {
 				requestBody = requestBody[:maxBodySize] + "... [truncated]"
 			}
@@ -98,6 +174,7 @@ func LoggingMiddleware(store *store.Store) func(http.Handler) http.Handler {
 			// Create request log
 			requestLog := models.RequestLog{
 				ID:              uuid.New().String(),
+				RequestID:       logging.RequestID(r.Context()),
 				Method:          r.Method,
 				Path:            r.URL.Path,
 				QueryParams:     queryParams,
@@ -112,136 +189,411 @@ func LoggingMiddleware(store *store.Store) func(http.Handler) http.Handler {
 				UserAgent:       r.UserAgent(),
 			}
 
-			// Save log asynchronously to avoid blocking the response
-			go func() {
-				if err := store.SaveRequestLog(requestLog); err != nil {
-					log.Printf("Failed to save request log: %v", err)
-				}
-			}()
+			// Queue the log; it is dropped if the writer is backed up
+			writer.Enqueue(requestLog)
 		})
 	}
 }
 
-// Middleware for Auth
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil || cookie.Value != "valid-token" {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
+// AuthMiddleware only lets requests with a valid server-side session
+// through. Browsers are sent to the login page; API clients get a 401.
+// State-changing requests must also carry the session's CSRF token.
+func AuthMiddleware(auth *service.AuthService) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var token string
+			if cookie, err := r.Cookie(sessionCookieName); err == nil {
+				token = cookie.Value
+			}
+
+			user, err := auth.Authenticate(token)
+			if err != nil {
+				if !errors.Is(err, service.ErrInvalidSession) {
+					slog.ErrorContext(r.Context(), "Failed to check session", "error", err)
+				}
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+
+			if !checkCSRF(w, r, auth, token) {
+				return
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+		}
+	}
+}
+
+// scope returns the tenants the current user may see. If they can't be
+// looked up the user sees nothing rather than everything.
+func (h *Handler) scope(r *http.Request) models.TenantScope {
+	scope, err := h.tenants.ScopeFor(UserFromContext(r.Context()))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to resolve tenant scope", "error", err)
+		return models.TenantScope{}
+	}
+	return scope
+}
+
+// tenantNames maps the IDs of the tenants in scope to their names, for display
+func (h *Handler) tenantNames(scope models.TenantScope) (map[string]string, error) {
+	tenants, err := h.tenants.List(scope)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(tenants))
+	for _, t := range tenants {
+		names[t.ID] = t.Name
+	}
+	return names, nil
+}
+
+// checkCSRF rejects state-changing requests that don't carry the session's
+// CSRF token and reports whether the request may continue. JSON requests,
+// JSON Merge Patch included, are exempt: browsers won't send them cross-site
+// without a CORS preflight, which this server never approves.
+func checkCSRF(w http.ResponseWriter, r *http.Request, auth *service.AuthService, sessionToken string) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	if isJSON(r.Header.Get("Content-Type")) {
+		return true
+	}
+
+	csrfToken := r.Header.Get(csrfHeaderName)
+	if csrfToken == "" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(32 << 20); err != nil {
+				http.Error(w, "Invalid form: "+err.Error(), http.StatusBadRequest)
+				return false
+			}
 		}
-		next(w, r)
+		csrfToken = r.PostFormValue(csrfFieldName)
 	}
+
+	if !auth.CheckCSRF(sessionToken, csrfToken) {
+		http.Error(w, "Invalid or missing CSRF token. Reload the page and try again.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// isJSON reports whether a content type is JSON or a JSON Merge Patch
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "application/merge-patch+json")
+}
+
+// pageTemplate parses the layout together with one page template. Pages can
+// call {{can "campaigns:edit"}} to hide actions the current user's role
+// doesn't allow, {{currentUser}} to show who is logged in, and
+// {{csrfField}} inside every POST form.
+func (h *Handler) pageTemplate(r *http.Request, page string) *template.Template {
+	user := UserFromContext(r.Context())
+	var csrfToken string
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		csrfToken = h.auth.CSRFToken(cookie.Value)
+	}
+	funcs := template.FuncMap{
+		"can":         func(perm string) bool { return Can(user, Permission(perm)) },
+		"currentUser": func() *models.User { return user },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(csrfToken) + `">`)
+		},
+	}
+	return template.Must(template.New("layout.html").Funcs(funcs).
+		ParseFiles("web/templates/layout.html", "web/templates/"+page))
+}
+
+// setSessionCookie writes the session cookie. It is HttpOnly so scripts
+// can't read it, SameSite=Lax so other sites can't post with it, and
+// Secure unless explicitly disabled for plain-HTTP development.
+func (h *Handler) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+	}
+	http.SetCookie(w, cookie)
 }
 
 // Login Page
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	tmpl := template.Must(template.ParseFiles("web/templates/login.html"))
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("web/templates/login.html"))
 		tmpl.Execute(w, nil)
 		return
 	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
-	// TODO: Implement proper authentication. For now we're using a simple hardcoded username and password.
-	if strings.ToLower(username) == "admin" && strings.ToLower(password) == "admin" {
-		http.SetCookie(w, &http.Cookie{
-			Name:  "session_token",
-			Value: "valid-token",
-			Path:  "/",
+	token, sess, err := h.auth.Login(username, password)
+	if err != nil {
+		if !errors.Is(err, service.ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		tmpl.Execute(w, struct {
+			Error    string
+			Username string
+		}{
+			Error:    "Invalid username or password",
+			Username: username,
 		})
-		http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
 		return
 	}
-	http.Error(w, "Invalid Credentials", http.StatusUnauthorized)
+
+	h.setSessionCookie(w, token, sess.ExpiresAt)
+	http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
 }
 
-// Campaign UI
-func (h *Handler) ListCampaigns(w http.ResponseWriter, r *http.Request) {
-	campaigns, err := h.service.ListCampaigns()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// Logout ends the current session and clears the cookie
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	availableAds, err := h.service.GetAvailableAds()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if !checkCSRF(w, r, h.auth, cookie.Value) {
+			return
+		}
+		if err := h.auth.Logout(cookie.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	h.setSessionCookie(w, "", time.Time{})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
 
-	data := struct {
-		Campaign        *models.Campaign
-		Campaigns       []models.Campaign
-		AvailableAds    []models.Ad
-		CurrentMediaURL string
-	}{
-		Campaign:        nil,
-		Campaigns:       campaigns,
-		AvailableAds:    availableAds,
-		CurrentMediaURL: "",
+// campaignPageData is what campaigns.html renders: the campaign table with
+// delivery totals, plus the create or edit form
+type campaignPageData struct {
+	Campaign     *models.Campaign
+	Campaigns    []models.Campaign
+	Creatives    []models.CreativeAsset
+	Form         campaignForm
+	Errors       []string
+	Advertisers  []models.Tenant
+	TenantNames  map[string]string
+	Delivery     map[string]models.CampaignDelivery
+	HouseAllowed bool
+	ShowArchived bool
+	Templates    []models.CampaignTemplate
+	Template     string // ID of the template the create form was filled from
+	// StatusActions lists the lifecycle actions offered for each status
+	StatusActions map[string][]string
+}
+
+// campaignForm holds the campaign form fields as submitted, so a rejected
+// form can be shown again with the user's input intact
+type campaignForm struct {
+	Name           string
+	StartTime      string
+	EndTime        string
+	TargetDMA      string
+	TenantID       string
+	CreativeID     string
+	ImpressionGoal string // blank for campaigns without a goal
+	Draft          bool
+}
+
+// formTimeLayout is the format of datetime-local inputs
+const formTimeLayout = "2006-01-02T15:04"
+
+func campaignFormFromRequest(r *http.Request) campaignForm {
+	return campaignForm{
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		StartTime:      r.FormValue("start_time"),
+		EndTime:        r.FormValue("end_time"),
+		TargetDMA:      strings.TrimSpace(r.FormValue("target_dma")),
+		TenantID:       r.FormValue("tenant_id"),
+		CreativeID:     r.FormValue("creative_id"),
+		ImpressionGoal: strings.TrimSpace(r.FormValue("impression_goal")),
+		Draft:          r.FormValue("draft") != "",
 	}
+}
 
-	log.Println("data.Campaigns", data.Campaigns)
-	tmpl := template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/campaigns.html"))
-	tmpl.Execute(w, data)
+func campaignFormFromCampaign(c *models.Campaign) campaignForm {
+	form := campaignForm{
+		Name:      c.Name,
+		StartTime: c.StartTime.Format(formTimeLayout),
+		EndTime:   c.EndTime.Format(formTimeLayout),
+		TargetDMA: c.TargetDMA,
+		TenantID:  c.TenantID,
+	}
+	if c.ImpressionGoal > 0 {
+		form.ImpressionGoal = strconv.Itoa(c.ImpressionGoal)
+	}
+	if len(c.Ads) > 0 {
+		form.CreativeID = c.Ads[0].CreativeID
+	}
+	return form
 }
 
-// CreateCampaign handles campaign creation form submission
-func (h *Handler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// parse converts the form into campaign fields, collecting every field that
+// doesn't parse
+func (f campaignForm) parse() (models.Campaign, []string) {
+	var errs []string
+	campaign := models.Campaign{
+		Name:      f.Name,
+		TargetDMA: f.TargetDMA,
+		TenantID:  f.TenantID,
+	}
+	var err error
+	if campaign.StartTime, err = time.Parse(formTimeLayout, f.StartTime); err != nil {
+		errs = append(errs, "Start time must be a date and time")
+	}
+	if campaign.EndTime, err = time.Parse(formTimeLayout, f.EndTime); err != nil {
+		errs = append(errs, "End time must be a date and time")
+	}
+	if f.ImpressionGoal != "" {
+		if campaign.ImpressionGoal, err = strconv.Atoi(f.ImpressionGoal); err != nil {
+			errs = append(errs, "Impression goal must be a whole number")
+		}
+	}
+	if f.CreativeID == "" {
+		errs = append(errs, "Choose a creative")
 	}
+	if f.Draft {
+		campaign.Status = models.CampaignStatusDraft
+	}
+	return campaign, errs
+}
 
-	startTimeStr := r.FormValue("start_time")
-	endTimeStr := r.FormValue("end_time")
+// loadCampaignPage fills in the parts of the campaigns page every view
+// shares. Archived campaigns are listed only with ?archived=true.
+func (h *Handler) loadCampaignPage(r *http.Request, scope models.TenantScope) (campaignPageData, error) {
+	var data campaignPageData
+	var err error
+	data.ShowArchived = r.URL.Query().Get("archived") == "true"
+	data.Template = r.URL.Query().Get("template")
+	if data.Campaigns, err = h.service.ListCampaigns(scope, data.ShowArchived); err != nil {
+		return data, err
+	}
+	if data.Delivery, err = h.service.CampaignDelivery(scope); err != nil {
+		return data, err
+	}
+	if data.Advertisers, err = h.tenants.Advertisers(scope); err != nil {
+		return data, err
+	}
+	if data.TenantNames, err = h.tenantNames(scope); err != nil {
+		return data, err
+	}
+	if data.Templates, err = h.service.ListCampaignTemplates(scope); err != nil {
+		return data, err
+	}
+	data.HouseAllowed = scope.All
+	data.StatusActions = service.StatusActions()
+	data.Form = campaignForm{TargetDMA: "*"}
+	return data, nil
+}
 
-	start, err := time.Parse("2006-01-02T15:04", startTimeStr)
+// renderCampaignPage shows the campaigns page. With editing set it shows the
+// edit form for that campaign, otherwise the create form; form and errs
+// replace the form's contents after a rejected submission.
+func (h *Handler) renderCampaignPage(w http.ResponseWriter, r *http.Request, status int, editing *models.Campaign, form *campaignForm, errs []string) {
+	scope := h.scope(r)
+	data, err := h.loadCampaignPage(r, scope)
 	if err != nil {
-		log.Printf("Error parsing start_time '%s': %v", startTimeStr, err)
-		http.Error(w, "Invalid start_time format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	data.Campaign = editing
+	data.Errors = errs
 
-	end, err := time.Parse("2006-01-02T15:04", endTimeStr)
+	// Only the campaign's own advertiser's creatives can be attached to it
+	creativeScope := scope
+	if editing != nil {
+		data.Form = campaignFormFromCampaign(editing)
+		if editing.TenantID != "" {
+			creativeScope = models.TenantScope{TenantIDs: []string{editing.TenantID}}
+		}
+	}
+	if form != nil {
+		data.Form = *form
+	}
+	data.Creatives, err = h.creatives.List(false, creativeScope)
 	if err != nil {
-		log.Printf("Error parsing end_time '%s': %v", endTimeStr, err)
-		http.Error(w, "Invalid end_time format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("start", start)
-	log.Println("end", end)
+	tmpl := h.pageTemplate(r, "campaigns.html")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
+
+// formErrors turns a service error into messages for a re-rendered form. It
+// reports false for errors the user can't fix, which should be sent as-is.
+func formErrors(err error) ([]string, bool) {
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		return []string{validationErr.Msg}, true
+	}
+	return nil, false
+}
 
-	// Get the selected available ad by media_url
-	mediaURL := r.FormValue("media_url")
-	availableAd, err := h.service.GetAvailableAdByMediaURL(mediaURL)
+// Campaign UI. With ?template={id} the create form starts from that template.
+func (h *Handler) ListCampaigns(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("template")
+	if id == "" {
+		h.renderCampaignPage(w, r, http.StatusOK, nil, nil, nil)
+		return
+	}
+	t, err := h.service.GetCampaignTemplate(id, h.scope(r))
 	if err != nil {
-		http.Error(w, "Invalid media URL selected", http.StatusBadRequest)
+		writeServiceError(w, err)
 		return
 	}
+	form := campaignFormFromTemplate(t, time.Now())
+	h.renderCampaignPage(w, r, http.StatusOK, nil, &form, nil)
+}
 
-	// Create a new ad linked to the campaign (copying properties from available ad)
-	ads := []models.Ad{
-		{
-			MediaURL:        availableAd.MediaURL,
-			DurationSeconds: availableAd.DurationSeconds,
-			CreativeID:      availableAd.CreativeID,
-		},
-	}
-	campaign := models.Campaign{
-		Name:      r.FormValue("name"),
-		StartTime: start,
-		EndTime:   end,
-		TargetDMA: r.FormValue("target_dma"),
-		Ads:       ads,
+// CreateCampaign handles campaign creation form submission. Invalid input
+// re-renders the form with the errors and the submitted values.
+func (h *Handler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	if err := h.service.CreateCampaign(campaign); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	form := campaignFormFromRequest(r)
+	campaign, errs := form.parse()
+	if len(errs) == 0 {
+		// The new ad references the selected library creative
+		campaign.Ads = []models.Ad{{CreativeID: form.CreativeID}}
+		if err := h.service.CreateCampaign(campaign, h.scope(r), UserFromContext(r.Context())); err != nil {
+			var ok bool
+			if errs, ok = formErrors(err); !ok {
+				writeServiceError(w, err)
+				return
+			}
+		}
+	}
+	if len(errs) > 0 {
+		h.renderCampaignPage(w, r, http.StatusBadRequest, nil, &form, errs)
 		return
 	}
 
@@ -263,48 +615,17 @@ func (h *Handler) EditCampaign(w http.ResponseWriter, r *http.Request) {
 	}
 	campaignID := pathParts[1]
 
-	campaign, err := h.service.GetCampaign(campaignID)
+	campaign, err := h.service.GetCampaign(campaignID, h.scope(r))
 	if err != nil {
 		http.Error(w, "Campaign not found", http.StatusNotFound)
 		return
 	}
 
-	availableAds, err := h.service.GetAvailableAds()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Get current ad's media URL if campaign has ads
-	currentMediaURL := ""
-	if len(campaign.Ads) > 0 {
-		currentMediaURL = campaign.Ads[0].MediaURL
-	}
-
-	// Get all campaigns for the table
-	allCampaigns, err := h.service.ListCampaigns()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	data := struct {
-		Campaign        *models.Campaign
-		Campaigns       []models.Campaign
-		AvailableAds    []models.Ad
-		CurrentMediaURL string
-	}{
-		Campaign:        campaign,
-		Campaigns:       allCampaigns,
-		AvailableAds:    availableAds,
-		CurrentMediaURL: currentMediaURL,
-	}
-
-	tmpl := template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/campaigns.html"))
-	tmpl.Execute(w, data)
+	h.renderCampaignPage(w, r, http.StatusOK, campaign, nil, nil)
 }
 
-// UpdateCampaign handles campaign update form submission
+// UpdateCampaign handles campaign update form submission. Invalid input
+// re-renders the edit form with the errors and the submitted values.
 func (h *Handler) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -319,59 +640,76 @@ func (h *Handler) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
 	}
 	campaignID := pathParts[1]
 
-	startTimeStr := r.FormValue("start_time")
-	endTimeStr := r.FormValue("end_time")
-
-	start, err := time.Parse("2006-01-02T15:04", startTimeStr)
+	scope := h.scope(r)
+	existing, err := h.service.GetCampaign(campaignID, scope)
 	if err != nil {
-		log.Printf("Error parsing start_time '%s': %v", startTimeStr, err)
-		http.Error(w, "Invalid start_time format", http.StatusBadRequest)
+		http.Error(w, "Campaign not found", http.StatusNotFound)
 		return
 	}
 
-	end, err := time.Parse("2006-01-02T15:04", endTimeStr)
-	if err != nil {
-		log.Printf("Error parsing end_time '%s': %v", endTimeStr, err)
-		http.Error(w, "Invalid end_time format", http.StatusBadRequest)
-		return
+	form := campaignFormFromRequest(r)
+	campaign, errs := form.parse()
+	if len(errs) == 0 {
+		// The form edits the campaign's first ad only; any other ads (added
+		// through the API) are carried over, and an unchanged creative keeps
+		// its ad row so impression history stays linked.
+		ads := existing.Ads
+		if len(ads) == 0 || ads[0].CreativeID != form.CreativeID {
+			ad := models.Ad{CreativeID: form.CreativeID}
+			if len(ads) == 0 {
+				ads = []models.Ad{ad}
+			} else {
+				ads[0] = ad
+			}
+		}
+		campaign.ID = campaignID
+		campaign.Ads = ads
+
+		if err := h.service.UpdateCampaign(campaign, scope, UserFromContext(r.Context())); err != nil {
+			var ok bool
+			if errs, ok = formErrors(err); !ok {
+				writeServiceError(w, err)
+				return
+			}
+		}
 	}
-
-	// Get the selected available ad by media_url
-	mediaURL := r.FormValue("media_url")
-	availableAd, err := h.service.GetAvailableAdByMediaURL(mediaURL)
-	if err != nil {
-		http.Error(w, "Invalid media URL selected", http.StatusBadRequest)
+	if len(errs) > 0 {
+		form.TenantID = existing.TenantID
+		h.renderCampaignPage(w, r, http.StatusBadRequest, existing, &form, errs)
 		return
 	}
 
-	// Create updated campaign
-	ads := []models.Ad{
-		{
-			MediaURL:        availableAd.MediaURL,
-			DurationSeconds: availableAd.DurationSeconds,
-			CreativeID:      availableAd.CreativeID,
-		},
+	http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
+}
+
+// DeleteCampaign handles the delete button on the campaigns page
+// (POST /campaigns/{id}/delete)
+func (h *Handler) DeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	campaign := models.Campaign{
-		ID:        campaignID,
-		Name:      r.FormValue("name"),
-		StartTime: start,
-		EndTime:   end,
-		TargetDMA: r.FormValue("target_dma"),
-		Ads:       ads,
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "campaigns" || pathParts[2] != "delete" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
 	}
-
-	if err := h.service.UpdateCampaign(campaign); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.service.DeleteCampaign(pathParts[1], h.scope(r), UserFromContext(r.Context())); err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		h.renderCampaignPage(w, r, http.StatusBadRequest, nil, nil, errs)
 		return
 	}
-
 	http.Redirect(w, r, "/campaigns", http.StatusSeeOther)
 }
 
-// UpdateCampaignAPI handles REST API campaign updates (PUT/PATCH)
+// UpdateCampaignAPI handles REST API campaign updates. PUT replaces the
+// campaign; PATCH applies a JSON Merge Patch (RFC 7396); DELETE soft-deletes it.
 func (h *Handler) UpdateCampaignAPI(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "PUT" && r.Method != "PATCH" {
+	if r.Method != "PUT" && r.Method != "PATCH" && r.Method != "DELETE" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -383,6 +721,31 @@ func (h *Handler) UpdateCampaignAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	campaignID := pathParts[2]
+	scope := h.scope(r)
+
+	if r.Method == "DELETE" {
+		if err := h.service.DeleteCampaign(campaignID, scope, UserFromContext(r.Context())); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method == "PATCH" {
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		updated, err := h.service.PatchCampaign(campaignID, patch, scope, UserFromContext(r.Context()))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+		return
+	}
 
 	// Parse JSON request body
 	var campaign models.Campaign
@@ -394,24 +757,10 @@ func (h *Handler) UpdateCampaignAPI(w http.ResponseWriter, r *http.Request) {
 	// Ensure the ID matches
 	campaign.ID = campaignID
 
-	// Validate required fields
-	if campaign.Name == "" {
-		http.Error(w, "Campaign name is required", http.StatusBadRequest)
-		return
-	}
-	if campaign.StartTime.IsZero() || campaign.EndTime.IsZero() {
-		http.Error(w, "Start time and end time are required", http.StatusBadRequest)
-		return
-	}
-	if campaign.TargetDMA == "" {
-		http.Error(w, "Target DMA is required", http.StatusBadRequest)
-		return
-	}
-
 	// If ads are provided, validate them; otherwise keep existing ads
 	if len(campaign.Ads) == 0 {
 		// Get existing campaign to preserve ads
-		existing, err := h.service.GetCampaign(campaignID)
+		existing, err := h.service.GetCampaign(campaignID, scope)
 		if err != nil {
 			http.Error(w, "Campaign not found", http.StatusNotFound)
 			return
@@ -419,41 +768,134 @@ func (h *Handler) UpdateCampaignAPI(w http.ResponseWriter, r *http.Request) {
 		campaign.Ads = existing.Ads
 	}
 
-	if err := h.service.UpdateCampaign(campaign); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := service.ValidateCampaign(campaign); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	if err := h.service.UpdateCampaign(campaign, scope, UserFromContext(r.Context())); err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	// Return updated campaign
-	updated, err := h.service.GetCampaign(campaignID)
+	updated, err := h.service.GetCampaign(campaignID, scope)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// CampaignAdsAPI manages the ads of one campaign as a sub-resource:
+// GET/POST /api/campaigns/{id}/ads and DELETE /api/campaigns/{id}/ads/{adID}
+func (h *Handler) CampaignAdsAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 4 || pathParts[0] != "api" || pathParts[1] != "campaigns" || pathParts[3] != "ads" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	campaignID := pathParts[2]
+	scope := h.scope(r)
+
+	if len(pathParts) == 5 {
+		if r.Method != "DELETE" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := h.service.RemoveAd(campaignID, pathParts[4], scope, UserFromContext(r.Context())); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if len(pathParts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		campaign, err := h.service.GetCampaign(campaignID, scope)
+		if err != nil {
+			http.Error(w, "Campaign not found", http.StatusNotFound)
+			return
+		}
+		ads := campaign.Ads
+		if ads == nil {
+			ads = []models.Ad{}
+		}
+		writeJSON(w, http.StatusOK, ads)
+	case "POST":
+		var ad models.Ad
+		if err := json.NewDecoder(r.Body).Decode(&ad); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		created, err := h.service.AddAd(campaignID, ad, scope, UserFromContext(r.Context()))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeJSON encodes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updated)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeServiceError maps service errors onto HTTP status codes
+func writeServiceError(w http.ResponseWriter, err error) {
+	var validationErr *service.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		http.Error(w, validationErr.Msg, http.StatusBadRequest)
+	case errors.Is(err, service.ErrNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 // Client Demo
 func (h *Handler) ClientDemo(w http.ResponseWriter, r *http.Request) {
-	log.Println("Inside ClientDemo function")
-	tmpl := template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/client_demo.html"))
-	log.Println("Inside ClientDemo function", tmpl.Tree.Root.Nodes)
+	tmpl := h.pageTemplate(r, "client_demo.html")
 	tmpl.Execute(w, nil)
 }
 
 // API: Serve Ads
 func (h *Handler) ServeAds(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "parse ad request")
 	dma := r.URL.Query().Get("dma")
 	clientID := r.URL.Query().Get("client_id")
 
+	// Authenticated devices always serve as their bound client, so the rate
+	// limit can't be dodged by sending a different client_id
+	if device := DeviceFromContext(r.Context()); device != nil {
+		if clientID != "" && clientID != device.ClientID {
+			span.End()
+			http.Error(w, "client_id does not match device", http.StatusForbidden)
+			return
+		}
+		clientID = device.ClientID
+	}
+	span.SetAttributes(attribute.String("client_id", clientID), attribute.String("dma", dma))
+	span.End()
+
 	if clientID == "" {
 		http.Error(w, "Missing client_id", http.StatusBadRequest)
 		return
 	}
 
-	xmlResponse, err := h.service.GetAdsForClient(clientID, dma)
+	xmlResponse, err := h.service.GetAdsForClient(r.Context(), clientID, dma)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -482,6 +924,7 @@ func (h *Handler) QueryRequestLogs(w http.ResponseWriter, r *http.Request) {
 
 	methodFilter := r.URL.Query().Get("method")
 	pathFilter := r.URL.Query().Get("path")
+	requestIDFilter := r.URL.Query().Get("request_id")
 
 	var startTime *time.Time
 	if startTimeStr := r.URL.Query().Get("start_time"); startTimeStr != "" {
@@ -498,14 +941,14 @@ func (h *Handler) QueryRequestLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get logs
-	logs, err := h.store.GetRequestLogs(limit, offset, methodFilter, pathFilter, startTime, endTime)
+	logs, err := h.store.GetRequestLogs(limit, offset, methodFilter, pathFilter, requestIDFilter, startTime, endTime)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Get total count
-	totalCount, err := h.store.GetRequestLogCount(methodFilter, pathFilter, startTime, endTime)
+	totalCount, err := h.store.GetRequestLogCount(methodFilter, pathFilter, requestIDFilter, startTime, endTime)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/service"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv is a Handler over a throwaway database
+type testEnv struct {
+	db      *store.Store
+	h       *Handler
+	svc     *service.AdService
+	auth    *service.AuthService
+	devices *service.DeviceService
+	tenants *service.TenantService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db, err := store.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	e := &testEnv{
+		db:      db,
+		svc:     service.NewAdService(db, service.RateLimit{MaxSeconds: 300, Window: time.Hour}, "http://ads.example.com"),
+		auth:    service.NewAuthService(db, time.Hour),
+		devices: service.NewDeviceService(db, 5*time.Minute),
+		tenants: service.NewTenantService(db),
+	}
+	e.h = NewHandler(e.svc, nil, e.auth, e.devices, e.tenants, service.NewReportService(db), db, false)
+	return e
+}
+
+// login creates a user and returns a cookie for a session of theirs
+func (e *testEnv) login(t *testing.T, username, role, tenantID string) *http.Cookie {
+	t.Helper()
+	if _, err := e.auth.CreateUser(username, "password123", role, tenantID); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := e.auth.Login(username, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+// advertiser creates an advertiser tenant
+func (e *testEnv) advertiser(t *testing.T, name string) string {
+	t.Helper()
+	tenant, err := e.tenants.Create(name, models.TenantTypeAdvertiser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tenant.ID
+}
+
+// liveCampaign stores a national campaign in flight now, with one ad using
+// a creative of its own
+func (e *testEnv) liveCampaign(t *testing.T, id, tenantID string) {
+	t.Helper()
+	creative := models.CreativeAsset{
+		ID: id + "-creative", Name: id, MediaURL: "http://example.com/" + id + ".mp4", MimeType: "video/mp4",
+		DurationSeconds: 15, TenantID: tenantID, Status: models.CreativeStatusActive, CreatedAt: time.Now(),
+	}
+	if err := e.db.CreateCreative(creative); err != nil {
+		t.Fatal(err)
+	}
+	c := models.Campaign{
+		ID:        id,
+		Name:      id,
+		StartTime: time.Now().Add(-time.Hour),
+		EndTime:   time.Now().Add(time.Hour),
+		TargetDMA: "*",
+		TenantID:  tenantID,
+		Status:    models.CampaignStatusScheduled,
+		Ads: []models.Ad{{
+			ID: id + "-ad", MediaURL: "http://example.com/" + id + ".mp4", DurationSeconds: 15, CreativeID: id + "-creative",
+		}},
+	}
+	if err := e.db.CreateCampaign(c, models.CampaignRevision{Action: models.RevisionCreate}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	e := newTestEnv(t)
+	cookie := e.login(t, "trafficker", models.RoleTrafficker, "")
+	other := e.login(t, "other", models.RoleTrafficker, "")
+	token := e.auth.CSRFToken(cookie.Value)
+	protected := AuthMiddleware(e.auth)(func(w http.ResponseWriter, r *http.Request) {})
+
+	form := func(token string) *http.Request {
+		req := httptest.NewRequest("POST", "/campaigns/c1/delete", strings.NewReader(url.Values{csrfFieldName: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	withHeader := func(req *http.Request, token string) *http.Request {
+		req.Header.Set(csrfHeaderName, token)
+		return req
+	}
+	withType := func(req *http.Request, contentType string) *http.Request {
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(csrfFieldName, token)
+	mw.Close()
+	upload := withType(httptest.NewRequest("POST", "/creatives", &body), mw.FormDataContentType())
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"GET needs no token", httptest.NewRequest("GET", "/campaigns", nil), http.StatusOK},
+		{"form without a token", form(""), http.StatusForbidden},
+		{"form with a wrong token", form("forged"), http.StatusForbidden},
+		{"form with another session's token", form(e.auth.CSRFToken(other.Value)), http.StatusForbidden},
+		{"form with the token", form(token), http.StatusOK},
+		{"multipart form with the token", upload, http.StatusOK},
+		{"token in the header", withHeader(httptest.NewRequest("POST", "/campaigns/c1/pause", nil), token), http.StatusOK},
+		{"wrong token in the header", withHeader(form(token), "forged"), http.StatusForbidden},
+		{"plain text without a token", withType(httptest.NewRequest("POST", "/api/campaigns", strings.NewReader("{}")), "text/plain"), http.StatusForbidden},
+		{"JSON API request", withType(httptest.NewRequest("POST", "/api/campaigns", strings.NewReader("{}")), "application/json; charset=utf-8"), http.StatusOK},
+		{"merge patch API request", withType(httptest.NewRequest("PATCH", "/api/campaigns/c1", strings.NewReader("{}")), "application/merge-patch+json"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		tt.req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		protected(rec, tt.req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	// Logging out also needs the token, so a forged form can't end the session
+	req := form("")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	e.h.Logout(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("logout without a token: got %d, want 403", rec.Code)
+	}
+	if _, err := e.auth.Authenticate(cookie.Value); err != nil {
+		t.Errorf("session ended by a logout without a token: %v", err)
+	}
+	req = form(token)
+	req.AddCookie(cookie)
+	e.h.Logout(httptest.NewRecorder(), req)
+	if _, err := e.auth.Authenticate(cookie.Value); err == nil {
+		t.Error("session still valid after logging out")
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"rockbot-adserver/internal/store"
+	"runtime"
+	"time"
+)
+
+// BuildInfo identifies the running binary. Commit and BuildTime are injected
+// with -ldflags at build time and are "unknown" in plain go build output.
+type BuildInfo struct {
+	Commit    string
+	BuildTime string
+}
+
+// Health serves the liveness, readiness and version endpoints. They sit
+// outside auth and request logging so orchestrators can poll them freely.
+type Health struct {
+	store *store.Store
+	build BuildInfo
+}
+
+func NewHealth(st *store.Store, build BuildInfo) *Health {
+	return &Health{store: st, build: build}
+}
+
+// Healthz reports that the process is up and serving HTTP
+func (h *Health) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz reports whether the server can serve ads: the database answers,
+// its schema is at the version this build expects, and the active campaign
+// query used by /vast runs. Campaigns are not cached; every ad request runs
+// that query, so there is no cache to warm and "serving_query" only says
+// the query succeeds. Any failing check makes it return 503.
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			checks[name] = err.Error()
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	check("database", h.store.Ping(ctx))
+	version, err := h.store.SchemaVersion()
+	if err == nil && version != store.LatestSchemaVersion() {
+		err = fmt.Errorf("schema version %d, want %d", version, store.LatestSchemaVersion())
+	}
+	check("migrations", err)
+	_, err = h.store.GetActiveCampaigns(ctx, "*", time.Now())
+	check("serving_query", err)
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+// Version reports the build and the database schema it is running against
+func (h *Health) Version(w http.ResponseWriter, r *http.Request) {
+	version, err := h.store.SchemaVersion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"commit":                h.build.Commit,
+		"build_time":            h.build.BuildTime,
+		"go_version":            runtime.Version(),
+		"schema_version":        version,
+		"latest_schema_version": store.LatestSchemaVersion(),
+	})
+}
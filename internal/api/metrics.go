@@ -0,0 +1,64 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"rockbot-adserver/internal/metrics"
+	"strconv"
+	"time"
+)
+
+// HTTPMetrics counts requests and their latency per route. Routes are the
+// patterns handlers are registered under, never raw paths, so campaign IDs
+// and other path parameters don't create new series.
+type HTTPMetrics struct {
+	Requests *metrics.CounterVec   // route, method, code
+	Duration *metrics.HistogramVec // route
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Middleware instruments every request to the route
+func (m *HTTPMetrics) Middleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			m.Requests.Inc(route, methodLabel(r.Method), strconv.Itoa(rec.status))
+			m.Duration.Observe(time.Since(start).Seconds(), route)
+		})
+	}
+}
+
+// methodLabel maps the request method onto a fixed set, so clients sending
+// made-up methods can't create new series
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
+
+// MetricsHandler serves the registry. When token is set, scrapers must send
+// it as a bearer token.
+func MetricsHandler(registry *metrics.Registry, token string) http.Handler {
+	next := registry.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+// Permission names an action that is granted to some roles
+type Permission string
+
+const (
+	PermViewCampaigns   Permission = "campaigns:view"
+	PermEditCampaigns   Permission = "campaigns:edit"
+	PermViewCreatives   Permission = "creatives:view"
+	PermManageCreatives Permission = "creatives:manage"
+	PermPreviewAds      Permission = "ads:preview"
+	PermViewReports     Permission = "reports:view"
+	PermViewLogs        Permission = "logs:view"
+	PermManageUsers     Permission = "users:manage"
+	PermManageDevices   Permission = "devices:manage"
+	PermManageTenants   Permission = "tenants:manage"
+	PermViewBudgets     Permission = "budgets:view"
+	PermResetBudgets    Permission = "budgets:reset"
+)
+
+// rolePermissions is the access policy. Request logs carry headers and
+// bodies, so they stay admin-only; analysts can look but never edit.
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin: {
+		PermViewCampaigns, PermEditCampaigns, PermViewCreatives, PermManageCreatives,
+		PermPreviewAds, PermViewReports, PermViewLogs, PermManageUsers, PermManageDevices,
+		PermManageTenants, PermViewBudgets, PermResetBudgets,
+	},
+	models.RoleTrafficker: {
+		PermViewCampaigns, PermEditCampaigns, PermViewCreatives, PermManageCreatives,
+		PermPreviewAds, PermViewReports, PermViewBudgets,
+	},
+	models.RoleAnalyst: {
+		PermViewCampaigns, PermViewCreatives, PermViewReports,
+	},
+	models.RoleReadOnly: {
+		PermViewCampaigns, PermViewCreatives,
+	},
+}
+
+// platformPermissions reach across tenants, so they are never granted to
+// agency or advertiser users whatever their role. A client's budget is
+// spent on every advertiser's ads, and previewing serves from every
+// advertiser's campaigns and records real impressions against them.
+var platformPermissions = map[Permission]bool{
+	PermPreviewAds:    true,
+	PermViewLogs:      true,
+	PermManageUsers:   true,
+	PermManageDevices: true,
+	PermManageTenants: true,
+	PermViewBudgets:   true,
+	PermResetBudgets:  true,
+}
+
+// Can reports whether the user's role grants perm
+func Can(user *models.User, perm Permission) bool {
+	if user == nil {
+		return false
+	}
+	if user.TenantID != "" && platformPermissions[perm] {
+		return false
+	}
+	for _, p := range rolePermissions[user.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Require only lets users whose role grants perm reach next. It must run
+// inside AuthMiddleware, which attaches the user to the request.
+func Require(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !Can(UserFromContext(r.Context()), perm) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireByMethod checks read for GET/HEAD requests and write for everything
+// else, for routes that serve both from one handler
+func RequireByMethod(read, write Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		perm := write
+		if r.Method == "GET" || r.Method == "HEAD" {
+			perm = read
+		}
+		if !Can(UserFromContext(r.Context()), perm) {
+			forbidden(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "You don't have permission to do this. Ask an admin to change your role.", http.StatusForbidden)
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"encoding/csv"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"rockbot-adserver/internal/chart"
+	"rockbot-adserver/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deliveryFilterParams maps query parameters to the dimensions they filter
+var deliveryFilterParams = map[string]string{
+	"campaign_id": models.DimensionCampaign,
+	"ad_id":       models.DimensionAd,
+	"creative_id": models.DimensionCreative,
+	"dma":         models.DimensionDMA,
+	"client_id":   models.DimensionClient,
+}
+
+// DeliveryReport serves GET /api/reports/delivery. Query parameters:
+// group_by (comma-separated campaign, ad, creative, dma, client, hour, day),
+// from and to (RFC 3339 or YYYY-MM-DD in UTC; a date "to" includes that day),
+// the filters in deliveryFilterParams, unique_clients=true, and format=json
+// (default) or csv. X-Report-Source says whether rollups or raw impressions
+// answered.
+func (h *Handler) DeliveryReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+
+	q := models.DeliveryQuery{Filters: map[string]string{}}
+	for _, dim := range strings.Split(params.Get("group_by"), ",") {
+		if dim = strings.TrimSpace(dim); dim != "" {
+			q.GroupBy = append(q.GroupBy, dim)
+		}
+	}
+	for param, dim := range deliveryFilterParams {
+		if v := params.Get(param); v != "" {
+			q.Filters[dim] = v
+		}
+	}
+	q.UniqueClients = params.Get("unique_clients") == "true"
+	var err error
+	if q.From, err = parseReportTime(params.Get("from"), false); err != nil {
+		http.Error(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseReportTime(params.Get("to"), true); err != nil {
+		http.Error(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, source, err := h.reports.Delivery(q, h.scope(r))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("X-Report-Source", source)
+
+	switch params.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, rows)
+	case "csv":
+		writeDeliveryCSV(w, q.GroupBy, rows)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+// parseReportTime reads RFC 3339 times or UTC dates. A date used as the end
+// of a range means the end of that day.
+func parseReportTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// writeDeliveryCSV writes one column per grouped dimension followed by the
+// metrics. unique_clients is empty for reports read from rollups.
+func writeDeliveryCSV(w http.ResponseWriter, groupBy []string, rows []models.DeliveryRow) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="delivery-report.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(append(append([]string{}, groupBy...), "impressions", "seconds_served", "unique_clients", "completions", "completion_rate"))
+	for _, row := range rows {
+		record := make([]string, 0, len(groupBy)+5)
+		for _, dim := range groupBy {
+			record = append(record, row.Dimensions[dim])
+		}
+		uniqueClients := ""
+		if row.UniqueClients != nil {
+			uniqueClients = strconv.Itoa(*row.UniqueClients)
+		}
+		record = append(record,
+			strconv.Itoa(row.Impressions),
+			strconv.Itoa(row.SecondsServed),
+			uniqueClients,
+			strconv.Itoa(row.Completions),
+			strconv.FormatFloat(row.CompletionRate, 'f', 4, 64))
+		out.Write(record)
+	}
+	out.Flush()
+}
+
+// TrackEvent serves the tracking links in VAST responses:
+// GET /vast/events?event=complete&impression_id=... Players fire these
+// without credentials; impression IDs are random and completions idempotent.
+func (h *Handler) TrackEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	impressionID := r.URL.Query().Get("impression_id")
+	if impressionID == "" {
+		http.Error(w, "Missing impression_id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.TrackEvent(impressionID, r.URL.Query().Get("event")); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reportsPageDays is the range the reports page shows by default
+const reportsPageDays = 7
+
+// reportsPageSeries caps the campaigns drawn as their own line; the rest
+// are summed into "Other"
+const reportsPageSeries = 5
+
+// reportsPageDMAs is how many DMAs the top DMAs chart shows
+const reportsPageDMAs = 10
+
+// reportsPageData is what reports.html renders. From and To are the dates
+// in the pickers; To is inclusive.
+type reportsPageData struct {
+	From          string
+	To            string
+	Errors        []string
+	Hourly        bool
+	DeliveryChart template.HTML
+	DMAChart      template.HTML
+	Campaigns     []campaignReportRow
+	Pacing        []pacingRow
+	Requests      *models.AdRequestSummary
+	FillPercent   string
+	DeliveryCSV   string
+	CampaignCSV   string
+	DMACSV        string
+}
+
+// campaignReportRow is one campaign's delivery within the range
+type campaignReportRow struct {
+	Name              string
+	Impressions       int
+	SecondsServed     int
+	Completions       int
+	CompletionPercent string
+}
+
+// pacingRow is a campaign's pacing with the bar widths precomputed
+type pacingRow struct {
+	models.CampaignPacing
+	Name           string
+	DeliveredWidth float64 // delivered as a percentage of the goal, capped at 100
+	ExpectedWidth  float64
+	PacePercent    string
+	Behind         bool
+}
+
+// ReportsPage serves /reports: delivery over time per campaign, pacing
+// against impression goals, top DMAs and, for platform users, fill rate
+// and rate-limit rejections, for a range of UTC dates. It reads the same
+// reports as /api/reports/delivery, which its CSV links point at.
+func (h *Handler) ReportsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	scope := h.scope(r)
+	now := time.Now()
+
+	var data reportsPageData
+	today := now.UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, 1-reportsPageDays), today.AddDate(0, 0, 1)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if t, err := time.Parse(formDateLayout, v); err == nil {
+			from = t
+		} else {
+			data.Errors = append(data.Errors, "From must be a date")
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if t, err := time.Parse(formDateLayout, v); err == nil {
+			to = t.AddDate(0, 0, 1)
+		} else {
+			data.Errors = append(data.Errors, "To must be a date")
+		}
+	}
+	if !to.After(from) {
+		data.Errors = append(data.Errors, "To must not be before from")
+		from, to = today.AddDate(0, 0, 1-reportsPageDays), today.AddDate(0, 0, 1)
+	}
+	data.From, data.To = from.Format(formDateLayout), to.AddDate(0, 0, -1).Format(formDateLayout)
+
+	names, err := h.service.CampaignNames(scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	campaignName := func(id string) string {
+		if name, ok := names[id]; ok {
+			return name
+		}
+		return id
+	}
+
+	// Delivery over time, hourly for ranges of two days or less
+	bucket, bucketLayout, step := models.DimensionDay, formDateLayout, 24*time.Hour
+	if to.Sub(from) <= 48*time.Hour {
+		data.Hourly = true
+		bucket, bucketLayout, step = models.DimensionHour, time.RFC3339, time.Hour
+	}
+	rows, _, err := h.reports.Delivery(models.DeliveryQuery{
+		GroupBy: []string{bucket, models.DimensionCampaign}, From: from, To: to}, scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	data.DeliveryChart = deliveryChart(rows, bucket, bucketLayout, step, from, to, campaignName)
+
+	// Per-campaign totals, busiest first
+	rows, _, err = h.reports.Delivery(models.DeliveryQuery{
+		GroupBy: []string{models.DimensionCampaign}, From: from, To: to}, scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Impressions > rows[j].Impressions })
+	for _, row := range rows {
+		data.Campaigns = append(data.Campaigns, campaignReportRow{
+			Name:              campaignName(row.Dimensions[models.DimensionCampaign]),
+			Impressions:       row.Impressions,
+			SecondsServed:     row.SecondsServed,
+			Completions:       row.Completions,
+			CompletionPercent: formatPercent(row.CompletionRate),
+		})
+	}
+
+	// Top DMAs
+	rows, _, err = h.reports.Delivery(models.DeliveryQuery{
+		GroupBy: []string{models.DimensionDMA}, From: from, To: to}, scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Impressions > rows[j].Impressions })
+	if len(rows) > reportsPageDMAs {
+		rows = rows[:reportsPageDMAs]
+	}
+	var dmaLabels []string
+	var dmaValues []float64
+	for _, row := range rows {
+		label := row.Dimensions[models.DimensionDMA]
+		if label == "" {
+			label = "(none)"
+		}
+		dmaLabels = append(dmaLabels, label)
+		dmaValues = append(dmaValues, float64(row.Impressions))
+	}
+	data.DMAChart = chart.Bars(dmaLabels, dmaValues)
+
+	pacing, err := h.reports.Pacing(from, to, now, scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	for _, p := range pacing {
+		row := pacingRow{
+			CampaignPacing: p,
+			Name:           p.Campaign.Name,
+			DeliveredWidth: math.Min(100, 100*float64(p.Delivered)/float64(p.Campaign.ImpressionGoal)),
+			ExpectedWidth:  math.Min(100, 100*float64(p.Expected)/float64(p.Campaign.ImpressionGoal)),
+			PacePercent:    "-",
+			Behind:         p.Expected > 0 && p.Pace < 0.9,
+		}
+		if p.Expected > 0 {
+			row.PacePercent = formatPercent(p.Pace)
+		}
+		data.Pacing = append(data.Pacing, row)
+	}
+
+	if scope.All {
+		requests, err := h.reports.AdRequests(from, to, scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		data.Requests = &requests
+		data.FillPercent = formatPercent(requests.FillRate)
+	}
+
+	csvLink := func(groupBy string) string {
+		return "/api/reports/delivery?" + url.Values{
+			"group_by": {groupBy}, "from": {data.From}, "to": {data.To}, "format": {"csv"},
+		}.Encode()
+	}
+	data.DeliveryCSV = csvLink(bucket + "," + models.DimensionCampaign)
+	data.CampaignCSV = csvLink(models.DimensionCampaign)
+	data.DMACSV = csvLink(models.DimensionDMA)
+
+	tmpl := h.pageTemplate(r, "reports.html")
+	tmpl.Execute(w, data)
+}
+
+// formDateLayout is the format of date inputs
+const formDateLayout = "2006-01-02"
+
+// deliveryChart draws impressions per bucket in [from, to), one line for
+// each of the busiest campaigns and one for the rest. Buckets without
+// impressions are drawn as zero.
+func deliveryChart(rows []models.DeliveryRow, bucket, layout string, step time.Duration, from, to time.Time, campaignName func(string) string) template.HTML {
+	var labels []string
+	index := make(map[string]int)
+	for t := from; t.Before(to); t = t.Add(step) {
+		key := t.UTC().Format(layout)
+		index[key] = len(labels)
+		if layout == formDateLayout {
+			labels = append(labels, t.Format("Jan 2"))
+		} else {
+			labels = append(labels, t.Format("Jan 2 15:04"))
+		}
+	}
+
+	totals := make(map[string]int)
+	for _, row := range rows {
+		totals[row.Dimensions[models.DimensionCampaign]] += row.Impressions
+	}
+	ids := make([]string, 0, len(totals))
+	for id := range totals {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if totals[ids[i]] != totals[ids[j]] {
+			return totals[ids[i]] > totals[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	series := make([]chart.Series, 0, reportsPageSeries+1)
+	position := make(map[string]int, len(ids))
+	for i, id := range ids {
+		if i == reportsPageSeries {
+			series = append(series, chart.Series{Name: "Other", Values: make([]float64, len(labels))})
+			break
+		}
+		position[id] = i
+		series = append(series, chart.Series{Name: campaignName(id), Values: make([]float64, len(labels))})
+	}
+	for _, row := range rows {
+		i, ok := index[row.Dimensions[bucket]]
+		if !ok {
+			continue
+		}
+		s, ok := position[row.Dimensions[models.DimensionCampaign]]
+		if !ok {
+			s = reportsPageSeries
+		}
+		series[s].Values[i] += float64(row.Impressions)
+	}
+	if len(series) == 0 {
+		series = []chart.Series{{Name: "Impressions", Values: make([]float64, len(labels))}}
+	}
+	return chart.Lines(labels, series)
+}
+
+// formatPercent formats a ratio as a percentage with one decimal
+func formatPercent(ratio float64) string {
+	return strconv.FormatFloat(100*ratio, 'f', 1, 64) + "%"
+}
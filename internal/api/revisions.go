@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"strconv"
+	"strings"
+)
+
+// CampaignRevisionsAPI serves a campaign's history:
+// GET /api/campaigns/{id}/revisions and
+// POST /api/campaigns/{id}/revisions/{revision}/rollback
+func (h *Handler) CampaignRevisionsAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 4 || pathParts[0] != "api" || pathParts[1] != "campaigns" || pathParts[3] != "revisions" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	campaignID := pathParts[2]
+	scope := h.scope(r)
+
+	switch {
+	case len(pathParts) == 4 && r.Method == "GET":
+		revisions, err := h.service.CampaignHistory(campaignID, scope)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, revisions)
+	case len(pathParts) == 6 && pathParts[5] == "rollback" && r.Method == "POST":
+		revisionID, err := strconv.ParseInt(pathParts[4], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		campaign, err := h.service.RollbackCampaign(campaignID, revisionID, scope, UserFromContext(r.Context()))
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, campaign)
+	case len(pathParts) == 4 || (len(pathParts) == 6 && pathParts[5] == "rollback"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// campaignHistoryPageData is what campaign_history.html renders
+type campaignHistoryPageData struct {
+	Campaign  *models.Campaign
+	Revisions []models.CampaignRevision
+	Errors    []string
+}
+
+// CampaignHistory shows a campaign's revisions with what each changed
+// (GET /campaigns/{id}/history)
+func (h *Handler) CampaignHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "campaigns" || pathParts[2] != "history" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	h.renderCampaignHistory(w, r, http.StatusOK, pathParts[1], nil)
+}
+
+// RollbackCampaign handles the rollback buttons on the history page
+// (POST /campaigns/{id}/rollback with a revision field)
+func (h *Handler) RollbackCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) != 3 || pathParts[0] != "campaigns" || pathParts[2] != "rollback" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	campaignID := pathParts[1]
+
+	revisionID, err := strconv.ParseInt(r.FormValue("revision"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.RollbackCampaign(campaignID, revisionID, h.scope(r), UserFromContext(r.Context())); err != nil {
+		errs, ok := formErrors(err)
+		if !ok {
+			writeServiceError(w, err)
+			return
+		}
+		h.renderCampaignHistory(w, r, http.StatusBadRequest, campaignID, errs)
+		return
+	}
+	http.Redirect(w, r, "/campaigns/"+campaignID+"/history", http.StatusSeeOther)
+}
+
+func (h *Handler) renderCampaignHistory(w http.ResponseWriter, r *http.Request, status int, campaignID string, errs []string) {
+	scope := h.scope(r)
+	campaign, err := h.service.GetCampaign(campaignID, scope)
+	if err != nil {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+	revisions, err := h.service.CampaignHistory(campaignID, scope)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	tmpl := h.pageTemplate(r, "campaign_history.html")
+	w.WriteHeader(status)
+	tmpl.Execute(w, campaignHistoryPageData{Campaign: campaign, Revisions: revisions, Errors: errs})
+}
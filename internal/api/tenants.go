@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+// TenantsAPI lets platform admins manage agencies and advertisers:
+// GET/POST /api/tenants and GET /api/tenants/{id}
+func (h *Handler) TenantsAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "api" || pathParts[1] != "tenants" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(pathParts) == 2 && r.Method == "GET":
+		tenants, err := h.tenants.List(models.AllTenants)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tenants == nil {
+			tenants = []models.Tenant{}
+		}
+		writeJSON(w, http.StatusOK, tenants)
+	case len(pathParts) == 2 && r.Method == "POST":
+		var req struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			ParentID string `json:"parent_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		tenant, err := h.tenants.Create(req.Name, req.Type, req.ParentID)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, tenant)
+	case len(pathParts) == 3 && r.Method == "GET":
+		tenant, err := h.tenants.Get(pathParts[2])
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tenant)
+	case len(pathParts) <= 3:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+// UsersAPI lets admins manage accounts:
+// GET/POST /api/users and PATCH /api/users/{id} (to change the role or tenant)
+func (h *Handler) UsersAPI(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "api" || pathParts[1] != "users" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(pathParts) == 2 && r.Method == "GET":
+		users, err := h.auth.ListUsers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if users == nil {
+			users = []models.User{}
+		}
+		writeJSON(w, http.StatusOK, users)
+	case len(pathParts) == 2 && r.Method == "POST":
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+			TenantID string `json:"tenant_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		user, err := h.auth.CreateUser(req.Username, req.Password, req.Role, req.TenantID)
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, user)
+	case len(pathParts) == 3 && r.Method == "PATCH":
+		// Both fields are optional; tenant_id "" moves the user to the platform
+		var req struct {
+			Role     *string `json:"role"`
+			TenantID *string `json:"tenant_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Role != nil {
+			if err := h.auth.SetRole(pathParts[2], *req.Role); err != nil {
+				writeServiceError(w, err)
+				return
+			}
+		}
+		if req.TenantID != nil {
+			if err := h.auth.SetTenant(pathParts[2], *req.TenantID); err != nil {
+				writeServiceError(w, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(pathParts) <= 3:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
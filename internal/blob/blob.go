@@ -0,0 +1,22 @@
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a key does not exist in the backend
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a place to keep uploaded creative files. Implementations only need
+// to map keys to bytes and to a URL that devices can fetch the file from.
+type Store interface {
+	// Put writes the contents of r under key and returns the number of bytes stored
+	Put(key string, r io.Reader) (int64, error)
+	// Open returns a reader for the blob stored under key
+	Open(key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key
+	Delete(key string) error
+	// URL returns the public URL for the blob stored under key
+	URL(key string) string
+}
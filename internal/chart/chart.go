@@ -0,0 +1,161 @@
+// Package chart draws the small charts on the reports page as inline SVG,
+// so the page needs no JavaScript or front-end build.
+package chart
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Chart dimensions in SVG user units. Charts scale to their container.
+const (
+	width        = 760
+	lineHeight   = 240
+	barRowHeight = 22
+	marginLeft   = 60
+	marginRight  = 20
+	marginTop    = 10
+	marginBottom = 30
+)
+
+// palette colours series in order, then repeats
+var palette = []string{"#007bff", "#28a745", "#fd7e14", "#6f42c1", "#dc3545", "#17a2b8", "#6c757d"}
+
+// Series is one line of a line chart, with a value per label
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+// Lines draws series over shared x labels, e.g. one line per campaign over
+// days, with a legend when there is more than one series
+func Lines(labels []string, series []Series) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" width="100%%" role="img" style="font: 11px sans-serif;">`, width, lineHeight)
+	if len(labels) == 0 {
+		empty(&b, lineHeight)
+		return template.HTML(b.String())
+	}
+
+	max := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	max = niceMax(max)
+	plotW := float64(width - marginLeft - marginRight)
+	plotH := float64(lineHeight - marginTop - marginBottom)
+	x := func(i int) float64 {
+		if len(labels) == 1 {
+			return marginLeft + plotW/2
+		}
+		return marginLeft + plotW*float64(i)/float64(len(labels)-1)
+	}
+	y := func(v float64) float64 { return marginTop + plotH - plotH*v/max }
+
+	// Gridlines and y axis labels
+	for i := 0; i <= 4; i++ {
+		v := max * float64(i) / 4
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" stroke="#eee"/>`, marginLeft, width-marginRight, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" fill="#666">%s</text>`, marginLeft-6, y(v)+4, formatValue(v))
+	}
+	// At most about eight x labels, evenly spaced
+	step := (len(labels) + 7) / 8
+	for i := 0; i < len(labels); i += step {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" fill="#666">%s</text>`,
+			x(i), lineHeight-marginBottom+16, template.HTMLEscapeString(labels[i]))
+	}
+
+	for n, s := range series {
+		colour := palette[n%len(palette)]
+		points := make([]string, 0, len(s.Values))
+		for i, v := range s.Values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x(i), y(v)))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"><title>%s</title></polyline>`,
+			strings.Join(points, " "), colour, template.HTMLEscapeString(s.Name))
+		if len(points) == 1 {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, x(0), y(s.Values[0]), colour)
+		}
+	}
+	b.WriteString(`</svg>`)
+
+	if len(series) > 1 {
+		b.WriteString(`<div style="font-size: 0.85em;">`)
+		for n, s := range series {
+			fmt.Fprintf(&b, `<span style="margin-right: 12px;"><span style="display: inline-block; width: 10px; height: 10px; background: %s;"></span> %s</span>`,
+				palette[n%len(palette)], template.HTMLEscapeString(s.Name))
+		}
+		b.WriteString(`</div>`)
+	}
+	return template.HTML(b.String())
+}
+
+// Bars draws one horizontal bar per label, largest first as given
+func Bars(labels []string, values []float64) template.HTML {
+	height := marginTop + marginBottom + barRowHeight*len(labels)
+	if len(labels) == 0 {
+		height = lineHeight / 2
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" width="100%%" role="img" style="font: 11px sans-serif;">`, width, height)
+	if len(labels) == 0 {
+		empty(&b, height)
+		return template.HTML(b.String())
+	}
+
+	max := 0.0
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	max = niceMax(max)
+	plotW := float64(width - marginLeft - marginRight)
+	for i, label := range labels {
+		top := marginTop + barRowHeight*i
+		w := plotW * values[i] / max
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#333">%s</text>`,
+			marginLeft-6, top+barRowHeight/2+4, template.HTMLEscapeString(label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/>`,
+			marginLeft, top+3, w, barRowHeight-6, palette[0])
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" fill="#666">%s</text>`,
+			marginLeft+w+4, top+barRowHeight/2+4, formatValue(values[i]))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// empty labels a chart with nothing to show
+func empty(b *strings.Builder, height int) {
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle" fill="#999">No data in this range</text></svg>`, width/2, height/2)
+}
+
+// niceMax rounds an axis maximum up to 1, 2 or 5 times a power of ten
+func niceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatValue prints axis and bar values, abbreviating thousands and millions
+func formatValue(v float64) string {
+	switch {
+	case v >= 1e6:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", v/1e6), ".0") + "M"
+	case v >= 1e4:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", v/1e3), ".0") + "k"
+	case v == math.Trunc(v):
+		return fmt.Sprintf("%.0f", v)
+	default:
+		return fmt.Sprintf("%.1f", v)
+	}
+}
@@ -0,0 +1,441 @@
+// Package config holds every tunable of the server in one typed struct.
+// Values are layered: built-in defaults, then a YAML or TOML file, then
+// environment variables, then command-line flags, each overriding the last.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"rockbot-adserver/internal/logging"
+	"rockbot-adserver/internal/reqlog"
+	"rockbot-adserver/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Fields are bound to an environment variable with the env tag and to a
+// command-line flag with the flag tag. Fields tagged secret are masked when
+// the configuration is printed. Lists are comma-separated in env and flags.
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	Media     Media     `yaml:"media" toml:"media"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	Serving   Serving   `yaml:"serving" toml:"serving"`
+	Campaigns Campaigns `yaml:"campaigns" toml:"campaigns"`
+	Creatives Creatives `yaml:"creatives" toml:"creatives"`
+	Logging   Logging   `yaml:"logging" toml:"logging"`
+	Reporting Reporting `yaml:"reporting" toml:"reporting"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	Metrics   Metrics   `yaml:"metrics" toml:"metrics"`
+}
+
+type Server struct {
+	Addr string `yaml:"addr" toml:"addr" env:"LISTEN_ADDR" flag:"addr" help:"address to listen on"`
+	// TLS is served when both files are set; they are reloaded when replaced
+	TLSCertFile string `yaml:"tls_cert_file" toml:"tls_cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" help:"PEM certificate chain for HTTPS"`
+	TLSKeyFile  string `yaml:"tls_key_file" toml:"tls_key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" help:"PEM private key for HTTPS"`
+	// Timeouts of zero mean none. Read and write timeouts cover whole
+	// requests, so they must allow for large creative uploads.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"READ_HEADER_TIMEOUT" flag:"read-header-timeout" help:"time allowed to read request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"READ_TIMEOUT" flag:"read-timeout" help:"time allowed to read a whole request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"WRITE_TIMEOUT" flag:"write-timeout" help:"time allowed to handle a request and write the response"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"IDLE_TIMEOUT" flag:"idle-timeout" help:"how long idle keep-alive connections stay open"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish on
+	// SIGTERM or SIGINT
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" help:"time allowed for in-flight requests on shutdown"`
+}
+
+// TLS reports whether the server should serve HTTPS
+func (s Server) TLS() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+type Database struct {
+	Path string `yaml:"path" toml:"path" env:"DB_PATH" flag:"db-path" help:"SQLite database file"`
+}
+
+type Media struct {
+	// Dir defaults to "media" next to the database file and BaseURL to
+	// /media under serving.tracking_base_url
+	Dir     string `yaml:"dir" toml:"dir" env:"MEDIA_DIR" flag:"media-dir" help:"directory uploaded creatives are written to"`
+	BaseURL string `yaml:"base_url" toml:"base_url" env:"MEDIA_BASE_URL" flag:"media-base-url" help:"public URL of the media directory"`
+}
+
+type Auth struct {
+	SessionTTL   time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SESSION_TTL" flag:"session-ttl" help:"how long a login session lasts"`
+	CookieSecure bool          `yaml:"cookie_secure" toml:"cookie_secure" env:"COOKIE_SECURE" flag:"cookie-secure" help:"set the Secure flag on the session cookie"`
+	// DeviceSignatureWindow is how far a signed /vast URL's timestamp may
+	// be from the server clock
+	DeviceSignatureWindow time.Duration `yaml:"device_signature_window" toml:"device_signature_window" env:"DEVICE_SIGNATURE_WINDOW" flag:"device-signature-window" help:"accepted clock skew of signed device requests"`
+}
+
+// Serving limits how much ad time one client gets
+type Serving struct {
+	RateLimitSeconds int           `yaml:"rate_limit_seconds" toml:"rate_limit_seconds" env:"RATE_LIMIT_SECONDS" flag:"rate-limit-seconds" help:"seconds of ads a client may be served per window"`
+	RateLimitWindow  time.Duration `yaml:"rate_limit_window" toml:"rate_limit_window" env:"RATE_LIMIT_WINDOW" flag:"rate-limit-window" help:"window the rate limit is counted over"`
+	// TrackingBaseURL is this server as players reach it; VAST responses
+	// link to its /vast/events endpoint. It has no default, so a deployment
+	// can't end up handing players localhost links.
+	TrackingBaseURL string `yaml:"tracking_base_url" toml:"tracking_base_url" env:"TRACKING_BASE_URL" flag:"tracking-base-url" help:"public URL players send tracking events to"`
+}
+
+type Campaigns struct {
+	// DeletedRetentionDays is how long deleted campaigns and ads are kept
+	// before the purge-deleted command may remove them for good
+	DeletedRetentionDays int `yaml:"deleted_retention_days" toml:"deleted_retention_days" env:"DELETED_RETENTION_DAYS" flag:"deleted-retention-days" help:"days deleted campaigns and ads are kept before they can be purged"`
+}
+
+type Creatives struct {
+	// AllowedCodecs replaces the built-in codec list when set
+	AllowedCodecs []string `yaml:"allowed_codecs" toml:"allowed_codecs" env:"ALLOWED_CODECS" flag:"allowed-codecs" help:"codecs uploaded creatives may use"`
+	// Seed creatives are added on startup if missing; file only
+	Seed []SeedCreative `yaml:"seed" toml:"seed"`
+}
+
+// SeedCreative is a hosted creative every install starts with
+type SeedCreative struct {
+	ID              string `yaml:"id" toml:"id"`
+	Name            string `yaml:"name" toml:"name"`
+	MediaURL        string `yaml:"media_url" toml:"media_url"`
+	MimeType        string `yaml:"mime_type" toml:"mime_type"`
+	DurationSeconds int    `yaml:"duration_seconds" toml:"duration_seconds"`
+}
+
+type Logging struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" flag:"log-level" help:"debug, info, warn or error"`
+	// MaxBodyBytes truncates request and response bodies in the request log
+	MaxBodyBytes  int           `yaml:"max_body_bytes" toml:"max_body_bytes" env:"LOG_MAX_BODY_BYTES" flag:"log-max-body-bytes" help:"request log body size limit"`
+	SampleRates   string        `yaml:"sample_rates" toml:"sample_rates" env:"LOG_SAMPLE_RATES" flag:"log-sample-rates" help:"fraction of requests logged per path prefix, e.g. /vast=0.01"`
+	QueueSize     int           `yaml:"queue_size" toml:"queue_size" env:"LOG_QUEUE_SIZE" flag:"log-queue-size" help:"request logs buffered before new ones are dropped"`
+	BatchSize     int           `yaml:"batch_size" toml:"batch_size" env:"LOG_BATCH_SIZE" flag:"log-batch-size" help:"request logs written per transaction"`
+	FlushInterval time.Duration `yaml:"flush_interval" toml:"flush_interval" env:"LOG_FLUSH_INTERVAL" flag:"log-flush-interval" help:"longest a request log waits in the queue"`
+	RetentionDays int           `yaml:"retention_days" toml:"retention_days" env:"LOG_RETENTION_DAYS" flag:"log-retention-days" help:"days request logs are kept, 0 for ever"`
+	ArchiveDir    string        `yaml:"archive_dir" toml:"archive_dir" env:"LOG_ARCHIVE_DIR" flag:"log-archive-dir" help:"directory expired request logs are archived to"`
+	// Redaction rules are added to reqlog.DefaultRedaction, never replace it
+	RedactHeaders   []string `yaml:"redact_headers" toml:"redact_headers" env:"LOG_REDACT_HEADERS" flag:"log-redact-headers" help:"extra headers scrubbed from request logs"`
+	RedactFields    []string `yaml:"redact_fields" toml:"redact_fields" env:"LOG_REDACT_FIELDS" flag:"log-redact-fields" help:"extra form fields scrubbed from request logs"`
+	RedactJSONPaths []string `yaml:"redact_json_paths" toml:"redact_json_paths" env:"LOG_REDACT_JSON_PATHS" flag:"log-redact-json-paths" help:"extra JSON paths scrubbed from request logs"`
+}
+
+// Reporting controls the rollup aggregator behind the delivery reports
+type Reporting struct {
+	RollupInterval time.Duration `yaml:"rollup_interval" toml:"rollup_interval" env:"ROLLUP_INTERVAL" flag:"rollup-interval" help:"how often report rollups are refreshed"`
+	// RollupGrace is how long after an hour ends its rollup is still
+	// recomputed on every run
+	RollupGrace time.Duration `yaml:"rollup_grace" toml:"rollup_grace" env:"ROLLUP_GRACE" flag:"rollup-grace" help:"how long past hours keep being recomputed"`
+}
+
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" help:"none, otlp or stdout"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACE_SAMPLE_RATIO" flag:"trace-sample-ratio" help:"fraction of new traces kept"`
+}
+
+type Metrics struct {
+	Token string `yaml:"token" toml:"token" env:"METRICS_TOKEN" flag:"metrics-token" secret:"true" help:"bearer token required to scrape /metrics"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       10 * time.Minute,
+			WriteTimeout:      10 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: Database{Path: "adserver.db"},
+		Auth: Auth{
+			SessionTTL:            12 * time.Hour,
+			CookieSecure:          true,
+			DeviceSignatureWindow: 5 * time.Minute,
+		},
+		Serving:   Serving{RateLimitSeconds: 300, RateLimitWindow: time.Hour},
+		Campaigns: Campaigns{DeletedRetentionDays: 90},
+		Creatives: Creatives{Seed: []SeedCreative{
+			{ID: "creative-1", Name: "ForBiggerBlazes", MediaURL: "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerBlazes.mp4", MimeType: "video/mp4", DurationSeconds: 15},
+			{ID: "creative-2", Name: "ForBiggerEscapes", MediaURL: "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerEscapes.mp4", MimeType: "video/mp4", DurationSeconds: 15},
+			{ID: "creative-3", Name: "ForBiggerFun", MediaURL: "http://commondatastorage.googleapis.com/gtv-videos-bucket/sample/ForBiggerFun.mp4", MimeType: "video/mp4", DurationSeconds: 15},
+		}},
+		Logging: Logging{
+			Level:         "info",
+			MaxBodyBytes:  10000,
+			QueueSize:     10000,
+			BatchSize:     200,
+			FlushInterval: time.Second,
+			RetentionDays: 30,
+		},
+		Reporting: Reporting{RollupInterval: time.Minute, RollupGrace: 5 * time.Minute},
+		Tracing:   Tracing{Exporter: tracing.ExporterNone, SampleRatio: 1},
+	}
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and the flags in args. It returns the
+// arguments left after the flags, i.e. an admin subcommand and its options.
+// With -print-config the effective configuration is written to out and
+// printed is true, so the caller can exit.
+func Load(args []string, getenv func(string) string, out io.Writer) (cfg Config, rest []string, printed bool, err error) {
+	cfg = Default()
+
+	fs := flag.NewFlagSet("adserver", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets masked and exit")
+	flagValues := map[string]string{}
+	for _, f := range fields(&cfg) {
+		name := f.tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		record := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(name, f.tag.Get("help"), record)
+		} else {
+			fs.Func(name, f.tag.Get("help"), record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, false, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return cfg, nil, false, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields(&cfg) {
+		if name := f.tag.Get("env"); name != "" {
+			if v := getenv(name); v != "" {
+				if err := setField(f.value, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+		}
+	}
+	for _, f := range fields(&cfg) {
+		if name := f.tag.Get("flag"); name != "" {
+			if v, ok := flagValues[name]; ok {
+				if err := setField(f.value, v); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, nil, false, errors.Join(errs...)
+	}
+
+	if cfg.Media.Dir == "" {
+		cfg.Media.Dir = filepath.Join(filepath.Dir(cfg.Database.Path), "media")
+	}
+	if cfg.Media.BaseURL == "" && cfg.Serving.TrackingBaseURL != "" {
+		cfg.Media.BaseURL = strings.TrimSuffix(cfg.Serving.TrackingBaseURL, "/") + "/media"
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, nil, false, err
+	}
+
+	if *printConfig {
+		return cfg, fs.Args(), true, cfg.Print(out)
+	}
+	return cfg, fs.Args(), false, nil
+}
+
+// loadFile reads a YAML (.yaml, .yml) or TOML (.toml) file over cfg. Keys
+// the file leaves out keep their current values; unknown keys are errors.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config format, want .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once. Settings only the server
+// needs, not the admin subcommands, are checked by ValidateServing.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file and server.tls_key_file must be set together")
+	check(c.Server.ReadHeaderTimeout >= 0 && c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0, "server timeouts must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Database.Path != "", "database.path is required")
+	check(c.Auth.SessionTTL > 0, "auth.session_ttl must be positive")
+	check(c.Auth.DeviceSignatureWindow > 0, "auth.device_signature_window must be positive")
+	check(c.Serving.RateLimitSeconds > 0, "serving.rate_limit_seconds must be positive")
+	check(c.Serving.RateLimitWindow > 0, "serving.rate_limit_window must be positive")
+	check(c.Campaigns.DeletedRetentionDays >= 0, "campaigns.deleted_retention_days must not be negative")
+	for i, s := range c.Creatives.Seed {
+		check(s.ID != "" && s.Name != "" && s.MediaURL != "", "creatives.seed[%d] needs an id, name and media_url", i)
+		check(s.DurationSeconds > 0, "creatives.seed[%d].duration_seconds must be positive", i)
+	}
+	_, err := logging.ParseLevel(c.Logging.Level)
+	check(err == nil, "logging.level: %v", err)
+	check(c.Logging.MaxBodyBytes > 0, "logging.max_body_bytes must be positive")
+	_, err = reqlog.ParseSampling(c.Logging.SampleRates)
+	check(err == nil, "logging.sample_rates: %v", err)
+	check(c.Logging.QueueSize > 0, "logging.queue_size must be positive")
+	check(c.Logging.BatchSize > 0, "logging.batch_size must be positive")
+	check(c.Logging.FlushInterval > 0, "logging.flush_interval must be positive")
+	check(c.Logging.RetentionDays >= 0, "logging.retention_days must not be negative")
+	check(c.Reporting.RollupInterval > 0, "reporting.rollup_interval must be positive")
+	check(c.Reporting.RollupGrace >= 0, "reporting.rollup_grace must not be negative")
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		check(false, "tracing.exporter must be none, otlp or stdout")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	return errors.Join(errs...)
+}
+
+// ValidateServing checks the settings only the server itself needs, not
+// the admin subcommands: the public URLs written into VAST responses
+func (c Config) ValidateServing() error {
+	if !publicURL(c.Serving.TrackingBaseURL) {
+		return errors.New("serving.tracking_base_url is required: the http(s) URL players reach this server at, e.g. https://ads.example.com")
+	}
+	// media.base_url follows serving.tracking_base_url unless set
+	if !publicURL(c.Media.BaseURL) {
+		return errors.New("media.base_url must be an http(s) URL")
+	}
+	return nil
+}
+
+// publicURL reports whether s is an absolute http or https URL
+func publicURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Masked returns a copy with secret values replaced, safe to print or log
+func (c Config) Masked() Config {
+	for _, f := range fields(&c) {
+		if f.tag.Get("secret") == "true" && f.value.String() != "" {
+			f.value.SetString("********")
+		}
+	}
+	return c
+}
+
+// Print writes the masked configuration as YAML, in the same shape a config
+// file takes
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Masked()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type field struct {
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+// fields lists the settable leaf fields of cfg, in declaration order
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if v.Field(i).Kind() == reflect.Struct {
+				walk(v.Field(i))
+				continue
+			}
+			out = append(out, field{value: v.Field(i), tag: t.Field(i).Tag})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return out
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField parses an env or flag value into a config field
+func setField(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
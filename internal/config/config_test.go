@@ -0,0 +1,43 @@
+package config
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTrackingBaseURLOnlyRequiredToServe(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	cfg, rest, _, err := Load([]string{"purge-deleted", "-days", "30"}, getenv, io.Discard)
+	if err != nil {
+		t.Fatalf("subcommand without tracking_base_url: %v", err)
+	}
+	if len(rest) != 3 || rest[0] != "purge-deleted" {
+		t.Errorf("got args %v", rest)
+	}
+	if err := cfg.ValidateServing(); err == nil {
+		t.Error("serving without tracking_base_url was accepted")
+	}
+
+	env["TRACKING_BASE_URL"] = "localhost:8080"
+	cfg, _, _, err = Load(nil, getenv, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ValidateServing(); err == nil {
+		t.Error("tracking_base_url without a scheme was accepted")
+	}
+
+	env["TRACKING_BASE_URL"] = "https://ads.example.com/"
+	cfg, _, _, err = Load(nil, getenv, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ValidateServing(); err != nil {
+		t.Errorf("valid tracking_base_url: %v", err)
+	}
+	if cfg.Media.BaseURL != "https://ads.example.com/media" {
+		t.Errorf("media.base_url = %q, want it derived from tracking_base_url", cfg.Media.BaseURL)
+	}
+}
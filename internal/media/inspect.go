@@ -0,0 +1,341 @@
+// Package media extracts technical metadata from uploaded video creatives.
+// It understands the ISO base media file format used by MP4 and QuickTime
+// MOV files and needs no external tools such as ffprobe.
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupported is returned for files that are not MP4/MOV containers
+var ErrUnsupported = errors.New("unsupported media container")
+
+// Info is what Inspect learns about a media file
+type Info struct {
+	Container   string  `json:"container"` // "mp4" or "mov"
+	Duration    float64 `json:"duration"`  // seconds
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	VideoCodec  string  `json:"video_codec"`
+	VideoFourCC string  `json:"video_fourcc"`
+	AudioCodec  string  `json:"audio_codec,omitempty"`
+	BitrateBps  int64   `json:"bitrate_bps"`
+	SizeBytes   int64   `json:"size_bytes"`
+}
+
+// codecNames maps sample entry four-character codes to codec names
+var codecNames = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"apch": "prores",
+	"apcn": "prores",
+	"apcs": "prores",
+	"apco": "prores",
+	"ap4h": "prores",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"alac": "alac",
+	"lpcm": "pcm",
+	"sowt": "pcm",
+	"twos": "pcm",
+}
+
+// box is an ISO BMFF box header together with where its payload lives
+type box struct {
+	typ    string
+	offset int64 // start of payload
+	size   int64 // payload size
+}
+
+// Inspect reads the container structure of an MP4/MOV file. Only the box
+// headers and the small metadata boxes under moov are read; media data is
+// skipped with Seek, so large files are cheap to inspect.
+func Inspect(r io.ReadSeeker) (*Info, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := readBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{SizeBytes: size}
+	var moov *box
+	for i := range top {
+		switch top[i].typ {
+		case "ftyp":
+			brand, err := readAt(r, top[i].offset, 4)
+			if err != nil {
+				return nil, err
+			}
+			info.Container = "mp4"
+			if string(brand) == "qt  " {
+				info.Container = "mov"
+			}
+		case "moov":
+			moov = &top[i]
+		}
+	}
+	if moov == nil {
+		return nil, fmt.Errorf("%w: no moov box", ErrUnsupported)
+	}
+	if info.Container == "" {
+		// Old QuickTime files may omit ftyp entirely
+		info.Container = "mov"
+	}
+
+	children, err := readBoxes(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range children {
+		switch b.typ {
+		case "mvhd":
+			if info.Duration, err = readMovieDuration(r, b); err != nil {
+				return nil, err
+			}
+		case "trak":
+			if err := readTrack(r, b, info); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if info.VideoCodec == "" {
+		return nil, fmt.Errorf("%w: no video track", ErrUnsupported)
+	}
+	if info.Duration > 0 {
+		info.BitrateBps = int64(float64(size*8) / info.Duration)
+	}
+	return info, nil
+}
+
+// readBoxes lists the boxes laid out back to back between start and end
+func readBoxes(r io.ReadSeeker, start, end int64) ([]box, error) {
+	var boxes []box
+	for pos := start; pos+8 <= end; {
+		hdr, err := readAt(r, pos, 8)
+		if err != nil {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+
+		switch boxSize {
+		case 0: // box extends to the end of its parent
+			boxSize = end - pos
+		case 1: // 64-bit size follows the type
+			ext, err := readAt(r, pos+8, 8)
+			if err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(ext))
+			headerLen = 16
+		}
+		if boxSize < headerLen || boxSize > end-pos {
+			return nil, fmt.Errorf("%w: corrupt box at offset %d", ErrUnsupported, pos)
+		}
+
+		boxes = append(boxes, box{typ: typ, offset: pos + headerLen, size: boxSize - headerLen})
+		pos += boxSize
+	}
+	return boxes, nil
+}
+
+func readAt(r io.ReadSeeker, offset int64, n int) ([]byte, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: truncated file", ErrUnsupported)
+	} else if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readPayload reads a whole (small) box payload
+func readPayload(r io.ReadSeeker, b box, max int64) ([]byte, error) {
+	if b.size > max {
+		return nil, fmt.Errorf("%w: %q box too large", ErrUnsupported, b.typ)
+	}
+	return readAt(r, b.offset, int(b.size))
+}
+
+// readMovieDuration parses mvhd: duration / timescale in seconds
+func readMovieDuration(r io.ReadSeeker, b box) (float64, error) {
+	p, err := readPayload(r, b, 1<<10)
+	if err != nil {
+		return 0, err
+	}
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(p) >= 32 && p[0] == 1:
+		timescale = binary.BigEndian.Uint32(p[20:24])
+		duration = binary.BigEndian.Uint64(p[24:32])
+	case len(p) >= 20:
+		timescale = binary.BigEndian.Uint32(p[12:16])
+		duration = uint64(binary.BigEndian.Uint32(p[16:20]))
+	default:
+		return 0, fmt.Errorf("%w: short mvhd box", ErrUnsupported)
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("%w: zero timescale", ErrUnsupported)
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+// readTrack walks trak -> tkhd and trak -> mdia -> (hdlr, minf -> stbl -> stsd)
+func readTrack(r io.ReadSeeker, trak box, info *Info) error {
+	children, err := readBoxes(r, trak.offset, trak.offset+trak.size)
+	if err != nil {
+		return err
+	}
+
+	var width, height int
+	var handler, fourcc string
+	var entryWidth, entryHeight int
+	for _, b := range children {
+		switch b.typ {
+		case "tkhd":
+			if width, height, err = readTrackDimensions(r, b); err != nil {
+				return err
+			}
+		case "mdia":
+			if handler, fourcc, entryWidth, entryHeight, err = readMedia(r, b); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch handler {
+	case "vide":
+		if info.VideoCodec != "" {
+			return nil // keep the first video track
+		}
+		info.VideoFourCC = fourcc
+		info.VideoCodec = codecName(fourcc)
+		// tkhd holds the display size; fall back to the coded size in stsd
+		if width == 0 || height == 0 {
+			width, height = entryWidth, entryHeight
+		}
+		info.Width, info.Height = width, height
+	case "soun":
+		if info.AudioCodec == "" {
+			info.AudioCodec = codecName(fourcc)
+		}
+	}
+	return nil
+}
+
+// readTrackDimensions parses the 16.16 fixed point width and height from tkhd
+func readTrackDimensions(r io.ReadSeeker, b box) (int, int, error) {
+	p, err := readPayload(r, b, 1<<10)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := 76 // version 0
+	if len(p) > 0 && p[0] == 1 {
+		offset = 88
+	}
+	if len(p) < offset+8 {
+		return 0, 0, fmt.Errorf("%w: short tkhd box", ErrUnsupported)
+	}
+	width := int(binary.BigEndian.Uint32(p[offset:offset+4]) >> 16)
+	height := int(binary.BigEndian.Uint32(p[offset+4:offset+8]) >> 16)
+	return width, height, nil
+}
+
+// readMedia returns the track's handler type and its first sample entry
+func readMedia(r io.ReadSeeker, mdia box) (handler, fourcc string, width, height int, err error) {
+	children, err := readBoxes(r, mdia.offset, mdia.offset+mdia.size)
+	if err != nil {
+		return "", "", 0, 0, err
+	}
+	for _, b := range children {
+		switch b.typ {
+		case "hdlr":
+			p, err := readPayload(r, b, 1<<12)
+			if err != nil {
+				return "", "", 0, 0, err
+			}
+			if len(p) < 12 {
+				return "", "", 0, 0, fmt.Errorf("%w: short hdlr box", ErrUnsupported)
+			}
+			handler = string(p[8:12])
+		case "minf":
+			fourcc, width, height, err = readSampleEntry(r, b)
+			if err != nil {
+				return "", "", 0, 0, err
+			}
+		}
+	}
+	return handler, fourcc, width, height, nil
+}
+
+// readSampleEntry finds minf -> stbl -> stsd and reads the first entry's
+// format and, for video entries, its coded width and height
+func readSampleEntry(r io.ReadSeeker, minf box) (string, int, int, error) {
+	minfChildren, err := readBoxes(r, minf.offset, minf.offset+minf.size)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	for _, stbl := range minfChildren {
+		if stbl.typ != "stbl" {
+			continue
+		}
+		stblChildren, err := readBoxes(r, stbl.offset, stbl.offset+stbl.size)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		for _, stsd := range stblChildren {
+			if stsd.typ != "stsd" {
+				continue
+			}
+			// version/flags (4) + entry_count (4), then the first entry:
+			// size (4), format (4), reserved (6), data_reference_index (2)
+			// and for video: pre_defined/reserved (16), width (2), height (2)
+			n := int64(44)
+			if stsd.size < n {
+				n = stsd.size
+			}
+			if n < 16 {
+				return "", 0, 0, fmt.Errorf("%w: short stsd box", ErrUnsupported)
+			}
+			p, err := readAt(r, stsd.offset, int(n))
+			if err != nil {
+				return "", 0, 0, err
+			}
+			fourcc := string(p[12:16])
+			var width, height int
+			if len(p) >= 44 {
+				width = int(binary.BigEndian.Uint16(p[40:42]))
+				height = int(binary.BigEndian.Uint16(p[42:44]))
+			}
+			return fourcc, width, height, nil
+		}
+	}
+	return "", 0, 0, nil
+}
+
+func codecName(fourcc string) string {
+	if name, ok := codecNames[fourcc]; ok {
+		return name
+	}
+	return fourcc
+}
@@ -0,0 +1,181 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// mkbox builds a box with a 32-bit size header
+func mkbox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	out = append(out, typ...)
+	return append(out, body...)
+}
+
+// mkbox64 builds a box with size 1 and a 64-bit size after the type
+func mkbox64(typ string, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, 1)
+	out = append(out, typ...)
+	out = binary.BigEndian.AppendUint64(out, uint64(16+len(payload)))
+	return append(out, payload...)
+}
+
+// rawbox builds a box header with any size value, for corrupt files
+func rawbox(size uint32, typ string, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, size)
+	out = append(out, typ...)
+	return append(out, payload...)
+}
+
+func ftyp(brand string) []byte {
+	return mkbox("ftyp", []byte(brand), make([]byte, 4), []byte(brand))
+}
+
+// mvhd builds a version 0 or 1 movie header
+func mvhd(version byte, timescale uint32, duration uint64) []byte {
+	p := []byte{version, 0, 0, 0}
+	if version == 1 {
+		p = append(p, make([]byte, 16)...) // creation and modification time
+		p = binary.BigEndian.AppendUint32(p, timescale)
+		p = binary.BigEndian.AppendUint64(p, duration)
+	} else {
+		p = append(p, make([]byte, 8)...)
+		p = binary.BigEndian.AppendUint32(p, timescale)
+		p = binary.BigEndian.AppendUint32(p, uint32(duration))
+	}
+	return mkbox("mvhd", p, make([]byte, 80))
+}
+
+// tkhd builds a version 0 or 1 track header with a 16.16 display size
+func tkhd(version byte, width, height int) []byte {
+	p := make([]byte, 76)
+	if version == 1 {
+		p = make([]byte, 88)
+	}
+	p[0] = version
+	p = binary.BigEndian.AppendUint32(p, uint32(width)<<16)
+	p = binary.BigEndian.AppendUint32(p, uint32(height)<<16)
+	return mkbox("tkhd", p)
+}
+
+// mdia builds a media box with a handler and one sample entry of the given
+// format and coded size
+func mdia(handler, format string, width, height int) []byte {
+	hdlr := mkbox("hdlr", make([]byte, 8), []byte(handler), make([]byte, 13))
+	entry := make([]byte, 16)
+	entry = append(entry, make([]byte, 16)...)
+	entry = binary.BigEndian.AppendUint16(entry, uint16(width))
+	entry = binary.BigEndian.AppendUint16(entry, uint16(height))
+	entry = append(entry, make([]byte, 50)...)
+	binary.BigEndian.PutUint32(entry[0:4], uint32(len(entry)))
+	copy(entry[4:8], format)
+	stsd := mkbox("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, entry)
+	return mkbox("mdia", hdlr, mkbox("minf", mkbox("stbl", stsd)))
+}
+
+func videoTrak(version byte) []byte {
+	return mkbox("trak", tkhd(version, 1920, 1080), mdia("vide", "avc1", 1920, 1088))
+}
+
+func audioTrak() []byte {
+	return mkbox("trak", tkhd(0, 0, 0), mdia("soun", "mp4a", 0, 0))
+}
+
+func file(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+func TestInspect(t *testing.T) {
+	v0 := file(ftyp("isom"), mkbox("moov", mvhd(0, 1000, 15000), videoTrak(0), audioTrak()), mkbox("mdat", make([]byte, 1000)))
+	v1 := file(ftyp("qt  "), mkbox("moov", mvhd(1, 600, 18300), videoTrak(1)), mkbox("mdat", make([]byte, 100)))
+
+	// SizeBytes and BitrateBps follow from the data and are filled in below
+	tests := []struct {
+		name string
+		data []byte
+		want Info
+	}{
+		{
+			name: "version 0 mp4",
+			data: v0,
+			want: Info{Container: "mp4", Duration: 15, Width: 1920, Height: 1080, VideoCodec: "h264", VideoFourCC: "avc1", AudioCodec: "aac"},
+		},
+		{
+			name: "version 1 mov",
+			data: v1,
+			want: Info{Container: "mov", Duration: 30.5, Width: 1920, Height: 1080, VideoCodec: "h264", VideoFourCC: "avc1"},
+		},
+		{
+			name: "no ftyp, size from the sample entry",
+			data: file(mkbox("moov", mvhd(0, 1, 10), mkbox("trak", tkhd(0, 0, 0), mdia("vide", "hvc1", 640, 360)))),
+			want: Info{Container: "mov", Duration: 10, Width: 640, Height: 360, VideoCodec: "hevc", VideoFourCC: "hvc1"},
+		},
+		{
+			name: "size 0 box runs to the end of the file",
+			data: file(ftyp("isom"), mkbox("moov", mvhd(0, 1000, 5000), videoTrak(0)), rawbox(0, "mdat", make([]byte, 500))),
+			want: Info{Container: "mp4", Duration: 5, Width: 1920, Height: 1080, VideoCodec: "h264", VideoFourCC: "avc1"},
+		},
+		{
+			name: "size 1 box with a 64-bit size",
+			data: file(ftyp("isom"), mkbox64("mdat", make([]byte, 300)), mkbox("moov", mvhd(0, 1000, 5000), videoTrak(0))),
+			want: Info{Container: "mp4", Duration: 5, Width: 1920, Height: 1080, VideoCodec: "h264", VideoFourCC: "avc1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Inspect(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("Inspect: %v", err)
+			}
+			want := tt.want
+			want.SizeBytes = int64(len(tt.data))
+			want.BitrateBps = int64(float64(len(tt.data)*8) / want.Duration)
+			if *got != want {
+				t.Errorf("got %+v\nwant %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestInspectRejects(t *testing.T) {
+	valid := file(ftyp("isom"), mkbox("moov", mvhd(0, 1000, 15000), videoTrak(0)), mkbox("mdat", make([]byte, 100)))
+	moovEnd := len(ftyp("isom")) + len(mkbox("moov", mvhd(0, 1000, 15000), videoTrak(0)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty file", nil},
+		{"not a container", []byte("this is not a video file at all")},
+		{"truncated inside moov", valid[:moovEnd-20]},
+		{"truncated inside mdat", valid[:len(valid)-10]},
+		{"box longer than the file", file(ftyp("isom"), rawbox(1<<20, "moov", mvhd(0, 1000, 15000)))},
+		{"box longer than its parent", file(ftyp("isom"), mkbox("moov", rawbox(4096, "mvhd", make([]byte, 100))))},
+		{"box shorter than its header", file(ftyp("isom"), rawbox(4, "moov", nil), mkbox("moov", mvhd(0, 1000, 15000), videoTrak(0)))},
+		{"64-bit size shorter than its header", file(ftyp("isom"), append(rawbox(1, "mdat", nil), 0, 0, 0, 0, 0, 0, 0, 8))},
+		{"64-bit size past the end", file(ftyp("isom"), append(rawbox(1, "mdat", nil), 0x40, 0, 0, 0, 0, 0, 0, 0))},
+		{"64-bit size that overflows", file(ftyp("isom"), append(rawbox(1, "mdat", nil), 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))},
+		{"64-bit size that is negative", file(ftyp("isom"), append(rawbox(1, "mdat", nil), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))},
+		{"oversized mvhd", file(mkbox("moov", mkbox("mvhd", make([]byte, 2048)), videoTrak(0)))},
+		{"short mvhd", file(mkbox("moov", mkbox("mvhd", make([]byte, 10)), videoTrak(0)))},
+		{"zero timescale", file(mkbox("moov", mvhd(0, 0, 15000), videoTrak(0)))},
+		{"short tkhd", file(mkbox("moov", mvhd(0, 1000, 15000), mkbox("trak", mkbox("tkhd", make([]byte, 40)))))},
+		{"short stsd", file(mkbox("moov", mvhd(0, 1000, 15000), mkbox("trak", mkbox("mdia", mkbox("minf", mkbox("stbl", mkbox("stsd", make([]byte, 8))))))))},
+		{"no moov", file(ftyp("isom"), mkbox("mdat", make([]byte, 100)))},
+		{"no video track", file(ftyp("isom"), mkbox("moov", mvhd(0, 1000, 15000), audioTrak()))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Inspect(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatalf("Inspect accepted the file: %+v", info)
+			}
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("got %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// remoteChunk is how much a RemoteFile fetches per request. Inspect reads
+// many small headers close together, so one chunk usually covers several.
+const remoteChunk = 64 << 10
+
+// maxRedirects bounds how many redirects a PublicClient follows
+const maxRedirects = 3
+
+// errNotPublic is returned when a PublicClient would connect to an address
+// that isn't on the public internet
+var errNotPublic = errors.New("address is not public")
+
+// nonPublic are address ranges a PublicClient refuses on top of loopback,
+// private, link-local, multicast and unspecified addresses
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which can reach IPv4 ranges
+}
+
+// isPublic reports whether addr is a public unicast address
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublic {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicClient returns a client for fetching media from URLs that users
+// give us. It only connects to public addresses, checked after DNS
+// resolution on every connection including those for redirects, so it
+// can't be pointed at loopback, the internal network or cloud metadata
+// endpoints. It ignores proxy settings, which would bypass that check.
+func PublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errNotPublic, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to a non-http(s) URL")
+			}
+			return nil
+		},
+	}
+}
+
+// FetchError is returned by OpenRemote and a RemoteFile's reads when the
+// file could not be fetched, as opposed to read as media. Its text describes
+// the remote server, so it is for logs rather than for whoever gave the URL.
+type FetchError struct {
+	Err error
+}
+
+func (e *FetchError) Error() string { return "fetching media: " + e.Err.Error() }
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// RemoteFile reads a hosted file with HTTP range requests, so Inspect can
+// read the few boxes it needs without downloading the media data
+type RemoteFile struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+	pos    int64
+
+	// the last chunk fetched and where it starts
+	buf      []byte
+	bufStart int64
+}
+
+// OpenRemote checks that the server at url answers range requests and
+// learns the file's size
+func OpenRemote(ctx context.Context, client *http.Client, url string) (*RemoteFile, error) {
+	f := &RemoteFile{ctx: ctx, client: client, url: url}
+	resp, err := f.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	// Content-Range: bytes 0-0/12345
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil || size <= 0 {
+		return nil, &FetchError{Err: errors.New("server did not report the file size")}
+	}
+	f.size = size
+	return f, nil
+}
+
+// get requests bytes first to last inclusive. Its errors are FetchErrors.
+func (f *RemoteFile) get(first, last int64) (*http.Response, error) {
+	resp, err := f.fetch(first, last)
+	if err != nil {
+		return nil, &FetchError{Err: err}
+	}
+	return resp, nil
+}
+
+func (f *RemoteFile) fetch(first, last int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", first)) {
+			resp.Body.Close()
+			return nil, errors.New("server answered with the wrong range")
+		}
+		return resp, nil
+	case http.StatusOK:
+		resp.Body.Close()
+		return nil, errors.New("server does not support range requests")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("server answered %s", resp.Status)
+	}
+}
+
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.pos < f.bufStart || f.pos >= f.bufStart+int64(len(f.buf)) {
+		last := f.pos + max(int64(len(p)), remoteChunk) - 1
+		if last >= f.size {
+			last = f.size - 1
+		}
+		resp, err := f.get(f.pos, last)
+		if err != nil {
+			return 0, err
+		}
+		buf, err := io.ReadAll(io.LimitReader(resp.Body, last-f.pos+1))
+		resp.Body.Close()
+		if err != nil {
+			return 0, &FetchError{Err: err}
+		}
+		if len(buf) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		f.buf, f.bufStart = buf, f.pos
+	}
+	n := copy(p, f.buf[f.pos-f.bufStart:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,94 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestInspectRemote(t *testing.T) {
+	data := file(ftyp("isom"), mkbox("mdat", make([]byte, 200<<10)), mkbox("moov", mvhd(0, 1000, 15000), videoTrak(0)))
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/norange.mp4" {
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	remote, err := OpenRemote(context.Background(), srv.Client(), srv.URL+"/video.mp4")
+	if err != nil {
+		t.Fatalf("OpenRemote: %v", err)
+	}
+	got, err := Inspect(remote)
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	want, _ := Inspect(bytes.NewReader(data))
+	if *got != *want {
+		t.Errorf("got %+v\nwant %+v", *got, *want)
+	}
+	if requests > 5 {
+		t.Errorf("made %d requests; the media data should have been skipped", requests)
+	}
+
+	if _, err := OpenRemote(context.Background(), srv.Client(), srv.URL+"/norange.mp4"); err == nil {
+		t.Error("OpenRemote accepted a server without range requests")
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+	}
+	for _, tt := range tests {
+		if got := isPublic(netip.MustParseAddr(tt.addr)); got != tt.public {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.addr, got, tt.public)
+		}
+	}
+}
+
+func TestPublicClientRefusesLocalServers(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	_, err := OpenRemote(context.Background(), PublicClient(time.Second), srv.URL+"/video.mp4")
+	var fetchErr *FetchError
+	if !errors.As(err, &fetchErr) || !errors.Is(err, errNotPublic) {
+		t.Errorf("got %v, want a FetchError refusing the address", err)
+	}
+	if requests != 0 {
+		t.Errorf("the local server got %d requests", requests)
+	}
+}
@@ -3,12 +3,127 @@ package models
 import "time"
 
 type Campaign struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	TargetDMA string    `json:"target_dma"` // "10" or "*"
-	Ads       []Ad      `json:"ads,omitempty"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	TargetDMA      string    `json:"target_dma"`                // numeric DMA code ("501", "10") or "*" for all
+	TenantID       string    `json:"tenant_id,omitempty"`       // owning advertiser; empty for house campaigns
+	ImpressionGoal int       `json:"impression_goal,omitempty"` // booked for the whole flight; 0 means not paced
+	Status         string    `json:"status"`                    // one of the CampaignStatus values, as of when it was read
+	Ads            []Ad      `json:"ads,omitempty"`
+}
+
+// Campaign statuses. Only draft, scheduled, paused and archived are stored;
+// a scheduled campaign reads as live during its flight and completed after.
+const (
+	CampaignStatusDraft     = "draft"
+	CampaignStatusScheduled = "scheduled"
+	CampaignStatusLive      = "live"
+	CampaignStatusPaused    = "paused"
+	CampaignStatusCompleted = "completed"
+	CampaignStatusArchived  = "archived"
+)
+
+// CampaignStatus turns a stored status into the one shown at now
+func CampaignStatus(stored string, start, end, now time.Time) string {
+	if stored != CampaignStatusScheduled {
+		return stored
+	}
+	switch {
+	case now.Before(start):
+		return CampaignStatusScheduled
+	case now.After(end):
+		return CampaignStatusCompleted
+	default:
+		return CampaignStatusLive
+	}
+}
+
+// Campaign revision actions
+const (
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRollback = "rollback"
+)
+
+// CampaignRevision is one change to a campaign, with full snapshots of the
+// campaign before and after it. Before is nil for a create and After for a
+// delete.
+type CampaignRevision struct {
+	ID         int64     `json:"id"`
+	CampaignID string    `json:"campaign_id"`
+	Action     string    `json:"action"`
+	Before     *Campaign `json:"before"`
+	After      *Campaign `json:"after"`
+	UserID     string    `json:"user_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	// RolledBackTo is the revision whose After a rollback restored
+	RolledBackTo int64         `json:"rolled_back_to,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+// FieldChange is one difference between two campaign snapshots
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// CampaignTemplate holds the settings a new campaign starts from. The flight
+// is a length, placed when the template is used.
+type CampaignTemplate struct {
+	ID             string    `json:"id"`
+	TenantID       string    `json:"tenant_id,omitempty"` // empty for templates shared with every advertiser
+	Name           string    `json:"name"`
+	DurationDays   int       `json:"duration_days"`
+	TargetDMA      string    `json:"target_dma"`
+	ImpressionGoal int       `json:"impression_goal,omitempty"`
+	CreativeID     string    `json:"creative_id,omitempty"`
+	CreatedBy      string    `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CampaignRecord is a campaign as bulk import and export read and write it,
+// with its ads named by their library creatives. An empty ID imports as a
+// new campaign.
+type CampaignRecord struct {
+	ID             string    `json:"id,omitempty"`
+	Name           string    `json:"name"`
+	TenantID       string    `json:"tenant_id,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	TargetDMA      string    `json:"target_dma"`
+	ImpressionGoal int       `json:"impression_goal,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	CreativeIDs    []string  `json:"creative_ids"`
+}
+
+// CampaignImport is the outcome of a bulk import. Nothing is written when
+// there are errors or on a dry run; the counts say what would be.
+type CampaignImport struct {
+	DryRun    bool             `json:"dry_run"`
+	Rows      int              `json:"rows"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+// ImportRowError is a problem with one imported row. Rows count from 1; in
+// CSV the header is row 1, so they match spreadsheet line numbers.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"error"`
+}
+
+// CampaignDelivery summarises what a campaign has served so far
+type CampaignDelivery struct {
+	Impressions   int `json:"impressions"`
+	SecondsServed int `json:"seconds_served"`
+	UniqueClients int `json:"unique_clients"`
 }
 
 type Ad struct {
@@ -17,19 +132,151 @@ type Ad struct {
 	MediaURL        string `json:"media_url"`
 	DurationSeconds int    `json:"duration_seconds"`
 	CreativeID      string `json:"creative_id"`
+	// Media details copied from the creative for VAST rendering
+	MimeType   string `json:"mime_type,omitempty"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	BitrateBps int64  `json:"bitrate_bps,omitempty"`
+}
+
+// CreativeAsset is a video file in the creative library. Campaign ads
+// reference it through Ad.CreativeID.
+type CreativeAsset struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	MediaURL        string     `json:"media_url"`
+	StorageKey      string     `json:"storage_key,omitempty"` // empty for externally hosted files
+	MimeType        string     `json:"mime_type"`
+	SizeBytes       int64      `json:"size_bytes"`
+	DurationSeconds int        `json:"duration_seconds"`
+	Container       string     `json:"container,omitempty"` // set when the file was inspected on upload
+	Width           int        `json:"width,omitempty"`
+	Height          int        `json:"height,omitempty"`
+	VideoCodec      string     `json:"video_codec,omitempty"`
+	AudioCodec      string     `json:"audio_codec,omitempty"`
+	BitrateBps      int64      `json:"bitrate_bps,omitempty"`
+	TenantID        string     `json:"tenant_id,omitempty"`
+	Status          string     `json:"status"` // "active" or "retired"
+	CreatedAt       time.Time  `json:"created_at"`
+	RetiredAt       *time.Time `json:"retired_at,omitempty"`
 }
 
+const (
+	CreativeStatusActive  = "active"
+	CreativeStatusRetired = "retired"
+)
+
 type Impression struct {
 	ID              string    `json:"id"`
 	ClientID        string    `json:"client_id"`
 	AdID            string    `json:"ad_id"`
+	CampaignID      string    `json:"campaign_id"`
+	CreativeID      string    `json:"creative_id"`
+	TenantID        string    `json:"tenant_id,omitempty"`
+	DMA             string    `json:"dma"`
 	DurationSeconds int       `json:"duration_seconds"`
 	Timestamp       time.Time `json:"timestamp"`
 }
 
+// Delivery report dimensions, used to group and filter impressions
+const (
+	DimensionCampaign = "campaign"
+	DimensionAd       = "ad"
+	DimensionCreative = "creative"
+	DimensionDMA      = "dma"
+	DimensionClient   = "client"
+	DimensionHour     = "hour"
+	DimensionDay      = "day"
+)
+
+// DeliveryQuery selects and groups impressions for a delivery report.
+// From is inclusive and To exclusive; zero times leave the range open.
+type DeliveryQuery struct {
+	GroupBy []string
+	Filters map[string]string // dimension -> value; hour and day can't be filtered
+	From    time.Time
+	To      time.Time
+	// UniqueClients asks for distinct client counts, which only the raw
+	// impressions can answer
+	UniqueClients bool
+}
+
+// DeliveryRow is one group of a delivery report. Dimensions holds the
+// grouped values keyed by dimension name; hours and days are UTC.
+// UniqueClients is only set for reports read from raw impressions.
+type DeliveryRow struct {
+	Dimensions     map[string]string `json:"dimensions"`
+	Impressions    int               `json:"impressions"`
+	SecondsServed  int               `json:"seconds_served"`
+	UniqueClients  *int              `json:"unique_clients,omitempty"`
+	Completions    int               `json:"completions"`
+	CompletionRate float64           `json:"completion_rate"`
+}
+
+// CampaignPacing compares a campaign's delivery with its impression goal
+// spread evenly over the flight
+type CampaignPacing struct {
+	Campaign  Campaign `json:"campaign"`
+	Delivered int      `json:"delivered"`
+	// Expected is the share of the goal due by now
+	Expected int `json:"expected"`
+	// Pace is Delivered / Expected: below 1 is behind, above 1 ahead
+	Pace float64 `json:"pace"`
+}
+
+// AdRequestSummary counts ad requests by result over a range
+type AdRequestSummary struct {
+	Requests    int     `json:"requests"`
+	Filled      int     `json:"filled"`
+	Empty       int     `json:"empty"`
+	RateLimited int     `json:"rate_limited"`
+	Errors      int     `json:"errors"`
+	FillRate    float64 `json:"fill_rate"` // Filled / Requests
+}
+
+// ClientBudget is how much of a client's rate limit is used. Impressions
+// count from CountingSince: the start of the window, or the client's last
+// reset if that is later.
+type ClientBudget struct {
+	ClientID         string    `json:"client_id"`
+	MaxSeconds       int       `json:"max_seconds"`
+	WindowSeconds    int       `json:"window_seconds"`
+	UsedSeconds      int       `json:"used_seconds"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+	CountingSince    time.Time `json:"counting_since"`
+	// NextReleaseAt is when the oldest counted impression leaves the
+	// window and FullyReleasedAt when the newest does; both are unset when
+	// nothing counts
+	NextReleaseAt   *time.Time   `json:"next_release_at,omitempty"`
+	FullyReleasedAt *time.Time   `json:"fully_released_at,omitempty"`
+	Ads             []BudgetAd   `json:"ads"`
+	LastReset       *BudgetReset `json:"last_reset,omitempty"`
+}
+
+// BudgetAd is what one ad took out of a client's budget
+type BudgetAd struct {
+	AdID         string    `json:"ad_id"`
+	CampaignID   string    `json:"campaign_id"`
+	CreativeID   string    `json:"creative_id"`
+	Impressions  int       `json:"impressions"`
+	Seconds      int       `json:"seconds"`
+	LastServedAt time.Time `json:"last_served_at"`
+}
+
+// BudgetReset records who cleared a client's budget, when and why
+type BudgetReset struct {
+	ID             string    `json:"id"`
+	ClientID       string    `json:"client_id"`
+	ClearedSeconds int       `json:"cleared_seconds"`
+	Reason         string    `json:"reason,omitempty"`
+	UserID         string    `json:"user_id,omitempty"`
+	Username       string    `json:"username,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
 // VAST Structures for response generation
 type VAST struct {
-	Version string `xml:"version,attr"`
+	Version string   `xml:"version,attr"`
 	Ad      []VASTAd `xml:"Ad"`
 }
 
@@ -39,24 +286,35 @@ type VASTAd struct {
 }
 
 type InLine struct {
-	AdSystem    string      `xml:"AdSystem"`
-	AdTitle     string      `xml:"AdTitle"`
-	Creatives   Creatives   `xml:"Creatives"`
-	Impression  string      `xml:"Impression"` // URL to ping
+	AdSystem   string        `xml:"AdSystem"`
+	AdTitle    string        `xml:"AdTitle"`
+	Creatives  VASTCreatives `xml:"Creatives"`
+	Impression string        `xml:"Impression"` // URL to ping
 }
 
-type Creatives struct {
-	Creative []Creative `xml:"Creative"`
+type VASTCreatives struct {
+	Creative []VASTCreative `xml:"Creative"`
 }
 
-type Creative struct {
+type VASTCreative struct {
 	ID     string  `xml:"id,attr"`
 	Linear *Linear `xml:"Linear,omitempty"`
 }
 
 type Linear struct {
-	Duration   string     `xml:"Duration"` // HH:MM:SS
-	MediaFiles MediaFiles `xml:"MediaFiles"`
+	Duration       string          `xml:"Duration"` // HH:MM:SS
+	TrackingEvents *TrackingEvents `xml:"TrackingEvents,omitempty"`
+	MediaFiles     MediaFiles      `xml:"MediaFiles"`
+}
+
+type TrackingEvents struct {
+	Tracking []Tracking `xml:"Tracking"`
+}
+
+// Tracking is a URL the player requests when the event happens
+type Tracking struct {
+	Event string `xml:"event,attr"`
+	URL   string `xml:",chardata"`
 }
 
 type MediaFiles struct {
@@ -68,11 +326,91 @@ type MediaFile struct {
 	Type     string `xml:"type,attr"`
 	Width    int    `xml:"width,attr"`
 	Height   int    `xml:"height,attr"`
+	Bitrate  int64  `xml:"bitrate,attr,omitempty"` // kbps
 	URL      string `xml:",chardata"`
 }
 
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	TenantID     string    `json:"tenant_id,omitempty"` // empty for platform staff
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const (
+	RoleAdmin      = "admin"
+	RoleTrafficker = "trafficker"
+	RoleAnalyst    = "analyst"
+	RoleReadOnly   = "read-only"
+)
+
+// Roles lists every valid user role
+var Roles = []string{RoleAdmin, RoleTrafficker, RoleAnalyst, RoleReadOnly}
+
+// Tenant is an agency or an advertiser. Advertisers may belong to an agency
+// through ParentID; agency users see all of their advertisers' data.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"` // "agency" or "advertiser"
+	ParentID  string    `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	TenantTypeAgency     = "agency"
+	TenantTypeAdvertiser = "advertiser"
+)
+
+// TenantScope limits which tenants' rows a store query may see. The zero
+// value matches nothing; AllTenants is for platform staff and internal jobs
+// such as ad serving.
+type TenantScope struct {
+	All       bool
+	TenantIDs []string
+}
+
+var AllTenants = TenantScope{All: true}
+
+// Includes reports whether rows owned by tenantID are visible in the scope
+func (s TenantScope) Includes(tenantID string) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.TenantIDs {
+		if id == tenantID && id != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Session is a logged-in browser session. The raw token only ever lives in
+// the user's cookie; the database keeps its SHA-256 hash.
+type Session struct {
+	TokenHash string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Device is a screen or player allowed to request ads from /vast. Ads it
+// is served count against ClientID's rate limit, whatever the request says.
+type Device struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Venue     string     `json:"venue,omitempty"`
+	ClientID  string     `json:"client_id"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
 type RequestLog struct {
 	ID              string    `json:"id"`
+	RequestID       string    `json:"request_id"`
 	Method          string    `json:"method"`
 	Path            string    `json:"path"`
 	QueryParams     string    `json:"query_params"`
@@ -0,0 +1,158 @@
+// Package reqlog decides what the request log keeps: which requests are
+// recorded, which secrets are scrubbed from them, and how long they stay.
+package reqlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Redacted replaces every scrubbed value
+const Redacted = "[redacted]"
+
+// Redaction lists the values that must never reach the request log
+type Redaction struct {
+	// Headers are request and response header names, matched case-insensitively
+	Headers []string
+	// Fields are form fields and query parameters, matched case-insensitively
+	Fields []string
+	// JSONPaths are dot-separated paths into JSON request and response
+	// bodies, e.g. "password" or "devices.*.secret". "*" matches any object
+	// member or array element.
+	JSONPaths []string
+}
+
+// DefaultRedaction covers the credentials this server handles: session
+// cookies, device keys, CSRF tokens, URL signatures, passwords and the
+// device secrets returned when a device is issued
+var DefaultRedaction = Redaction{
+	Headers:   []string{"Authorization", "Cookie", "Set-Cookie", "X-CSRF-Token"},
+	Fields:    []string{"password", "csrf_token", "sig"},
+	JSONPaths: []string{"password", "secret", "api_key"},
+}
+
+// Header returns a copy of h with the redacted headers' values replaced
+func (rd Redaction) Header(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range rd.Headers {
+		if _, ok := out[http.CanonicalHeaderKey(name)]; ok {
+			out.Set(name, Redacted)
+		}
+	}
+	return out
+}
+
+// Query redacts fields in a URL query string
+func (rd Redaction) Query(rawQuery string) string {
+	if rawQuery == "" || len(rd.Fields) == 0 {
+		return rawQuery
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Redacted
+	}
+	if !rd.redactValues(values) {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+// Body redacts a request or response body of the given content type. Form
+// bodies have their fields redacted and JSON bodies their paths; a body that
+// claims to be one of these but doesn't parse is dropped entirely, since it
+// can't be checked.
+func (rd Redaction) Body(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		return rd.Query(string(body))
+	case strings.HasPrefix(contentType, "application/json"),
+		strings.HasPrefix(contentType, "application/merge-patch+json"):
+		if len(rd.JSONPaths) == 0 {
+			return string(body)
+		}
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return Redacted
+		}
+		changed := false
+		for _, path := range rd.JSONPaths {
+			if redactPath(doc, strings.Split(path, ".")) {
+				changed = true
+			}
+		}
+		if !changed {
+			return string(body)
+		}
+		out, err := json.Marshal(doc)
+		if err != nil {
+			return Redacted
+		}
+		return string(out)
+	}
+	return string(body)
+}
+
+func (rd Redaction) redactValues(values url.Values) bool {
+	changed := false
+	for key := range values {
+		for _, field := range rd.Fields {
+			if strings.EqualFold(key, field) {
+				for i := range values[key] {
+					values[key][i] = Redacted
+				}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// redactPath replaces the value at path in doc and reports whether anything matched
+func redactPath(doc interface{}, path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	key, rest := path[0], path[1:]
+	changed := false
+
+	visit := func(get func() interface{}, set func()) {
+		if len(rest) == 0 {
+			set()
+			changed = true
+		} else if redactPath(get(), rest) {
+			changed = true
+		}
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range node {
+			if key == "*" || k == key {
+				k, v := k, v
+				visit(func() interface{} { return v }, func() { node[k] = Redacted })
+			}
+		}
+	case []interface{}:
+		for i, v := range node {
+			if key == "*" || strconv.Itoa(i) == key {
+				i, v := i, v
+				visit(func() interface{} { return v }, func() { node[i] = Redacted })
+			}
+		}
+	}
+	return changed
+}
+
+// Policy is what LoggingMiddleware applies to every request
+type Policy struct {
+	Redaction Redaction
+	Sampling  Sampling
+	// MaxBodyBytes is where request and response bodies are truncated
+	MaxBodyBytes int
+}
@@ -0,0 +1,88 @@
+package reqlog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"time"
+)
+
+// Retention deletes request logs older than MaxAge, first writing them to a
+// gzipped JSON Lines file in ArchiveDir when one is set
+type Retention struct {
+	Store      *store.Store
+	MaxAge     time.Duration
+	ArchiveDir string
+	Interval   time.Duration
+}
+
+// Start runs the job now and then every Interval until stop is closed. The
+// returned channel is closed once it has stopped, after finishing any run
+// in progress.
+func (r *Retention) Start(stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		for {
+			if n, err := r.RunOnce(time.Now()); err != nil {
+				slog.Error("Request log retention failed", "error", err)
+			} else if n > 0 {
+				slog.Info("Request log retention removed old logs", "count", n, "archive_dir", r.ArchiveDir)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// RunOnce removes the logs that have expired at now and returns how many
+// were removed. If archiving fails nothing is deleted.
+func (r *Retention) RunOnce(now time.Time) (int64, error) {
+	cutoff := now.Add(-r.MaxAge)
+	if r.ArchiveDir != "" {
+		if err := r.archive(cutoff, now); err != nil {
+			return 0, fmt.Errorf("archive: %w", err)
+		}
+	}
+	return r.Store.DeleteRequestLogsBefore(cutoff)
+}
+
+func (r *Retention) archive(cutoff, now time.Time) error {
+	if err := os.MkdirAll(r.ArchiveDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(r.ArchiveDir, "request_logs-"+now.UTC().Format("20060102T150405Z")+".jsonl.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	count := 0
+	err = r.Store.EachRequestLogBefore(cutoff, func(l models.RequestLog) error {
+		count++
+		return enc.Encode(l)
+	})
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || count == 0 {
+		os.Remove(path)
+	}
+	return err
+}
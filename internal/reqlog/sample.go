@@ -0,0 +1,53 @@
+package reqlog
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Sampling keeps a fraction of the requests to some routes. Rates are keyed
+// by path prefix; the longest matching prefix wins and unmatched paths are
+// always logged.
+type Sampling struct {
+	rates map[string]float64
+}
+
+// ParseSampling reads rates written as "/vast=0.01,/api/logs=0"
+func ParseSampling(spec string) (Sampling, error) {
+	s := Sampling{rates: map[string]float64{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, rateStr, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return Sampling{}, fmt.Errorf("invalid sampling rule %q, want /path=rate", entry)
+		}
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return Sampling{}, fmt.Errorf("invalid sampling rate in %q, want a number from 0 to 1", entry)
+		}
+		s.rates[prefix] = rate
+	}
+	return s, nil
+}
+
+// Rate returns the fraction of requests to path that are logged
+func (s Sampling) Rate(path string) float64 {
+	rate, longest := 1.0, -1
+	for prefix, r := range s.rates {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+	return rate
+}
+
+// Sampled decides whether one request to path is logged
+func (s Sampling) Sampled(path string) bool {
+	rate := s.Rate(path)
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
@@ -0,0 +1,116 @@
+package reqlog
+
+import (
+	"log/slog"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Writer saves request logs off the request path. Logs queue in a bounded
+// channel and a single goroutine inserts them in batches, one transaction
+// per batch. When the queue is full new logs are dropped and counted rather
+// than slowing requests down.
+type Writer struct {
+	store         *store.Store
+	queue         chan models.RequestLog
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// NewWriter starts a writer that buffers up to queueSize logs and writes
+// them batchSize at a time, or every flushInterval if fewer are waiting
+func NewWriter(store *store.Store, queueSize, batchSize int, flushInterval time.Duration) *Writer {
+	w := &Writer{
+		store:         store,
+		queue:         make(chan models.RequestLog, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Enqueue queues a log for writing without blocking. It reports false if
+// the log was dropped because the queue is full or the writer is closed.
+func (w *Writer) Enqueue(l models.RequestLog) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return false
+	}
+	select {
+	case w.queue <- l:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns how many logs have been dropped since the writer started
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Queued returns how many logs are waiting to be written
+func (w *Writer) Queued() int {
+	return len(w.queue)
+}
+
+// Close stops accepting logs and waits until the queued ones are written
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.RequestLog, 0, w.batchSize)
+	var reportedDrops int64
+	flush := func() {
+		if len(batch) > 0 {
+			if err := w.store.SaveRequestLogs(batch); err != nil {
+				slog.Error("Failed to save request logs", "count", len(batch), "error", err)
+			}
+			batch = batch[:0]
+		}
+		if dropped := w.Dropped(); dropped > reportedDrops {
+			slog.Warn("Request log queue full, logs dropped", "dropped", dropped-reportedDrops)
+			reportedDrops = dropped
+		}
+	}
+
+	for {
+		select {
+		case l, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, l)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
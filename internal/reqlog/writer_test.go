@@ -0,0 +1,77 @@
+package reqlog
+
+import (
+	"path/filepath"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openBenchStore opens a throwaway database that is closed when b ends
+func openBenchStore(b *testing.B) *store.Store {
+	b.Helper()
+	db, err := store.NewStore(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// benchRequestLog is a typical /vast log
+func benchRequestLog() models.RequestLog {
+	return models.RequestLog{
+		ID:              uuid.New().String(),
+		Method:          "GET",
+		Path:            "/vast",
+		QueryParams:     "client_id=bench&dma=501",
+		RequestHeaders:  `{"Accept":["*/*"]}`,
+		ResponseStatus:  200,
+		ResponseHeaders: `{"Content-Type":["application/xml"]}`,
+		ResponseBody:    strings.Repeat("<VAST/>", 200),
+		DurationMs:      1,
+		Timestamp:       time.Now(),
+		RemoteAddr:      "127.0.0.1:1234",
+		UserAgent:       "bench",
+	}
+}
+
+// BenchmarkSaveRequestLogSync measures what a request paid when each log was
+// one INSERT on the request path
+func BenchmarkSaveRequestLogSync(b *testing.B) {
+	db := openBenchStore(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := db.SaveRequestLog(benchRequestLog()); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkWriterBatched measures what a request pays with the batched
+// writer: only the enqueue. The queue holds every log, so none are dropped;
+// draining it afterwards is reported separately as drain-ms.
+func BenchmarkWriterBatched(b *testing.B) {
+	db := openBenchStore(b)
+	w := NewWriter(db, b.N, 200, time.Second)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Enqueue(benchRequestLog())
+		}
+	})
+	b.StopTimer()
+
+	start := time.Now()
+	w.Close()
+	b.ReportMetric(float64(time.Since(start).Milliseconds()), "drain-ms")
+	if dropped := w.Dropped(); dropped > 0 {
+		b.Errorf("%d logs dropped", dropped)
+	}
+}
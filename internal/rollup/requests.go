@@ -0,0 +1,55 @@
+package rollup
+
+import (
+	"rockbot-adserver/internal/store"
+	"sync"
+	"time"
+)
+
+// RequestCounts tallies ad requests by hour and result in memory, so
+// serving an ad never waits on a database write for them. The aggregator
+// writes the tallies out on every run, and Flush should be called once
+// more on shutdown.
+type RequestCounts struct {
+	Store *store.Store
+
+	mu     sync.Mutex
+	counts map[store.AdRequestKey]int
+}
+
+// Add counts one ad request answered at with result
+func (c *RequestCounts) Add(result string, at time.Time) {
+	key := store.AdRequestKey{Hour: at.UTC().Truncate(time.Hour), Result: result}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[store.AdRequestKey]int)
+	}
+	c.counts[key]++
+}
+
+// Flush adds the requests counted since the last flush to the stored
+// hourly counts. If that fails they are kept for the next flush.
+func (c *RequestCounts) Flush() error {
+	c.mu.Lock()
+	pending := c.counts
+	c.counts = nil
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := c.Store.AddAdRequestCounts(pending); err != nil {
+		c.mu.Lock()
+		if c.counts == nil {
+			c.counts = pending
+		} else {
+			for key, n := range pending {
+				c.counts[key] += n
+			}
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package rollup
+
+import (
+	"path/filepath"
+	"rockbot-adserver/internal/store"
+	"testing"
+	"time"
+)
+
+func TestRequestCountsFlush(t *testing.T) {
+	db, err := store.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &RequestCounts{Store: db}
+	hour := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	c.Add("filled", hour.Add(5*time.Minute))
+	c.Add("filled", hour.Add(50*time.Minute))
+	c.Add("rate_limited", hour.Add(time.Hour))
+
+	// Nothing is written until the flush
+	if counts, err := db.GetAdRequestCounts(time.Time{}, time.Time{}); err != nil || len(counts) != 0 {
+		t.Fatalf("before flush got %v, %v", counts, err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// A second flush adds only what was counted since
+	c.Add("filled", hour)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := db.GetAdRequestCounts(hour, hour.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["filled"] != 3 || counts["rate_limited"] != 0 {
+		t.Errorf("first hour got %v, want 3 filled", counts)
+	}
+	counts, err = db.GetAdRequestCounts(hour.Add(time.Hour), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["rate_limited"] != 1 {
+		t.Errorf("second hour got %v, want 1 rate_limited", counts)
+	}
+}
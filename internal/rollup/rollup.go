@@ -0,0 +1,130 @@
+// Package rollup keeps the hourly and daily delivery rollups that reports
+// read instead of scanning every impression.
+package rollup
+
+import (
+	"fmt"
+	"log/slog"
+	"rockbot-adserver/internal/store"
+	"time"
+)
+
+// catchUpChunk is how much history one refresh transaction covers while
+// catching up, so ad serving isn't locked out of the database for long
+const catchUpChunk = 24 * time.Hour
+
+// Aggregator refreshes the rollups incrementally. Hours before the
+// watermark are final and only recomputed when a late tracking event marks
+// them dirty; later hours are recomputed on every run. Each refresh
+// recomputes whole buckets, so running twice, or after a crash, gives the
+// same result.
+type Aggregator struct {
+	Store    *store.Store
+	Interval time.Duration
+	// Grace is how long after an hour ends it still gets recomputed, for
+	// requests that were in flight at the boundary
+	Grace time.Duration
+	// Requests, when set, has its ad request tallies written out on every run
+	Requests *RequestCounts
+}
+
+// Start runs the aggregator now and then every Interval until stop is
+// closed. The returned channel is closed once it has stopped, after
+// finishing any run in progress.
+func (a *Aggregator) Start(stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+		for {
+			if err := a.RunOnce(time.Now()); err != nil {
+				slog.Error("Rollup refresh failed", "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// RunOnce brings the rollups up to date as of now. After downtime it
+// catches up from the watermark a day at a time.
+func (a *Aggregator) RunOnce(now time.Time) error {
+	if a.Requests != nil {
+		if err := a.Requests.Flush(); err != nil {
+			return fmt.Errorf("flush ad request counts: %w", err)
+		}
+	}
+
+	now = now.UTC()
+	final := now.Add(-a.Grace).Truncate(time.Hour)
+	open := now.Truncate(time.Hour).Add(time.Hour)
+
+	watermark, err := a.Store.RollupWatermark()
+	if err != nil {
+		return err
+	}
+	if watermark.IsZero() {
+		// First run: start from the first impression
+		first, ok, err := a.Store.EarliestImpressionTime()
+		if err != nil {
+			return err
+		}
+		watermark = final
+		if ok && first.Before(final) {
+			watermark = first.UTC().Truncate(time.Hour)
+		}
+	}
+
+	caughtUp := 0
+	for watermark.Before(final) {
+		next := watermark.Add(catchUpChunk)
+		if next.After(final) {
+			next = final
+		}
+		if err := a.Store.RefreshRollups(watermark, next); err != nil {
+			return fmt.Errorf("refresh %s - %s: %w", watermark.Format(time.RFC3339), next.Format(time.RFC3339), err)
+		}
+		if err := a.Store.SetRollupWatermark(next); err != nil {
+			return err
+		}
+		caughtUp += int(next.Sub(watermark) / time.Hour)
+		watermark = next
+	}
+	if caughtUp > 1 {
+		slog.Info("Rollups caught up", "hours", caughtUp, "watermark", watermark)
+	}
+	if err := a.Store.SetRollupWatermark(watermark); err != nil {
+		return err
+	}
+
+	// Hours that aren't final yet are recomputed every run
+	if err := a.Store.RefreshRollups(watermark, open); err != nil {
+		return fmt.Errorf("refresh open hours: %w", err)
+	}
+
+	// Late tracking events for final hours
+	dirty, err := a.Store.DirtyRollupHours()
+	if err != nil {
+		return err
+	}
+	for _, hour := range dirty {
+		if err := a.Store.RefreshRollups(hour, hour.Add(time.Hour)); err != nil {
+			return fmt.Errorf("refresh dirty hour %s: %w", hour.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// Rebuild discards the rollups and recomputes them from all impressions,
+// e.g. after impressions were corrected by hand
+func (a *Aggregator) Rebuild(now time.Time) error {
+	if err := a.Store.ResetRollups(); err != nil {
+		return err
+	}
+	return a.RunOnce(now)
+}
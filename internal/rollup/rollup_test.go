@@ -0,0 +1,166 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	db, err := store.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestStartFinishesRunBeforeDone(t *testing.T) {
+	db := openTestStore(t)
+	counts := &RequestCounts{Store: db}
+	counts.Add("filled", time.Now())
+	a := &Aggregator{Store: db, Interval: time.Hour, Grace: time.Hour, Requests: counts}
+
+	// Stopped before it starts, the aggregator still finishes its first run
+	// before reporting done, so shutdown can close the database after it
+	stop := make(chan struct{})
+	close(stop)
+	select {
+	case <-a.Start(stop):
+	case <-time.After(5 * time.Second):
+		t.Fatal("aggregator did not stop")
+	}
+
+	watermark, err := db.RollupWatermark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watermark.IsZero() {
+		t.Error("done was closed before the run in progress finished")
+	}
+	requests, err := db.GetAdRequestCounts(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests["filled"] != 1 {
+		t.Errorf("got request counts %v, want the one filled request flushed", requests)
+	}
+}
+
+// seedImpressions stores a campaign with an impression three days before
+// now, two in the hour before last and one in the current hour
+func seedImpressions(t *testing.T, db *store.Store, now time.Time) {
+	t.Helper()
+	c := models.Campaign{ID: "c1", Name: "c1", StartTime: now.Add(-96 * time.Hour), EndTime: now.Add(time.Hour), TargetDMA: "*",
+		Status: models.CampaignStatusScheduled, Ads: []models.Ad{{ID: "ad1", MediaURL: "http://example.com/ad1.mp4", DurationSeconds: 15, CreativeID: "cr1"}}}
+	if err := db.CreateCampaign(c, models.CampaignRevision{Action: models.RevisionCreate}); err != nil {
+		t.Fatal(err)
+	}
+	for i, at := range []time.Time{now.Add(-72 * time.Hour), now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), now} {
+		imp := models.Impression{ID: fmt.Sprintf("imp%d", i), ClientID: "lobby", AdID: "ad1", CampaignID: "c1", CreativeID: "cr1",
+			DMA: "501", DurationSeconds: 15, Timestamp: at}
+		if err := db.RecordImpression(context.Background(), imp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// hourlyDelivery summarises the hourly delivery report, from the rollups or
+// straight from the impressions
+func hourlyDelivery(t *testing.T, db *store.Store, rollups bool) string {
+	t.Helper()
+	q := models.DeliveryQuery{GroupBy: []string{models.DimensionHour, models.DimensionCampaign}}
+	report := db.GetDeliveryReport
+	if rollups {
+		report = db.GetRollupDeliveryReport
+	}
+	rows, err := report(q, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, r := range rows {
+		lines = append(lines, fmt.Sprintf("%s %s: %d impressions, %ds, %d completions",
+			r.Dimensions[models.DimensionHour], r.Dimensions[models.DimensionCampaign], r.Impressions, r.SecondsServed, r.Completions))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestRunOnceIsIdempotent(t *testing.T) {
+	db := openTestStore(t)
+	now := time.Now()
+	seedImpressions(t, db, now)
+	a := &Aggregator{Store: db, Interval: time.Hour, Grace: time.Hour}
+
+	if err := a.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	want := hourlyDelivery(t, db, false)
+	if got := hourlyDelivery(t, db, true); got != want {
+		t.Fatalf("rollups after the first run:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Running again, or rebuilding, recomputes the same buckets
+	if err := a.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := hourlyDelivery(t, db, true); got != want {
+		t.Errorf("rollups after a second run:\n%s\nwant:\n%s", got, want)
+	}
+	if err := a.Rebuild(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := hourlyDelivery(t, db, true); got != want {
+		t.Errorf("rollups after a rebuild:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLateCompletionRecomputesHour(t *testing.T) {
+	db := openTestStore(t)
+	now := time.Now()
+	seedImpressions(t, db, now)
+	a := &Aggregator{Store: db, Interval: time.Hour, Grace: time.Hour}
+	if err := a.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The three-day-old hour is behind the watermark, so only the dirty mark
+	// gets its completion into the rollups
+	if ok, err := db.CompleteImpression("imp0", now); err != nil || !ok {
+		t.Fatalf("CompleteImpression: %v, %v", ok, err)
+	}
+	dirty, err := db.DirtyRollupHours()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := now.Add(-72 * time.Hour).UTC().Truncate(time.Hour)
+	if len(dirty) != 1 || !dirty[0].Equal(hour) {
+		t.Fatalf("dirty hours %v, want [%s]", dirty, hour)
+	}
+	// A repeated completion changes nothing
+	if _, err := db.CompleteImpression("imp0", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	want := hourlyDelivery(t, db, false)
+	if !strings.Contains(want, "15s, 1 completions") {
+		t.Fatalf("the completion is missing from the impressions:\n%s", want)
+	}
+	if got := hourlyDelivery(t, db, true); got != want {
+		t.Errorf("rollups after the late completion:\n%s\nwant:\n%s", got, want)
+	}
+	if dirty, err := db.DirtyRollupHours(); err != nil || len(dirty) != 0 {
+		t.Errorf("dirty hours left after the run: %v, %v", dirty, err)
+	}
+}
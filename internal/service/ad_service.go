@@ -1,76 +1,234 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"rockbot-adserver/internal/metrics"
 	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/rollup"
 	"rockbot-adserver/internal/store"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("rockbot-adserver/internal/service")
+
+// ErrNotFound is returned when the requested campaign or ad does not exist
+var ErrNotFound = errors.New("not found")
+
+// ValidationError describes campaign input that the caller needs to fix
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
+
 type AdService struct {
-	store *store.Store
+	store       *store.Store
+	limits      RateLimit
+	trackingURL string
+	metrics     *ServingMetrics
+	requests    *rollup.RequestCounts
+}
+
+// RateLimit caps the seconds of ads served to one client within a
+// trailing window
+type RateLimit struct {
+	MaxSeconds int
+	Window     time.Duration
+}
+
+// ServingMetrics counts ad decisions. Responses is labelled by result
+// (filled, empty, rate_limited or error) and AdsServed by campaign_id.
+type ServingMetrics struct {
+	Responses *metrics.CounterVec
+	AdsServed *metrics.CounterVec
+}
+
+// SetMetrics makes GetAdsForClient record its decisions in m
+func (s *AdService) SetMetrics(m *ServingMetrics) {
+	s.metrics = m
+}
+
+// SetRequestCounts makes GetAdsForClient tally its decisions in c, which
+// the reports page derives fill rate from
+func (s *AdService) SetRequestCounts(c *rollup.RequestCounts) {
+	s.requests = c
+}
+
+// countResponse counts an ad decision in the metrics and the request
+// tallies. Neither touches the database.
+func (s *AdService) countResponse(result string) {
+	if s.metrics != nil {
+		s.metrics.Responses.Inc(result)
+	}
+	if s.requests != nil {
+		s.requests.Add(result, time.Now())
+	}
+}
+
+// logDecision records which ads a client was given, or why it got none
+func logDecision(ctx context.Context, clientID, dma, result string, ads []models.Ad, usedSeconds int) {
+	campaignIDs := make([]string, len(ads))
+	adIDs := make([]string, len(ads))
+	for i, ad := range ads {
+		campaignIDs[i] = ad.CampaignID
+		adIDs[i] = ad.ID
+	}
+	slog.InfoContext(ctx, "Ad decision",
+		"client_id", clientID,
+		"dma", dma,
+		"result", result,
+		"campaign_ids", campaignIDs,
+		"ad_ids", adIDs,
+		"used_seconds", usedSeconds)
 }
 
-func NewAdService(store *store.Store) *AdService {
-	return &AdService{store: store}
+// NewAdService serves ads within limits. trackingURL is the public base
+// URL of this server, used for the completion tracking links in VAST.
+func NewAdService(store *store.Store, limits RateLimit, trackingURL string) *AdService {
+	return &AdService{store: store, limits: limits, trackingURL: strings.TrimSuffix(trackingURL, "/")}
+}
+
+// CreateCampaign stores a new campaign owned by c.TenantID, which must be an
+// advertiser in scope. Only platform users may create house campaigns. user
+// is recorded in the campaign's history.
+func (s *AdService) CreateCampaign(c models.Campaign, scope models.TenantScope, user *models.User) error {
+	if err := s.prepareNewCampaign(&c, scope); err != nil {
+		return err
+	}
+	return s.store.CreateCampaign(c, newRevision(models.RevisionCreate, user))
 }
 
-func (s *AdService) CreateCampaign(c models.Campaign) error {
+// prepareNewCampaign validates a campaign about to be created and fills in
+// its IDs, default status and ad details
+func (s *AdService) prepareNewCampaign(c *models.Campaign, scope models.TenantScope) error {
 	if c.ID == "" {
 		c.ID = uuid.New().String()
 	}
+	if err := ValidateCampaign(*c); err != nil {
+		return err
+	}
+	switch c.Status {
+	case "":
+		c.Status = models.CampaignStatusScheduled
+	case models.CampaignStatusDraft, models.CampaignStatusScheduled:
+	default:
+		return &ValidationError{Msg: "New campaigns must be draft or scheduled"}
+	}
+	if err := s.checkAdvertiser(c.TenantID, scope); err != nil {
+		return err
+	}
+	if err := s.checkAdIDs(c.ID, c.Ads); err != nil {
+		return err
+	}
+	if err := s.resolveAds(c.Ads, c.TenantID); err != nil {
+		return err
+	}
 	// Assign IDs to ads if missing
 	for i := range c.Ads {
 		if c.Ads[i].ID == "" {
 			c.Ads[i].ID = uuid.New().String()
 		}
 	}
-	return s.store.CreateCampaign(c)
+	return nil
+}
+
+// checkAdvertiser makes sure a new campaign or template may belong to
+// tenantID: an advertiser in scope, or nobody for platform users
+func (s *AdService) checkAdvertiser(tenantID string, scope models.TenantScope) error {
+	if err := checkOwner(scope, tenantID); err != nil {
+		return err
+	}
+	if tenantID == "" {
+		return nil
+	}
+	tenant, err := s.store.GetTenantByID(tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &ValidationError{Msg: "Unknown advertiser"}
+	}
+	if err != nil {
+		return err
+	}
+	if tenant.Type != models.TenantTypeAdvertiser {
+		return &ValidationError{Msg: "Campaigns must belong to an advertiser, not an agency"}
+	}
+	return nil
+}
+
+// newRevision starts a history entry for a change made by user, who is nil
+// for changes made from the command line
+func newRevision(action string, user *models.User) models.CampaignRevision {
+	rev := models.CampaignRevision{Action: action, CreatedAt: time.Now()}
+	if user != nil {
+		rev.UserID, rev.Username = user.ID, user.Username
+	}
+	return rev
 }
 
-func (s *AdService) GetAdsForClient(clientID, dma string) (string, error) {
+// GetAdsForClient picks the ads for one ad request and records them as
+// served. ctx carries the request ID for the decision log and the trace the
+// lookups, selection and impression writes are recorded under.
+func (s *AdService) GetAdsForClient(ctx context.Context, clientID, dma string) (string, error) {
+	ctx, span := tracer.Start(ctx, "AdService.GetAdsForClient",
+		trace.WithAttributes(attribute.String("client_id", clientID), attribute.String("dma", dma)))
+	defer span.End()
+
 	now := time.Now()
 	// 1. Get Active Campaigns for DMA
-	campaigns, err := s.store.GetActiveCampaigns(dma, now)
+	campaigns, err := s.store.GetActiveCampaigns(ctx, dma, now)
 	if err != nil {
+		s.countResponse("error")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "get active campaigns")
 		return "", err
 	}
 
 	// 2. Rate Limiting Check
 	// "Each unique client must be served no more than 5 minutes (300 seconds) of total ad duration within the current hour."
-	oneHourAgo := now.Add(-1 * time.Hour)
-	currentDuration, err := s.store.GetClientImpressionsDuration(clientID, oneHourAgo)
+	// The cap and window are configurable; those are the defaults.
+	windowStart := now.Add(-s.limits.Window)
+	currentDuration, err := s.store.GetClientImpressionsDuration(ctx, clientID, windowStart)
 	if err != nil {
+		s.countResponse("error")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "get client impressions")
 		return "", err
 	}
 
-	remainingDuration := 300 - currentDuration
+	remainingDuration := s.limits.MaxSeconds - currentDuration
 	if remainingDuration <= 0 {
-		return s.GenerateVAST(nil), nil // Return empty VAST
+		s.countResponse("rate_limited")
+		span.SetAttributes(attribute.String("result", "rate_limited"))
+		logDecision(ctx, clientID, dma, "rate_limited", nil, currentDuration)
+		return s.GenerateVAST(nil, nil), nil // Return empty VAST
 	}
 
 	// 3. Select Ads
+	_, selectSpan := tracer.Start(ctx, "select ads", trace.WithAttributes(attribute.Int("campaigns", len(campaigns))))
 	var selectedAds []models.Ad
+	tenantIDs := make(map[string]string)
 	for _, c := range campaigns {
+		tenantIDs[c.ID] = c.TenantID
 		for _, ad := range c.Ads {
 			if ad.DurationSeconds <= remainingDuration {
 				selectedAds = append(selectedAds, ad)
 				remainingDuration -= ad.DurationSeconds
-
-				// Record Impression immediately (simplified logic, usually done on ping)
-				// Note: User requirements say "Ads served must belong...", implementation detail: we count them as served when we return them for simplicity here,
-				// or we should rely on client pings. For a backend logic test, pre-recording or separate endpoint is common.
-				// Given the "Rate limiting" constraint is strictly about "served", better to count it now.
-				s.store.RecordImpression(models.Impression{
-					ID:              uuid.New().String(),
-					ClientID:        clientID,
-					AdID:            ad.ID,
-					DurationSeconds: ad.DurationSeconds,
-					Timestamp:       time.Now(),
-				})
 			}
 			if remainingDuration <= 0 {
 				break
@@ -80,35 +238,89 @@ func (s *AdService) GetAdsForClient(clientID, dma string) (string, error) {
 			break
 		}
 	}
+	selectSpan.SetAttributes(attribute.Int("ads", len(selectedAds)))
+	selectSpan.End()
+
+	// 4. Record Impressions immediately (simplified logic, usually done on ping)
+	// Note: User requirements say "Ads served must belong...", implementation detail: we count them as served when we return them for simplicity here,
+	// or we should rely on client pings. For a backend logic test, pre-recording or separate endpoint is common.
+	// Given the "Rate limiting" constraint is strictly about "served", better to count it now.
+	impressionIDs := make([]string, len(selectedAds))
+	for i, ad := range selectedAds {
+		impressionIDs[i] = uuid.New().String()
+		err := s.store.RecordImpression(ctx, models.Impression{
+			ID:              impressionIDs[i],
+			ClientID:        clientID,
+			AdID:            ad.ID,
+			CampaignID:      ad.CampaignID,
+			CreativeID:      ad.CreativeID,
+			TenantID:        tenantIDs[ad.CampaignID],
+			DMA:             dma,
+			DurationSeconds: ad.DurationSeconds,
+			Timestamp:       time.Now(),
+		})
+		if err != nil {
+			span.RecordError(err)
+			slog.ErrorContext(ctx, "Failed to record impression", "ad_id", ad.ID, "client_id", clientID, "error", err)
+		}
+		if s.metrics != nil {
+			s.metrics.AdsServed.Inc(ad.CampaignID)
+		}
+	}
 
-	return s.GenerateVAST(selectedAds), nil
+	result := "filled"
+	if len(selectedAds) == 0 {
+		result = "empty"
+	}
+	s.countResponse(result)
+	span.SetAttributes(attribute.String("result", result))
+	logDecision(ctx, clientID, dma, result, selectedAds, s.limits.MaxSeconds-remainingDuration)
+	return s.GenerateVAST(selectedAds, impressionIDs), nil
 }
 
-func (s *AdService) GenerateVAST(ads []models.Ad) string {
+// GenerateVAST renders the ads. Each ad gets a complete tracking event
+// pointing at its impression, so delivery reports can show completion rates.
+func (s *AdService) GenerateVAST(ads []models.Ad, impressionIDs []string) string {
 	vast := models.VAST{
 		Version: "3.0",
 		Ad:      make([]models.VASTAd, len(ads)),
 	}
 
 	for i, ad := range ads {
+		// Creatives registered before upload inspection existed have no
+		// recorded format; assume the 720p MP4 we used to advertise for all
+		mimeType, width, height := ad.MimeType, ad.Width, ad.Height
+		if mimeType == "" {
+			mimeType = "video/mp4"
+		}
+		if width == 0 || height == 0 {
+			width, height = 1280, 720
+		}
+
 		vast.Ad[i] = models.VASTAd{
 			ID: ad.ID,
 			InLine: &models.InLine{
 				AdSystem: "Rockbot Ad Server",
 				AdTitle:  "Inline Video Ad",
-				Creatives: models.Creatives{
-					Creative: []models.Creative{
+				Creatives: models.VASTCreatives{
+					Creative: []models.VASTCreative{
 						{
 							ID: ad.CreativeID,
 							Linear: &models.Linear{
-								Duration: fmt.Sprintf("00:00:%02d", ad.DurationSeconds),
+								Duration: fmt.Sprintf("%02d:%02d:%02d", ad.DurationSeconds/3600, ad.DurationSeconds/60%60, ad.DurationSeconds%60),
+								TrackingEvents: &models.TrackingEvents{
+									Tracking: []models.Tracking{
+										{Event: "complete", URL: s.trackingURL + "/vast/events?event=complete&impression_id=" + url.QueryEscape(impressionIDs[i])},
+									},
+								},
 								MediaFiles: models.MediaFiles{
 									MediaFile: []models.MediaFile{
 										{
 											Delivery: "progressive",
-											Type:     "video/mp4",
-											Width:    1280,
-											Height:   720,
+											Type:     mimeType,
+											Width:    width,
+											Height:   height,
+											Bitrate:  ad.BitrateBps / 1000,
 											URL:      ad.MediaURL,
 										},
 									},
@@ -125,26 +337,63 @@ func (s *AdService) GenerateVAST(ads []models.Ad) string {
 	return xml.Header + string(output)
 }
 
-func (s *AdService) ListCampaigns() ([]models.Campaign, error) {
-	return s.store.GetAllCampaigns()
+// ListCampaigns lists the campaigns in scope, without archived ones unless
+// includeArchived is set
+func (s *AdService) ListCampaigns(scope models.TenantScope, includeArchived bool) ([]models.Campaign, error) {
+	return s.store.GetAllCampaigns(scope, includeArchived)
+}
+
+// CampaignDelivery returns delivery totals for the campaigns in scope, keyed by campaign ID
+func (s *AdService) CampaignDelivery(scope models.TenantScope) (map[string]models.CampaignDelivery, error) {
+	return s.store.GetCampaignDelivery(scope)
 }
 
-func (s *AdService) GetAvailableAds() ([]models.Ad, error) {
-	return s.store.GetAvailableAds()
+func (s *AdService) GetCampaign(id string, scope models.TenantScope) (*models.Campaign, error) {
+	return s.store.GetCampaignByID(id, scope)
 }
 
-func (s *AdService) GetAvailableAdByMediaURL(mediaURL string) (*models.Ad, error) {
-	return s.store.GetAvailableAdByMediaURL(mediaURL)
+// UpdateCampaign replaces a campaign's fields and ads. The owning tenant is
+// fixed at creation and any TenantID on c is ignored.
+func (s *AdService) UpdateCampaign(c models.Campaign, scope models.TenantScope, user *models.User) error {
+	return s.updateCampaign(c, scope, newRevision(models.RevisionUpdate, user))
 }
 
-func (s *AdService) GetCampaign(id string) (*models.Campaign, error) {
-	return s.store.GetCampaignByID(id)
+func (s *AdService) updateCampaign(c models.Campaign, scope models.TenantScope, rev models.CampaignRevision) error {
+	if _, err := s.prepareCampaignUpdate(&c, scope); err != nil {
+		return err
+	}
+	if err := s.store.UpdateCampaign(c, scope, rev); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
 }
 
-func (s *AdService) UpdateCampaign(c models.Campaign) error {
+// prepareCampaignUpdate validates changes to a stored campaign, keeps its
+// owner and fills in its ad details. It returns the campaign as stored.
+func (s *AdService) prepareCampaignUpdate(c *models.Campaign, scope models.TenantScope) (*models.Campaign, error) {
 	// Ensure campaign has an ID
 	if c.ID == "" {
-		return fmt.Errorf("campaign ID is required")
+		return nil, fmt.Errorf("campaign ID is required")
+	}
+	if err := ValidateCampaign(*c); err != nil {
+		return nil, err
+	}
+	existing, err := s.store.GetCampaignByID(c.ID, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	c.TenantID = existing.TenantID
+	if err := s.checkAdIDs(c.ID, c.Ads); err != nil {
+		return nil, err
+	}
+	if err := s.resolveAds(c.Ads, c.TenantID); err != nil {
+		return nil, err
 	}
 	// Assign IDs to ads if missing
 	for i := range c.Ads {
@@ -152,5 +401,308 @@ func (s *AdService) UpdateCampaign(c models.Campaign) error {
 			c.Ads[i].ID = uuid.New().String()
 		}
 	}
-	return s.store.UpdateCampaign(c)
+	return existing, nil
+}
+
+// PatchCampaign applies a JSON Merge Patch (RFC 7396) to the stored campaign.
+// Fields absent from the patch are left alone; an "ads" member replaces the
+// ad list, but ads that keep their IDs keep their rows.
+func (s *AdService) PatchCampaign(id string, patch []byte, scope models.TenantScope, user *models.User) (*models.Campaign, error) {
+	existing, err := s.store.GetCampaignByID(id, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := applyMergePatch(doc, patch)
+	if err != nil {
+		return nil, &ValidationError{Msg: "Invalid merge patch: " + err.Error()}
+	}
+
+	var campaign models.Campaign
+	if err := json.Unmarshal(merged, &campaign); err != nil {
+		return nil, &ValidationError{Msg: "Invalid campaign: " + err.Error()}
+	}
+	campaign.ID = id
+	if campaign.Status != existing.Status {
+		return nil, &ValidationError{Msg: "Change the status with the schedule, pause, resume, archive and unarchive actions"}
+	}
+
+	if err := ValidateCampaign(campaign); err != nil {
+		return nil, err
+	}
+	if err := s.UpdateCampaign(campaign, scope, user); err != nil {
+		return nil, err
+	}
+	return s.store.GetCampaignByID(id, scope)
+}
+
+// AddAd attaches one ad to a campaign without touching its other ads. The ad
+// names a library creative by creative_id (or, for older clients, media_url).
+func (s *AdService) AddAd(campaignID string, ad models.Ad, scope models.TenantScope, user *models.User) (*models.Ad, error) {
+	campaign, err := s.store.GetCampaignByID(campaignID, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	ad.ID = ""
+	ads := []models.Ad{ad}
+	if err := s.resolveAds(ads, campaign.TenantID); err != nil {
+		return nil, err
+	}
+	ad = ads[0]
+
+	ad.ID = uuid.New().String()
+	ad.CampaignID = campaignID
+	if err := s.store.AddAd(campaignID, ad, scope, newRevision(models.RevisionUpdate, user)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// RemoveAd detaches one ad from a campaign. The ad is soft-deleted, so its
+// impressions stay reportable.
+func (s *AdService) RemoveAd(campaignID, adID string, scope models.TenantScope, user *models.User) error {
+	if err := s.store.DeleteAd(campaignID, adID, scope, newRevision(models.RevisionUpdate, user)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteCampaign soft-deletes a campaign with its ads. It stops serving and
+// disappears from lists, but its impressions stay in the reports. Live
+// campaigns must be paused first, as with archiving.
+func (s *AdService) DeleteCampaign(id string, scope models.TenantScope, user *models.User) error {
+	campaign, err := s.store.GetCampaignByID(id, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if campaign.Status == models.CampaignStatusLive {
+		return &ValidationError{Msg: "Pause the campaign before deleting it"}
+	}
+	if err := s.store.DeleteCampaign(id, scope, newRevision(models.RevisionDelete, user)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// CampaignNames maps campaign IDs in scope to names for labelling reports,
+// including archived and deleted campaigns
+func (s *AdService) CampaignNames(scope models.TenantScope) (map[string]string, error) {
+	return s.store.GetCampaignNames(scope)
+}
+
+// dmaPattern matches a DMA code: up to three digits, as players send it
+// ("501", but also short codes like "10" that campaigns already target).
+// "*" targets every DMA.
+var dmaPattern = regexp.MustCompile(`^[0-9]{1,3}$`)
+
+// ValidateCampaign checks the fields every stored campaign must have
+func ValidateCampaign(c models.Campaign) error {
+	if c.Name == "" {
+		return &ValidationError{Msg: "Campaign name is required"}
+	}
+	if c.StartTime.IsZero() || c.EndTime.IsZero() {
+		return &ValidationError{Msg: "Start time and end time are required"}
+	}
+	if !c.EndTime.After(c.StartTime) {
+		return &ValidationError{Msg: "End time must be after start time"}
+	}
+	if c.TargetDMA == "" {
+		return &ValidationError{Msg: "Target DMA is required"}
+	}
+	if c.TargetDMA != "*" && !dmaPattern.MatchString(c.TargetDMA) {
+		return &ValidationError{Msg: "Target DMA must be * or a numeric DMA code of up to three digits"}
+	}
+	if c.ImpressionGoal < 0 {
+		return &ValidationError{Msg: "Impression goal can't be negative"}
+	}
+	return nil
+}
+
+// checkAdIDs makes sure every ad given with an ID is listed once and is
+// either new or already one of campaignID's ads, deleted ones included
+func (s *AdService) checkAdIDs(campaignID string, ads []models.Ad) error {
+	seen := make(map[string]bool, len(ads))
+	for _, ad := range ads {
+		if ad.ID == "" {
+			continue
+		}
+		if seen[ad.ID] {
+			return &ValidationError{Msg: fmt.Sprintf("Ad %s is listed more than once", ad.ID)}
+		}
+		seen[ad.ID] = true
+
+		owner, err := s.store.GetAdCampaignID(ad.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if owner != campaignID {
+			return &ValidationError{Msg: fmt.Sprintf("Ad %s is not part of this campaign", ad.ID)}
+		}
+	}
+	return nil
+}
+
+// resolveAds fills each ad's media URL and duration from the creative library.
+// New ads (no ID yet) must reference an active creative; ads already on a
+// campaign may keep a creative that has since been retired. Creatives must
+// belong to the campaign's advertiser; house campaigns may use any creative.
+func (s *AdService) resolveAds(ads []models.Ad, tenantID string) error {
+	scope := models.AllTenants
+	if tenantID != "" {
+		scope = models.TenantScope{TenantIDs: []string{tenantID}}
+	}
+	for i := range ads {
+		ad := &ads[i]
+
+		var creative *models.CreativeAsset
+		var err error
+		switch {
+		case ad.CreativeID != "":
+			creative, err = s.store.GetCreativeByID(ad.CreativeID, scope)
+		case ad.MediaURL != "":
+			creative, err = s.store.GetCreativeByMediaURL(ad.MediaURL, scope)
+		default:
+			return &ValidationError{Msg: "Ad creative_id is required"}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return &ValidationError{Msg: "Unknown creative for ad"}
+		}
+		if err != nil {
+			return err
+		}
+		if ad.ID == "" && creative.Status != models.CreativeStatusActive {
+			return &ValidationError{Msg: fmt.Sprintf("Creative %s is retired", creative.ID)}
+		}
+
+		ad.CreativeID = creative.ID
+		ad.MediaURL = creative.MediaURL
+		ad.DurationSeconds = creative.DurationSeconds
+		ad.MimeType = creative.MimeType
+		ad.Width = creative.Width
+		ad.Height = creative.Height
+		ad.BitrateBps = creative.BitrateBps
+	}
+	return nil
+}
+
+// TrackEvent records a player event for an impression served by
+// GetAdsForClient. Only "complete" is tracked.
+func (s *AdService) TrackEvent(impressionID, event string) error {
+	if event != "complete" {
+		return &ValidationError{Msg: "Unsupported event " + event}
+	}
+	found, err := s.store.CompleteImpression(impressionID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// maxResetReasonLength caps the reason stored with a budget reset
+const maxResetReasonLength = 500
+
+// ClientBudget shows how much of the client's rate limit is used as of now,
+// which ads used it and when it frees up
+func (s *AdService) ClientBudget(clientID string, now time.Time) (*models.ClientBudget, error) {
+	if clientID == "" {
+		return nil, &ValidationError{Msg: "Client ID is required"}
+	}
+	budget := &models.ClientBudget{
+		ClientID:      clientID,
+		MaxSeconds:    s.limits.MaxSeconds,
+		WindowSeconds: int(s.limits.Window / time.Second),
+		CountingSince: now.Add(-s.limits.Window),
+		Ads:           []models.BudgetAd{},
+	}
+	var err error
+	if budget.LastReset, err = s.store.LatestBudgetReset(clientID); err != nil {
+		return nil, err
+	}
+	if budget.LastReset != nil && budget.LastReset.CreatedAt.After(budget.CountingSince) {
+		budget.CountingSince = budget.LastReset.CreatedAt
+	}
+
+	impressions, err := s.store.GetClientImpressions(clientID, budget.CountingSince)
+	if err != nil {
+		return nil, err
+	}
+	byAd := make(map[string]int)
+	for _, imp := range impressions {
+		budget.UsedSeconds += imp.DurationSeconds
+		i, ok := byAd[imp.AdID]
+		if !ok {
+			i = len(budget.Ads)
+			byAd[imp.AdID] = i
+			budget.Ads = append(budget.Ads, models.BudgetAd{AdID: imp.AdID, CampaignID: imp.CampaignID, CreativeID: imp.CreativeID})
+		}
+		budget.Ads[i].Impressions++
+		budget.Ads[i].Seconds += imp.DurationSeconds
+		budget.Ads[i].LastServedAt = imp.Timestamp
+	}
+	budget.RemainingSeconds = max(0, s.limits.MaxSeconds-budget.UsedSeconds)
+	if len(impressions) > 0 {
+		next := impressions[0].Timestamp.Add(s.limits.Window)
+		full := impressions[len(impressions)-1].Timestamp.Add(s.limits.Window)
+		budget.NextReleaseAt, budget.FullyReleasedAt = &next, &full
+	}
+	return budget, nil
+}
+
+// ResetClientBudget clears the client's used budget so it can be served
+// again straight away. The reset is recorded with the user and reason;
+// impressions are kept for reporting.
+func (s *AdService) ResetClientBudget(clientID, reason string, user *models.User, now time.Time) (*models.BudgetReset, error) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxResetReasonLength {
+		return nil, &ValidationError{Msg: fmt.Sprintf("Reason must be at most %d characters", maxResetReasonLength)}
+	}
+	budget, err := s.ClientBudget(clientID, now)
+	if err != nil {
+		return nil, err
+	}
+	reset := models.BudgetReset{
+		ID:             uuid.New().String(),
+		ClientID:       clientID,
+		ClearedSeconds: budget.UsedSeconds,
+		Reason:         reason,
+		CreatedAt:      now,
+	}
+	if user != nil {
+		reset.UserID, reset.Username = user.ID, user.Username
+	}
+	if err := s.store.CreateBudgetReset(reset); err != nil {
+		return nil, err
+	}
+	slog.Info("Client budget reset", "client_id", clientID, "cleared_seconds", reset.ClearedSeconds,
+		"user", reset.Username, "reason", reason)
+	return &reset, nil
 }
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCampaignDMA(t *testing.T) {
+	tests := []struct {
+		dma   string
+		valid bool
+	}{
+		{"*", true},
+		{"501", true},
+		{"10", true},
+		{"7", true},
+		{"", false},
+		{"5011", false},
+		{"50a", false},
+		{" 501", false},
+		{"**", false},
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		c := models.Campaign{Name: "c", StartTime: start, EndTime: start.Add(time.Hour), TargetDMA: tt.dma}
+		err := ValidateCampaign(c)
+		if tt.valid && err != nil {
+			t.Errorf("DMA %q rejected: %v", tt.dma, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("DMA %q accepted", tt.dma)
+		}
+	}
+}
+
+// adFixture is an AdService over a throwaway database with three house
+// creatives, spot-a to spot-c, and an advertiser with a creative of its own
+type adFixture struct {
+	s      *AdService
+	db     *store.Store
+	tenant string
+}
+
+func newAdFixture(t *testing.T) *adFixture {
+	t.Helper()
+	db := newTestStore(t)
+	tenant, err := NewTenantService(db).Create("Acme", models.TenantTypeAdvertiser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &adFixture{s: NewAdService(db, RateLimit{MaxSeconds: 300, Window: time.Hour}, "http://ads.example.com"), db: db, tenant: tenant.ID}
+	for _, c := range []struct{ id, tenant string }{{"spot-a", ""}, {"spot-b", ""}, {"spot-c", ""}, {"acme-spot", tenant.ID}} {
+		creative := models.CreativeAsset{ID: c.id, Name: c.id, MediaURL: "http://example.com/" + c.id + ".mp4", MimeType: "video/mp4",
+			DurationSeconds: 15, TenantID: c.tenant, Status: models.CreativeStatusActive, CreatedAt: time.Now()}
+		if err := db.CreateCreative(creative); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// campaign creates a national house campaign over the next week with an ad
+// for each creative
+func (f *adFixture) campaign(t *testing.T, creatives ...string) *models.Campaign {
+	t.Helper()
+	c := models.Campaign{
+		Name:           "Spring",
+		StartTime:      time.Now().Add(24 * time.Hour).Truncate(time.Second),
+		EndTime:        time.Now().Add(8 * 24 * time.Hour).Truncate(time.Second),
+		TargetDMA:      "501",
+		ImpressionGoal: 1000,
+	}
+	for _, id := range creatives {
+		c.Ads = append(c.Ads, models.Ad{CreativeID: id})
+	}
+	if err := f.s.CreateCampaign(c, models.AllTenants, nil); err != nil {
+		t.Fatal(err)
+	}
+	campaigns, err := f.db.GetAllCampaigns(models.AllTenants, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range campaigns {
+		if stored.Name == c.Name {
+			got, err := f.db.GetCampaignByID(stored.ID, models.AllTenants)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return got
+		}
+	}
+	t.Fatal("campaign was not stored")
+	return nil
+}
+
+// adsByCreative maps each of the campaign's ads' creative to the ad ID
+func adsByCreative(c *models.Campaign) map[string]string {
+	ads := make(map[string]string)
+	for _, ad := range c.Ads {
+		ads[ad.CreativeID] = ad.ID
+	}
+	return ads
+}
+
+func TestPatchCampaign(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a", "spot-b")
+	before := adsByCreative(c)
+
+	// Members left out of the patch keep their values, ads included
+	patched, err := f.s.PatchCampaign(c.ID, []byte(`{"name": "Summer"}`), models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Name != "Summer" || patched.TargetDMA != "501" || patched.ImpressionGoal != 1000 ||
+		!patched.StartTime.Equal(c.StartTime) || !patched.EndTime.Equal(c.EndTime) {
+		t.Errorf("patching the name changed other fields: %+v", patched)
+	}
+	if got := adsByCreative(patched); len(got) != 2 || got["spot-a"] != before["spot-a"] || got["spot-b"] != before["spot-b"] {
+		t.Errorf("patching the name changed the ads: %v, was %v", got, before)
+	}
+
+	// null removes a member, which for the goal means no goal
+	patched, err = f.s.PatchCampaign(c.ID, []byte(`{"impression_goal": null}`), models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.ImpressionGoal != 0 {
+		t.Errorf("impression_goal null left the goal at %d", patched.ImpressionGoal)
+	}
+
+	// An ads member replaces the whole list: listed IDs keep their rows, ads
+	// without an ID are added and the rest are removed
+	patch := `{"ads": [{"id": "` + before["spot-b"] + `", "creative_id": "spot-b"}, {"creative_id": "spot-c"}]}`
+	patched, err = f.s.PatchCampaign(c.ID, []byte(patch), models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := adsByCreative(patched)
+	if len(after) != 2 || after["spot-b"] != before["spot-b"] || after["spot-c"] == "" || after["spot-a"] != "" {
+		t.Errorf("ads after replacing the list: %v", after)
+	}
+	// The removed ad is soft-deleted, and naming its ID again restores it
+	owner, err := f.db.GetAdCampaignID(before["spot-a"])
+	if err != nil || owner != c.ID {
+		t.Errorf("removed ad row: owner %q, %v", owner, err)
+	}
+	patch = `{"ads": [{"id": "` + before["spot-a"] + `", "creative_id": "spot-a"}, {"id": "` + before["spot-b"] + `", "creative_id": "spot-b"}]}`
+	patched, err = f.s.PatchCampaign(c.ID, []byte(patch), models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := adsByCreative(patched); got["spot-a"] != before["spot-a"] || got["spot-b"] != before["spot-b"] || len(got) != 2 {
+		t.Errorf("ads after restoring: %v", got)
+	}
+}
+
+func TestPatchCampaignRejects(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a")
+	other := models.Campaign{Name: "Other", StartTime: c.StartTime, EndTime: c.EndTime, TargetDMA: "*", Ads: []models.Ad{{CreativeID: "spot-b"}}}
+	if err := f.s.CreateCampaign(other, models.AllTenants, nil); err != nil {
+		t.Fatal(err)
+	}
+	campaigns, err := f.db.GetAllCampaigns(models.AllTenants, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var foreignAd string
+	for _, stored := range campaigns {
+		if stored.Name == "Other" {
+			full, err := f.db.GetCampaignByID(stored.ID, models.AllTenants)
+			if err != nil {
+				t.Fatal(err)
+			}
+			foreignAd = full.Ads[0].ID
+		}
+	}
+	ownAd := c.Ads[0].ID
+
+	tests := []struct{ name, patch string }{
+		{"invalid JSON", `{"name": `},
+		{"missing name", `{"name": null}`},
+		{"end before start", `{"end_time": "2000-01-01T00:00:00Z"}`},
+		{"bad DMA", `{"target_dma": "new york"}`},
+		{"status change", `{"status": "paused"}`},
+		{"another campaign's ad", `{"ads": [{"id": "` + foreignAd + `", "creative_id": "spot-b"}]}`},
+		{"ad listed twice", `{"ads": [{"id": "` + ownAd + `", "creative_id": "spot-a"}, {"id": "` + ownAd + `", "creative_id": "spot-a"}]}`},
+		{"unknown creative", `{"ads": [{"creative_id": "missing"}]}`},
+		{"ad without a creative", `{"ads": [{}]}`},
+	}
+	for _, tt := range tests {
+		_, err := f.s.PatchCampaign(c.ID, []byte(tt.patch), models.AllTenants, nil)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want a ValidationError", tt.name, err)
+		}
+	}
+
+	stored, err := f.db.GetCampaignByID(c.ID, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != c.Name || len(stored.Ads) != 1 || stored.Ads[0].ID != ownAd {
+		t.Errorf("rejected patches changed the campaign: %+v", stored)
+	}
+	otherStored, err := f.db.GetAdCampaignID(foreignAd)
+	if err != nil || otherStored == c.ID {
+		t.Errorf("the other campaign's ad moved: %q, %v", otherStored, err)
+	}
+
+	acme := models.TenantScope{TenantIDs: []string{f.tenant}}
+	if _, err := f.s.PatchCampaign(c.ID, []byte(`{"name": "x"}`), acme, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("patching a campaign out of scope: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestAddAndRemoveAd(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a")
+	kept := c.Ads[0].ID
+
+	added, err := f.s.AddAd(c.ID, models.Ad{CreativeID: "spot-b"}, models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.db.RecordImpression(context.Background(), models.Impression{ID: "imp", ClientID: "lobby", AdID: added.ID,
+		CampaignID: c.ID, CreativeID: "spot-b", DMA: "501", DurationSeconds: 15, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.s.RemoveAd(c.ID, added.ID, models.AllTenants, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.s.RemoveAd(c.ID, added.ID, models.AllTenants, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing the ad twice: got %v, want ErrNotFound", err)
+	}
+
+	stored, err := f.db.GetCampaignByID(c.ID, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, ad := range stored.Ads {
+		ids = append(ids, ad.ID)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != kept {
+		t.Errorf("ads after adding and removing one: %v, want only %s", ids, kept)
+	}
+	// The removed ad's impression still reports under its ID
+	rows, err := f.db.GetDeliveryReport(models.DeliveryQuery{GroupBy: []string{models.DimensionAd}}, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Dimensions[models.DimensionAd] != added.ID || rows[0].Impressions != 1 {
+		t.Errorf("report after removing the ad: %+v", rows)
+	}
+
+	if _, err := f.s.AddAd(c.ID, models.Ad{CreativeID: "acme-spot"}, models.AllTenants, nil); err != nil {
+		t.Errorf("house campaigns may use any creative: %v", err)
+	}
+	globex, err := NewTenantService(f.db).Create("Globex", models.TenantTypeAdvertiser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirs := models.Campaign{ID: "globex-campaign", Name: "Globex", StartTime: c.StartTime, EndTime: c.EndTime, TargetDMA: "*", TenantID: globex.ID}
+	if err := f.s.CreateCampaign(theirs, models.AllTenants, nil); err != nil {
+		t.Fatal(err)
+	}
+	var verr *ValidationError
+	if _, err := f.s.AddAd(theirs.ID, models.Ad{CreativeID: "acme-spot"}, models.AllTenants, nil); !errors.As(err, &verr) {
+		t.Errorf("adding another advertiser's creative: got %v, want a ValidationError", err)
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned for a wrong username or password
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrInvalidSession is returned for unknown or expired session tokens
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// minPasswordLength is the shortest password CreateUser accepts
+const minPasswordLength = 8
+
+// dummyPasswordHash is compared against when a username doesn't exist, so
+// login takes the same time whether or not the account is real
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+type AuthService struct {
+	store      *store.Store
+	sessionTTL time.Duration
+}
+
+func NewAuthService(store *store.Store, sessionTTL time.Duration) *AuthService {
+	return &AuthService{store: store, sessionTTL: sessionTTL}
+}
+
+// SessionTTL is how long a new session stays valid
+func (s *AuthService) SessionTTL() time.Duration {
+	return s.sessionTTL
+}
+
+// CreateUser adds an account with a bcrypt-hashed password. An empty
+// tenantID creates a platform user who can see every tenant.
+func (s *AuthService) CreateUser(username, password, role, tenantID string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, &ValidationError{Msg: "Username is required"}
+	}
+	if !validRole(role) {
+		return nil, &ValidationError{Msg: "Role must be one of: " + strings.Join(models.Roles, ", ")}
+	}
+	if len(password) < minPasswordLength {
+		return nil, &ValidationError{Msg: "Password must be at least 8 characters"}
+	}
+	if err := s.checkTenant(tenantID); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.store.GetUserByUsername(username); err == nil {
+		return nil, &ValidationError{Msg: "Username already exists"}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		TenantID:     tenantID,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.store.CreateUser(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers returns all accounts
+func (s *AuthService) ListUsers() ([]models.User, error) {
+	return s.store.GetUsers()
+}
+
+// SetRole changes what a user is allowed to do. It takes effect on the
+// user's next request since roles are read with the session.
+func (s *AuthService) SetRole(userID, role string) error {
+	if !validRole(role) {
+		return &ValidationError{Msg: "Role must be one of: " + strings.Join(models.Roles, ", ")}
+	}
+	if err := s.store.SetUserRole(userID, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SetTenant moves a user to another tenant, or to the platform when tenantID is empty
+func (s *AuthService) SetTenant(userID, tenantID string) error {
+	if err := s.checkTenant(tenantID); err != nil {
+		return err
+	}
+	if err := s.store.SetUserTenant(userID, tenantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *AuthService) checkTenant(tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	if _, err := s.store.GetTenantByID(tenantID); errors.Is(err, sql.ErrNoRows) {
+		return &ValidationError{Msg: "Unknown tenant"}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func validRole(role string) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPassword changes a user's password and logs them out everywhere
+func (s *AuthService) SetPassword(username, password string) error {
+	if len(password) < minPasswordLength {
+		return &ValidationError{Msg: "Password must be at least 8 characters"}
+	}
+	user, err := s.store.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.store.SetUserPassword(user.ID, string(hash))
+}
+
+// Login checks the credentials and starts a session. The returned token is
+// what goes in the cookie; only its hash is stored.
+func (s *AuthService) Login(username, password string) (string, *models.Session, error) {
+	user, err := s.store.GetUserByUsername(strings.TrimSpace(username))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", nil, err
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	sess := models.Session{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	if err := s.store.CreateSession(sess); err != nil {
+		return "", nil, err
+	}
+
+	// Opportunistic cleanup; a failure here shouldn't block the login
+	s.store.DeleteExpiredSessions(now)
+
+	return token, &sess, nil
+}
+
+// Authenticate returns the user a session token belongs to
+func (s *AuthService) Authenticate(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidSession
+	}
+	user, err := s.store.GetSessionUser(hashToken(token), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidSession
+	}
+	return user, err
+}
+
+// Logout ends the session for token
+func (s *AuthService) Logout(token string) error {
+	if token == "" {
+		return nil
+	}
+	return s.store.DeleteSession(hashToken(token))
+}
+
+// CSRFToken returns the anti-forgery token for a session. It is derived from
+// the session token, which other sites can't read, so it needs no storage and
+// changes with every login.
+func (s *AuthService) CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte("csrf"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckCSRF reports whether csrfToken was issued for the session
+func (s *AuthService) CheckCSRF(sessionToken, csrfToken string) bool {
+	if sessionToken == "" || csrfToken == "" {
+		return false
+	}
+	return hmac.Equal([]byte(s.CSRFToken(sessionToken)), []byte(csrfToken))
+}
+
+// newSessionToken returns 256 bits of randomness, URL-safe encoded
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"rockbot-adserver/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CampaignHistory lists a campaign's revisions, newest first, each with the
+// fields it changed
+func (s *AdService) CampaignHistory(campaignID string, scope models.TenantScope) ([]models.CampaignRevision, error) {
+	if _, err := s.store.GetCampaignByID(campaignID, scope); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	revisions, err := s.store.GetCampaignRevisions(campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if revisions == nil {
+		revisions = []models.CampaignRevision{}
+	}
+	for i := range revisions {
+		revisions[i].Changes = DiffCampaigns(revisions[i].Before, revisions[i].After)
+	}
+	return revisions, nil
+}
+
+// RollbackCampaign restores the campaign as it was right after revisionID.
+// Ads that were removed since come back with their old IDs, so impressions
+// stay linked. The rollback is itself a new revision.
+func (s *AdService) RollbackCampaign(campaignID string, revisionID int64, scope models.TenantScope, user *models.User) (*models.Campaign, error) {
+	if _, err := s.store.GetCampaignByID(campaignID, scope); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	target, err := s.store.GetCampaignRevision(campaignID, revisionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.After == nil {
+		return nil, &ValidationError{Msg: "Can't roll back to a deletion"}
+	}
+
+	rev := newRevision(models.RevisionRollback, user)
+	rev.RolledBackTo = target.ID
+	if err := s.updateCampaign(*target.After, scope, rev); err != nil {
+		return nil, err
+	}
+	return s.store.GetCampaignByID(campaignID, scope)
+}
+
+// DiffCampaigns lists the fields that differ between two snapshots. Either
+// may be nil, for creates and deletes.
+func DiffCampaigns(before, after *models.Campaign) []models.FieldChange {
+	fields := func(c *models.Campaign) []string {
+		if c == nil {
+			return make([]string, 7)
+		}
+		goal := ""
+		if c.ImpressionGoal > 0 {
+			goal = strconv.Itoa(c.ImpressionGoal)
+		}
+		ads := make([]string, len(c.Ads))
+		for i, ad := range c.Ads {
+			ads[i] = fmt.Sprintf("%s (%ds)", ad.CreativeID, ad.DurationSeconds)
+		}
+		return []string{
+			c.Name,
+			c.StartTime.UTC().Format(time.RFC3339),
+			c.EndTime.UTC().Format(time.RFC3339),
+			c.TargetDMA,
+			goal,
+			c.Status,
+			strings.Join(ads, ", "),
+		}
+	}
+	names := []string{"name", "start_time", "end_time", "target_dma", "impression_goal", "status", "ads"}
+	b, a := fields(before), fields(after)
+
+	changes := []models.FieldChange{}
+	for i, name := range names {
+		if b[i] != a[i] {
+			changes = append(changes, models.FieldChange{Field: name, Before: b[i], After: a[i]})
+		}
+	}
+	return changes
+}
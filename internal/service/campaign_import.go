@@ -0,0 +1,371 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"rockbot-adserver/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bulk import and export formats
+const (
+	CampaignFormatCSV  = "csv"
+	CampaignFormatJSON = "json"
+)
+
+// maxImportRows caps the campaigns one import may hold
+const maxImportRows = 5000
+
+// campaignCSVColumns are the CSV columns in export order. Imports may order
+// them freely and leave out the optional ones.
+var campaignCSVColumns = []string{"id", "name", "tenant_id", "start_time", "end_time", "target_dma", "impression_goal", "status", "creative_ids"}
+
+var requiredCSVColumns = []string{"name", "start_time", "end_time", "target_dma", "creative_ids"}
+
+// importRow is one parsed row, or the reason it couldn't be parsed
+type importRow struct {
+	row    int
+	record models.CampaignRecord
+	err    string
+}
+
+// ExportCampaigns lists every campaign in scope, archived ones included, in
+// the format ImportCampaigns reads
+func (s *AdService) ExportCampaigns(scope models.TenantScope) ([]models.CampaignRecord, error) {
+	campaigns, err := s.store.GetAllCampaigns(scope, true)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]models.CampaignRecord, 0, len(campaigns))
+	for _, listed := range campaigns {
+		c, err := s.store.GetCampaignByID(listed.ID, scope)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // deleted since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		creatives := make([]string, len(c.Ads))
+		for i, ad := range c.Ads {
+			creatives[i] = ad.CreativeID
+		}
+		records = append(records, models.CampaignRecord{
+			ID:             c.ID,
+			Name:           c.Name,
+			TenantID:       c.TenantID,
+			StartTime:      c.StartTime.UTC(),
+			EndTime:        c.EndTime.UTC(),
+			TargetDMA:      c.TargetDMA,
+			ImpressionGoal: c.ImpressionGoal,
+			Status:         c.Status,
+			CreativeIDs:    creatives,
+		})
+	}
+	return records, nil
+}
+
+// WriteCampaignRecords writes records as CSV or JSON
+func WriteCampaignRecords(w io.Writer, format string, records []models.CampaignRecord) error {
+	switch format {
+	case CampaignFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case CampaignFormatCSV:
+		out := csv.NewWriter(w)
+		out.Write(campaignCSVColumns)
+		for _, r := range records {
+			goal := ""
+			if r.ImpressionGoal > 0 {
+				goal = strconv.Itoa(r.ImpressionGoal)
+			}
+			out.Write([]string{
+				r.ID,
+				r.Name,
+				r.TenantID,
+				r.StartTime.Format(time.RFC3339),
+				r.EndTime.Format(time.RFC3339),
+				r.TargetDMA,
+				goal,
+				r.Status,
+				strings.Join(r.CreativeIDs, ";"),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	default:
+		return &ValidationError{Msg: "Format must be csv or json"}
+	}
+}
+
+// ImportCampaigns creates the rows without an ID and updates the campaigns
+// named by the others. Every row is checked first; only when all are valid,
+// and this is not a dry run, are they written, in one transaction. Updates
+// keep the ad rows of creatives that stay, and an empty creative list keeps
+// the ads as they are. Status can only be set on new campaigns.
+func (s *AdService) ImportCampaigns(r io.Reader, format string, dryRun bool, scope models.TenantScope, user *models.User) (*models.CampaignImport, error) {
+	rows, err := readCampaignRecords(r, format)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.CampaignImport{DryRun: dryRun, Rows: len(rows), Errors: []models.ImportRowError{}}
+
+	var campaigns []models.Campaign
+	var revs []models.CampaignRevision
+	seen := make(map[string]int)
+	for _, row := range rows {
+		if row.err == "" && row.record.ID != "" {
+			if first, ok := seen[row.record.ID]; ok {
+				row.err = fmt.Sprintf("Campaign %s is already in row %d", row.record.ID, first)
+			}
+			seen[row.record.ID] = row.row
+		}
+		if row.err != "" {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: row.row, Message: row.err})
+			continue
+		}
+
+		c, action, err := s.prepareImportedCampaign(row.record, scope)
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: row.row, Message: verr.Msg})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch action {
+		case models.RevisionCreate:
+			result.Created++
+		case models.RevisionUpdate:
+			result.Updated++
+		default:
+			result.Unchanged++
+			continue
+		}
+		campaigns = append(campaigns, c)
+		revs = append(revs, newRevision(action, user))
+	}
+
+	if dryRun || len(result.Errors) > 0 || len(campaigns) == 0 {
+		return result, nil
+	}
+	if err := s.store.ImportCampaigns(campaigns, revs, scope); err != nil {
+		return nil, err
+	}
+	username := ""
+	if user != nil {
+		username = user.Username
+	}
+	slog.Info("Campaigns imported", "created", result.Created, "updated", result.Updated, "user", username)
+	return result, nil
+}
+
+// prepareImportedCampaign turns a record into the campaign to store and says
+// whether it is a create or an update, or "" when nothing would change
+func (s *AdService) prepareImportedCampaign(r models.CampaignRecord, scope models.TenantScope) (models.Campaign, string, error) {
+	c := models.Campaign{
+		ID:             r.ID,
+		Name:           r.Name,
+		TenantID:       r.TenantID,
+		StartTime:      r.StartTime,
+		EndTime:        r.EndTime,
+		TargetDMA:      r.TargetDMA,
+		ImpressionGoal: r.ImpressionGoal,
+		Status:         r.Status,
+	}
+
+	if r.ID == "" {
+		for _, creative := range r.CreativeIDs {
+			c.Ads = append(c.Ads, models.Ad{CreativeID: creative})
+		}
+		if err := s.prepareNewCampaign(&c, scope); err != nil {
+			return c, "", err
+		}
+		return c, models.RevisionCreate, nil
+	}
+
+	existing, err := s.store.GetCampaignByID(r.ID, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, "", &ValidationError{Msg: fmt.Sprintf("Unknown campaign %s; leave id empty to create a new one", r.ID)}
+	}
+	if err != nil {
+		return c, "", err
+	}
+	if r.TenantID != "" && r.TenantID != existing.TenantID {
+		return c, "", &ValidationError{Msg: "Campaigns can't move to another advertiser"}
+	}
+	if r.Status != "" && r.Status != existing.Status {
+		return c, "", &ValidationError{Msg: "Change the status with the schedule, pause, resume, archive and unarchive actions"}
+	}
+
+	// Creatives that stay keep their ad rows, so impressions stay linked
+	c.Ads = existing.Ads
+	if len(r.CreativeIDs) > 0 {
+		unused := existing.Ads
+		c.Ads = nil
+		for _, creative := range r.CreativeIDs {
+			ad := models.Ad{CreativeID: creative}
+			for i, old := range unused {
+				if old.CreativeID == creative {
+					ad = old
+					unused = append(unused[:i:i], unused[i+1:]...)
+					break
+				}
+			}
+			c.Ads = append(c.Ads, ad)
+		}
+	}
+	if _, err := s.prepareCampaignUpdate(&c, scope); err != nil {
+		return c, "", err
+	}
+	c.Status = existing.Status
+	if len(DiffCampaigns(existing, &c)) == 0 {
+		return c, "", nil
+	}
+	return c, models.RevisionUpdate, nil
+}
+
+// readCampaignRecords parses an import. Problems with single rows are
+// reported on the row; an unreadable file is an error.
+func readCampaignRecords(r io.Reader, format string) ([]importRow, error) {
+	var rows []importRow
+	var err error
+	switch format {
+	case CampaignFormatJSON:
+		rows, err = readCampaignJSON(r)
+	case CampaignFormatCSV:
+		rows, err = readCampaignCSV(r)
+	default:
+		return nil, &ValidationError{Msg: "Format must be csv or json"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, &ValidationError{Msg: "The import has no campaigns"}
+	}
+	if len(rows) > maxImportRows {
+		return nil, &ValidationError{Msg: fmt.Sprintf("An import may hold at most %d campaigns", maxImportRows)}
+	}
+	return rows, nil
+}
+
+func readCampaignJSON(r io.Reader) ([]importRow, error) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, &ValidationError{Msg: "Invalid JSON: expected an array of campaigns: " + err.Error()}
+	}
+	rows := make([]importRow, len(items))
+	for i, item := range items {
+		rows[i].row = i + 1
+		dec := json.NewDecoder(strings.NewReader(string(item)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rows[i].record); err != nil {
+			rows[i].err = "Invalid campaign: " + err.Error()
+		}
+	}
+	return rows, nil
+}
+
+func readCampaignCSV(r io.Reader) ([]importRow, error) {
+	in := csv.NewReader(r)
+	in.TrimLeadingSpace = true
+	header, err := in.Read()
+	if err == io.EOF {
+		return nil, &ValidationError{Msg: "The import has no campaigns"}
+	}
+	if err != nil {
+		return nil, &ValidationError{Msg: "Invalid CSV: " + err.Error()}
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		known := false
+		for _, c := range campaignCSVColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, &ValidationError{Msg: fmt.Sprintf("Unknown column %q; columns are %s", name, strings.Join(campaignCSVColumns, ", "))}
+		}
+		columns[name] = i
+	}
+	for _, name := range requiredCSVColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, &ValidationError{Msg: fmt.Sprintf("Missing column %q", name)}
+		}
+	}
+
+	var rows []importRow
+	for n := 2; ; n++ {
+		fields, err := in.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, &ValidationError{Msg: "Invalid CSV: " + err.Error()}
+		}
+		row := importRow{row: n}
+		if err != nil {
+			row.err = fmt.Sprintf("Expected %d fields, got %d", len(header), len(fields))
+		} else {
+			row.record, row.err = parseCampaignCSVRow(columns, fields)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseCampaignCSVRow reads one CSV row, returning why it is invalid if it is
+func parseCampaignCSVRow(columns map[string]int, fields []string) (models.CampaignRecord, string) {
+	get := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	r := models.CampaignRecord{
+		ID:        get("id"),
+		Name:      get("name"),
+		TenantID:  get("tenant_id"),
+		TargetDMA: get("target_dma"),
+		Status:    get("status"),
+	}
+	var err error
+	if r.StartTime, err = parseImportTime(get("start_time")); err != nil {
+		return r, "start_time must be an RFC 3339 time or a date"
+	}
+	if r.EndTime, err = parseImportTime(get("end_time")); err != nil {
+		return r, "end_time must be an RFC 3339 time or a date"
+	}
+	if v := get("impression_goal"); v != "" {
+		if r.ImpressionGoal, err = strconv.Atoi(v); err != nil {
+			return r, "impression_goal must be a whole number"
+		}
+	}
+	for _, id := range strings.Split(get("creative_ids"), ";") {
+		if id = strings.TrimSpace(id); id != "" {
+			r.CreativeIDs = append(r.CreativeIDs, id)
+		}
+	}
+	return r, ""
+}
+
+// parseImportTime reads RFC 3339 times, or dates as midnight UTC. Empty is
+// left to campaign validation.
+func parseImportTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
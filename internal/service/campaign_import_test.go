@@ -0,0 +1,158 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importCSV builds a CSV import of the campaign with id and new ones
+func importCSV(id string, rows ...string) string {
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	end := time.Now().Add(96 * time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	csv := "id,name,start_time,end_time,target_dma,creative_ids\n"
+	for _, r := range rows {
+		r = strings.ReplaceAll(r, "START", start)
+		r = strings.ReplaceAll(r, "END", end)
+		csv += strings.ReplaceAll(r, "ID", id) + "\n"
+	}
+	return csv
+}
+
+// campaignNames lists the names of the stored campaigns, in any order
+func campaignNames(t *testing.T, f *adFixture) map[string]bool {
+	t.Helper()
+	campaigns, err := f.db.GetAllCampaigns(models.AllTenants, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, c := range campaigns {
+		names[c.Name] = true
+	}
+	return names
+}
+
+func TestImportDryRun(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a")
+	in := importCSV(c.ID,
+		",Summer,START,END,501,spot-b;spot-c",
+		"ID,Spring renamed,START,END,501,spot-a",
+	)
+
+	result, err := f.s.ImportCampaigns(strings.NewReader(in), CampaignFormatCSV, true, models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.DryRun || result.Rows != 2 || result.Created != 1 || result.Updated != 1 || len(result.Errors) != 0 {
+		t.Errorf("dry run result: %+v", result)
+	}
+	if names := campaignNames(t, f); len(names) != 1 || !names["Spring"] {
+		t.Errorf("a dry run wrote campaigns: %v", names)
+	}
+}
+
+func TestImportRejectsWholeFile(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a")
+	in := importCSV(c.ID,
+		",Summer,START,END,501,spot-b",
+		",Autumn,START,END,new york,spot-b",
+		",Winter,START,END,501,missing",
+		"unknown-id,Renamed,START,END,501,spot-a",
+		"ID,Renamed,START,END,501,spot-a",
+		"ID,Renamed again,START,END,501,spot-a",
+		",Short,START",
+	)
+
+	for _, dryRun := range []bool{true, false} {
+		result, err := f.s.ImportCampaigns(strings.NewReader(in), CampaignFormatCSV, dryRun, models.AllTenants, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The header is row 1, so rows match spreadsheet line numbers
+		var rows []int
+		for _, e := range result.Errors {
+			rows = append(rows, e.Row)
+		}
+		if got := fmt.Sprint(rows); got != "[3 4 5 7 8]" {
+			t.Errorf("dry run %v: errors on rows %s, want [3 4 5 7 8]: %+v", dryRun, got, result.Errors)
+		}
+		if names := campaignNames(t, f); len(names) != 1 || !names["Spring"] {
+			t.Errorf("dry run %v: an import with errors wrote campaigns: %v", dryRun, names)
+		}
+	}
+}
+
+func TestImportCommit(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a", "spot-b")
+	kept := adsByCreative(c)["spot-b"]
+	in := importCSV(c.ID,
+		",Summer,START,END,501,spot-b;spot-c",
+		"ID,Spring renamed,START,END,*,spot-b;spot-c",
+	)
+
+	result, err := f.s.ImportCampaigns(strings.NewReader(in), CampaignFormatCSV, false, models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DryRun || result.Created != 1 || result.Updated != 1 || len(result.Errors) != 0 {
+		t.Errorf("import result: %+v", result)
+	}
+	if names := campaignNames(t, f); len(names) != 2 || !names["Summer"] || !names["Spring renamed"] {
+		t.Errorf("campaigns after the import: %v", names)
+	}
+	updated, err := f.db.GetCampaignByID(c.ID, models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ads := adsByCreative(updated)
+	if updated.TargetDMA != "*" || len(ads) != 2 || ads["spot-b"] != kept || ads["spot-c"] == "" {
+		t.Errorf("updated campaign: %+v", updated)
+	}
+
+	// Importing an export changes nothing
+	records, err := f.s.ExportCampaigns(models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteCampaignRecords(&out, CampaignFormatJSON, records); err != nil {
+		t.Fatal(err)
+	}
+	result, err = f.s.ImportCampaigns(&out, CampaignFormatJSON, false, models.AllTenants, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 2 || result.Created != 0 || result.Updated != 0 || len(result.Errors) != 0 {
+		t.Errorf("re-importing the export: %+v", result)
+	}
+}
+
+func TestImportIsOneTransaction(t *testing.T) {
+	f := newAdFixture(t)
+	c := f.campaign(t, "spot-a")
+
+	// The second campaign fails in the store, after the first was written
+	created := models.Campaign{Name: "Summer", StartTime: c.StartTime, EndTime: c.EndTime, TargetDMA: "*", Ads: []models.Ad{{CreativeID: "spot-b"}}}
+	if err := f.s.prepareNewCampaign(&created, models.AllTenants); err != nil {
+		t.Fatal(err)
+	}
+	gone := *c
+	gone.ID = "deleted-meanwhile"
+	gone.Name = "Gone"
+	revs := []models.CampaignRevision{{Action: models.RevisionCreate}, {Action: models.RevisionUpdate}}
+	if err := f.db.ImportCampaigns([]models.Campaign{created, gone}, revs, models.AllTenants); err == nil {
+		t.Fatal("importing an update of a missing campaign succeeded")
+	}
+	if _, err := f.db.GetCampaignByID(created.ID, models.AllTenants); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("the campaign before the failing one was kept: %v", err)
+	}
+}
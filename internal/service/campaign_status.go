@@ -0,0 +1,79 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+// statusTransition is a lifecycle action: the statuses it applies to and
+// the status it stores
+type statusTransition struct {
+	from []string
+	to   string
+}
+
+// statusTransitions are the allowed lifecycle actions. Live and completed
+// follow from the flight dates of a scheduled campaign, so pausing keeps the
+// original flight and resuming picks it up again.
+var statusTransitions = map[string]statusTransition{
+	"schedule":  {from: []string{models.CampaignStatusDraft}, to: models.CampaignStatusScheduled},
+	"pause":     {from: []string{models.CampaignStatusScheduled, models.CampaignStatusLive}, to: models.CampaignStatusPaused},
+	"resume":    {from: []string{models.CampaignStatusPaused}, to: models.CampaignStatusScheduled},
+	"archive":   {from: []string{models.CampaignStatusDraft, models.CampaignStatusPaused, models.CampaignStatusCompleted}, to: models.CampaignStatusArchived},
+	"unarchive": {from: []string{models.CampaignStatusArchived}, to: models.CampaignStatusPaused},
+}
+
+// IsStatusAction reports whether action is a lifecycle action
+func IsStatusAction(action string) bool {
+	_, ok := statusTransitions[action]
+	return ok
+}
+
+// ChangeCampaignStatus applies a lifecycle action (schedule, pause, resume,
+// archive or unarchive) to a campaign. Actions that don't apply to the
+// campaign's current status are rejected; live campaigns must be paused
+// before they are archived.
+func (s *AdService) ChangeCampaignStatus(id, action string, scope models.TenantScope, user *models.User) (*models.Campaign, error) {
+	transition, ok := statusTransitions[action]
+	if !ok {
+		return nil, &ValidationError{Msg: "Unknown action " + action}
+	}
+	campaign, err := s.store.GetCampaignByID(id, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	allowed := false
+	for _, from := range transition.from {
+		allowed = allowed || campaign.Status == from
+	}
+	if !allowed {
+		return nil, &ValidationError{Msg: "Can't " + action + " a " + campaign.Status + " campaign; only " + strings.Join(transition.from, ", ") + " campaigns"}
+	}
+
+	if err := s.store.SetCampaignStatus(id, transition.to, scope, newRevision(models.RevisionUpdate, user)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return s.store.GetCampaignByID(id, scope)
+}
+
+// statusActionOrder is the order actions are offered in
+var statusActionOrder = []string{"schedule", "resume", "pause", "archive", "unarchive"}
+
+// StatusActions maps each campaign status to the actions allowed from it
+func StatusActions() map[string][]string {
+	actions := make(map[string][]string)
+	for _, action := range statusActionOrder {
+		for _, from := range statusTransitions[action].from {
+			actions[from] = append(actions[from], action)
+		}
+	}
+	return actions
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxTemplateDays caps a template's flight length
+const maxTemplateDays = 3660
+
+// CloneCampaign copies a campaign's targeting, ads, impression goal and
+// flight into a new draft with new IDs. name defaults to the original's
+// with " (copy)" appended. Retired creatives can't be cloned.
+func (s *AdService) CloneCampaign(id, name string, scope models.TenantScope, user *models.User) (*models.Campaign, error) {
+	original, err := s.store.GetCampaignByID(id, scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	clone := models.Campaign{
+		ID:             uuid.New().String(),
+		Name:           strings.TrimSpace(name),
+		StartTime:      original.StartTime,
+		EndTime:        original.EndTime,
+		TargetDMA:      original.TargetDMA,
+		TenantID:       original.TenantID,
+		ImpressionGoal: original.ImpressionGoal,
+		Status:         models.CampaignStatusDraft,
+	}
+	if clone.Name == "" {
+		clone.Name = original.Name + " (copy)"
+	}
+	for _, ad := range original.Ads {
+		clone.Ads = append(clone.Ads, models.Ad{CreativeID: ad.CreativeID})
+	}
+	if err := s.CreateCampaign(clone, scope, user); err != nil {
+		return nil, err
+	}
+	return s.store.GetCampaignByID(clone.ID, scope)
+}
+
+// ListCampaignTemplates lists the shared templates and those of the tenants
+// in scope
+func (s *AdService) ListCampaignTemplates(scope models.TenantScope) ([]models.CampaignTemplate, error) {
+	templates, err := s.store.GetCampaignTemplates(scope)
+	if templates == nil && err == nil {
+		templates = []models.CampaignTemplate{}
+	}
+	return templates, err
+}
+
+// GetCampaignTemplate returns a template usable in scope
+func (s *AdService) GetCampaignTemplate(id string, scope models.TenantScope) (*models.CampaignTemplate, error) {
+	t, err := s.store.GetCampaignTemplate(id, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+// CreateCampaignTemplate saves a template for t.TenantID's advertiser, or
+// a shared one when it is empty, which only platform users may create
+func (s *AdService) CreateCampaignTemplate(t models.CampaignTemplate, scope models.TenantScope, user *models.User) (*models.CampaignTemplate, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return nil, &ValidationError{Msg: "Template name is required"}
+	}
+	if t.DurationDays < 1 || t.DurationDays > maxTemplateDays {
+		return nil, &ValidationError{Msg: fmt.Sprintf("Flight length must be between 1 and %d days", maxTemplateDays)}
+	}
+	if t.TargetDMA != "*" && !dmaPattern.MatchString(t.TargetDMA) {
+		return nil, &ValidationError{Msg: "Target DMA must be * or a numeric DMA code of up to three digits"}
+	}
+	if t.ImpressionGoal < 0 {
+		return nil, &ValidationError{Msg: "Impression goal can't be negative"}
+	}
+	if err := s.checkAdvertiser(t.TenantID, scope); err != nil {
+		return nil, err
+	}
+	if t.CreativeID != "" {
+		// Advertisers can only use their own creatives, so no creative
+		// works for every user of a shared template, and naming one would
+		// show its ID to every tenant
+		if t.TenantID == "" {
+			return nil, &ValidationError{Msg: "Shared templates can't include a creative; pick an advertiser to add one"}
+		}
+		creativeScope := models.TenantScope{TenantIDs: []string{t.TenantID}}
+		if _, err := s.store.GetCreativeByID(t.CreativeID, creativeScope); errors.Is(err, sql.ErrNoRows) {
+			return nil, &ValidationError{Msg: "Unknown creative"}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	t.ID = uuid.New().String()
+	t.CreatedAt = time.Now()
+	t.CreatedBy = ""
+	if user != nil {
+		t.CreatedBy = user.Username
+	}
+	if err := s.store.CreateCampaignTemplate(t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// DeleteCampaignTemplate removes a template. Shared templates can only be
+// removed by platform users.
+func (s *AdService) DeleteCampaignTemplate(id string, scope models.TenantScope) error {
+	if err := s.store.DeleteCampaignTemplate(id, scope); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
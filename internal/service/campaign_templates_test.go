@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"rockbot-adserver/internal/models"
+	"testing"
+	"time"
+)
+
+func TestCreateCampaignTemplateCreatives(t *testing.T) {
+	db := newTestStore(t)
+	s := NewAdService(db, RateLimit{MaxSeconds: 300, Window: time.Hour}, "http://ads.example.com")
+	tenant, err := NewTenantService(db).Create("Acme", models.TenantTypeAdvertiser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creative := models.CreativeAsset{
+		ID: "acme-spot", Name: "Acme spot", MediaURL: "http://example.com/acme.mp4", MimeType: "video/mp4",
+		DurationSeconds: 15, TenantID: tenant.ID, Status: models.CreativeStatusActive, CreatedAt: time.Now(),
+	}
+	if err := db.CreateCreative(creative); err != nil {
+		t.Fatal(err)
+	}
+	template := func(tenantID, creativeID string) models.CampaignTemplate {
+		return models.CampaignTemplate{Name: "t", DurationDays: 30, TargetDMA: "*", TenantID: tenantID, CreativeID: creativeID}
+	}
+	var verr *ValidationError
+
+	// A platform user can't put an advertiser's creative in a shared template
+	if _, err := s.CreateCampaignTemplate(template("", creative.ID), models.AllTenants, nil); !errors.As(err, &verr) {
+		t.Errorf("shared template with a creative: got %v, want a ValidationError", err)
+	}
+	if _, err := s.CreateCampaignTemplate(template("", ""), models.AllTenants, nil); err != nil {
+		t.Errorf("shared template without a creative: %v", err)
+	}
+	if _, err := s.CreateCampaignTemplate(template(tenant.ID, creative.ID), models.AllTenants, nil); err != nil {
+		t.Errorf("advertiser template with its own creative: %v", err)
+	}
+
+	tooLong := template("", "")
+	tooLong.DurationDays = maxTemplateDays + 1
+	if _, err := s.CreateCampaignTemplate(tooLong, models.AllTenants, nil); !errors.As(err, &verr) || verr.Msg != "Flight length must be between 1 and 3660 days" {
+		t.Errorf("too long a flight: got %v", err)
+	}
+}
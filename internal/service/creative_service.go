@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"rockbot-adserver/internal/blob"
+	"rockbot-adserver/internal/media"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// creativeMimeTypes lists the upload extensions we accept and their content types
+var creativeMimeTypes = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+	".mov": "video/quicktime",
+}
+
+// CreativeRules decides which uploaded files are accepted into the library
+type CreativeRules struct {
+	// AllowedCodecs lists video and audio codec names (as reported by
+	// media.Inspect, e.g. "h264", "aac") that devices can play
+	AllowedCodecs []string
+	// DurationToleranceSeconds is how far the declared duration may be from
+	// the duration found in the file
+	DurationToleranceSeconds float64
+}
+
+// DefaultCreativeRules accepts H.264/HEVC video with AAC audio and allows
+// for rounding of the declared duration
+var DefaultCreativeRules = CreativeRules{
+	AllowedCodecs:            []string{"h264", "hevc", "aac"},
+	DurationToleranceSeconds: 1,
+}
+
+// CreativeUpload describes a new library creative. Either File (with
+// Filename) or MediaURL must be set; MediaURL registers an externally hosted
+// file, which is fetched to be inspected. DurationSeconds may be left at 0;
+// the stored duration is always the inspected one.
+type CreativeUpload struct {
+	Name            string
+	DurationSeconds int
+	Filename        string
+	File            io.ReadSeeker
+	MediaURL        string
+	TenantID        string
+}
+
+// remoteInspectTimeout bounds fetching a hosted creative to inspect it
+const remoteInspectTimeout = 30 * time.Second
+
+type CreativeService struct {
+	store  *store.Store
+	blobs  blob.Store
+	rules  CreativeRules
+	client *http.Client
+}
+
+func NewCreativeService(store *store.Store, blobs blob.Store, rules CreativeRules) *CreativeService {
+	return &CreativeService{store: store, blobs: blobs, rules: rules, client: media.PublicClient(remoteInspectTimeout)}
+}
+
+// inspectRemote fetches and inspects a hosted creative. Why a fetch failed
+// is only logged: echoing it would let callers probe hosts and ports
+// through the server.
+func (s *CreativeService) inspectRemote(mediaURL string) (*media.Info, error) {
+	info, err := s.inspectURL(mediaURL)
+	var fetchErr *media.FetchError
+	if errors.As(err, &fetchErr) {
+		slog.Warn("Could not fetch media_url", "media_url", mediaURL, "error", err)
+		return nil, &ValidationError{Msg: "Could not fetch media_url. It must be a publicly reachable server that answers HTTP range requests"}
+	}
+	if err != nil {
+		return nil, &ValidationError{Msg: "Could not read video at media_url: " + err.Error()}
+	}
+	return info, nil
+}
+
+func (s *CreativeService) inspectURL(mediaURL string) (*media.Info, error) {
+	remote, err := media.OpenRemote(context.Background(), s.client, mediaURL)
+	if err != nil {
+		return nil, err
+	}
+	return media.Inspect(remote)
+}
+
+// Upload inspects the creative's file, or fetches and inspects a hosted one,
+// stores an uploaded file in the blob backend and adds the creative to the
+// library of u.TenantID, which must be in scope
+func (s *CreativeService) Upload(u CreativeUpload, scope models.TenantScope) (*models.CreativeAsset, error) {
+	if err := checkOwner(scope, u.TenantID); err != nil {
+		return nil, err
+	}
+	if u.DurationSeconds < 0 {
+		return nil, &ValidationError{Msg: "duration_seconds must be positive"}
+	}
+
+	c := models.CreativeAsset{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(u.Name),
+		TenantID:  u.TenantID,
+		Status:    models.CreativeStatusActive,
+		CreatedAt: time.Now(),
+	}
+
+	switch {
+	case u.File != nil:
+		ext := strings.ToLower(filepath.Ext(u.Filename))
+		if _, ok := creativeMimeTypes[ext]; !ok {
+			return nil, &ValidationError{Msg: "Unsupported file type " + ext + "; upload .mp4, .m4v or .mov"}
+		}
+		if c.Name == "" {
+			c.Name = strings.TrimSuffix(filepath.Base(u.Filename), filepath.Ext(u.Filename))
+		}
+
+		info, err := media.Inspect(u.File)
+		if err != nil {
+			return nil, &ValidationError{Msg: "Could not read video file: " + err.Error()}
+		}
+		if err := s.applyMedia(&c, info, u.DurationSeconds); err != nil {
+			return nil, err
+		}
+
+		if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		c.StorageKey = "creatives/" + c.ID + ext
+
+		size, err := s.blobs.Put(c.StorageKey, u.File)
+		if err != nil {
+			return nil, err
+		}
+		c.SizeBytes = size
+		c.MediaURL = s.blobs.URL(c.StorageKey)
+	case u.MediaURL != "":
+		parsed, err := url.Parse(u.MediaURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, &ValidationError{Msg: "media_url must be an http(s) URL"}
+		}
+		info, err := s.inspectRemote(u.MediaURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.applyMedia(&c, info, u.DurationSeconds); err != nil {
+			return nil, err
+		}
+		c.MediaURL = u.MediaURL
+		c.SizeBytes = info.SizeBytes
+		if c.Name == "" {
+			c.Name = filepath.Base(parsed.Path)
+		}
+	default:
+		return nil, &ValidationError{Msg: "A file or media_url is required"}
+	}
+
+	if err := s.store.CreateCreative(c); err != nil {
+		if c.StorageKey != "" {
+			s.blobs.Delete(c.StorageKey)
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// applyMedia checks an inspected file and copies what was learned onto c.
+// The stored duration is always the file's own; a declared one only has to
+// agree with it.
+func (s *CreativeService) applyMedia(c *models.CreativeAsset, info *media.Info, declaredSeconds int) error {
+	if err := s.checkMedia(info, declaredSeconds); err != nil {
+		return err
+	}
+	c.DurationSeconds = max(int(math.Round(info.Duration)), 1)
+	c.Container = info.Container
+	c.Width = info.Width
+	c.Height = info.Height
+	c.VideoCodec = info.VideoCodec
+	c.AudioCodec = info.AudioCodec
+	c.BitrateBps = info.BitrateBps
+	c.MimeType = "video/mp4"
+	if info.Container == "mov" {
+		c.MimeType = "video/quicktime"
+	}
+	return nil
+}
+
+// checkMedia rejects files whose codecs devices can't play or whose real
+// duration differs from what was declared. Durations feed the per-client
+// rate limit, so a wrong one over- or under-serves every client.
+func (s *CreativeService) checkMedia(info *media.Info, declaredSeconds int) error {
+	for _, codec := range []string{info.VideoCodec, info.AudioCodec} {
+		if codec != "" && !s.codecAllowed(codec) {
+			return &ValidationError{Msg: fmt.Sprintf("Codec %q is not allowed; allowed codecs: %s",
+				codec, strings.Join(s.rules.AllowedCodecs, ", "))}
+		}
+	}
+	if info.Duration <= 0 {
+		return &ValidationError{Msg: "Video file has no duration"}
+	}
+	if declaredSeconds > 0 && math.Abs(float64(declaredSeconds)-info.Duration) > s.rules.DurationToleranceSeconds {
+		return &ValidationError{Msg: fmt.Sprintf("Declared duration %ds does not match file duration %.2fs",
+			declaredSeconds, info.Duration)}
+	}
+	return nil
+}
+
+func (s *CreativeService) codecAllowed(codec string) bool {
+	if len(s.rules.AllowedCodecs) == 0 {
+		return true
+	}
+	for _, allowed := range s.rules.AllowedCodecs {
+		if strings.EqualFold(allowed, codec) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the library; retired creatives are only included on request
+func (s *CreativeService) List(includeRetired bool, scope models.TenantScope) ([]models.CreativeAsset, error) {
+	if includeRetired {
+		return s.store.GetCreatives("", scope)
+	}
+	return s.store.GetCreatives(models.CreativeStatusActive, scope)
+}
+
+func (s *CreativeService) Get(id string, scope models.TenantScope) (*models.CreativeAsset, error) {
+	c, err := s.store.GetCreativeByID(id, scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return c, err
+}
+
+// Retire removes a creative from the library and from ad rotation. The file
+// is kept so historical impressions can still be traced back to it.
+func (s *CreativeService) Retire(id string, scope models.TenantScope) error {
+	if err := s.store.RetireCreative(id, time.Now(), scope); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestUploadRefusesInternalMediaURL(t *testing.T) {
+	requests := 0
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer internal.Close()
+
+	s := NewCreativeService(newTestStore(t), nil, CreativeRules{})
+	for _, mediaURL := range []string{
+		internal.URL + "/video.mp4",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:22/",
+	} {
+		_, err := s.Upload(CreativeUpload{Name: "probe", MediaURL: mediaURL}, models.AllTenants)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want a ValidationError", mediaURL, err)
+			continue
+		}
+		// The message must not tell the caller what is listening where
+		if !strings.HasPrefix(verr.Msg, "Could not fetch media_url.") || strings.Contains(verr.Msg, "127.0.0.1") ||
+			strings.Contains(verr.Msg, "refused") || strings.Contains(verr.Msg, "not public") {
+			t.Errorf("%s: message %q leaks why the fetch failed", mediaURL, verr.Msg)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("the internal server got %d requests", requests)
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeviceUnauthorized is returned for missing, unknown, revoked or badly
+// signed device credentials
+var ErrDeviceUnauthorized = errors.New("device unauthorized")
+
+// DeviceService issues device credentials and checks them on /vast requests.
+// Devices either send "Authorization: Bearer <device_id>.<secret>" or sign
+// the query string with HMAC-SHA256 (see VerifySignature).
+type DeviceService struct {
+	store        *store.Store
+	replayWindow time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // signatures used within the replay window
+	lastPrune time.Time
+}
+
+func NewDeviceService(store *store.Store, replayWindow time.Duration) *DeviceService {
+	return &DeviceService{store: store, replayWindow: replayWindow, seen: make(map[string]time.Time)}
+}
+
+// Issue creates a device credential. The secret is returned only here; the
+// caller has to hand it to the device. If clientID is empty the device ID is used.
+func (s *DeviceService) Issue(name, venue, clientID string) (*models.Device, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{Msg: "Device name is required"}
+	}
+
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, err
+	}
+
+	d := models.Device{
+		ID:        "dev_" + hex.EncodeToString(idBytes),
+		Name:      name,
+		Venue:     strings.TrimSpace(venue),
+		ClientID:  strings.TrimSpace(clientID),
+		Secret:    base64.RawURLEncoding.EncodeToString(secretBytes),
+		CreatedAt: time.Now(),
+	}
+	if d.ClientID == "" {
+		d.ClientID = d.ID
+	}
+	if err := s.store.CreateDevice(d); err != nil {
+		if errors.Is(err, store.ErrClientIDTaken) {
+			return nil, &ValidationError{Msg: "client_id is already bound to an active device; revoke it first to issue a new key"}
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *DeviceService) List() ([]models.Device, error) {
+	return s.store.GetDevices()
+}
+
+// Revoke disables a device; its next request is rejected
+func (s *DeviceService) Revoke(id string) error {
+	if err := s.store.RevokeDevice(id, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// AuthenticateKey checks a bearer key of the form "<device_id>.<secret>"
+func (s *DeviceService) AuthenticateKey(key string) (*models.Device, error) {
+	id, secret, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil, ErrDeviceUnauthorized
+	}
+	d, err := s.activeDevice(id)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(d.Secret)) != 1 {
+		return nil, ErrDeviceUnauthorized
+	}
+	return d, nil
+}
+
+// VerifySignature checks an HMAC-signed request. The query must carry
+// device_id, ts (unix seconds) and sig, where sig is the hex HMAC-SHA256,
+// keyed with the device secret, of
+//
+//	METHOD + "\n" + PATH + "\n" + every other query parameter sorted by name and URL-encoded
+//
+// Requests outside the replay window, or reusing a signature inside it, are
+// rejected; devices that may repeat a request within a second should add a
+// random "nonce" parameter.
+func (s *DeviceService) VerifySignature(method, path string, query url.Values, now time.Time) (*models.Device, error) {
+	sig := query.Get("sig")
+	ts, err := strconv.ParseInt(query.Get("ts"), 10, 64)
+	if sig == "" || err != nil {
+		return nil, ErrDeviceUnauthorized
+	}
+	signedAt := time.Unix(ts, 0)
+	if now.Sub(signedAt) > s.replayWindow || signedAt.Sub(now) > s.replayWindow {
+		return nil, ErrDeviceUnauthorized
+	}
+
+	d, err := s.activeDevice(query.Get("device_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	expected := SignRequest(d.Secret, method, path, query)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, ErrDeviceUnauthorized
+	}
+	if !s.markSeen(sig, signedAt, now) {
+		return nil, ErrDeviceUnauthorized
+	}
+	return d, nil
+}
+
+// SignRequest computes the signature VerifySignature expects. The "sig"
+// parameter itself is ignored if present.
+func SignRequest(secret, method, path string, query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != "sig" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strings.Join(params, "&")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// markSeen records a signature and reports whether it was new. Entries older
+// than the replay window are dropped since their timestamp no longer passes.
+func (s *DeviceService) markSeen(sig string, signedAt, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, t := range s.seen {
+			if now.Sub(t) > s.replayWindow {
+				delete(s.seen, k)
+			}
+		}
+		s.lastPrune = now
+	}
+	if _, ok := s.seen[sig]; ok {
+		return false
+	}
+	s.seen[sig] = signedAt
+	return true
+}
+
+func (s *DeviceService) activeDevice(id string) (*models.Device, error) {
+	if id == "" {
+		return nil, ErrDeviceUnauthorized
+	}
+	d, err := s.store.GetDeviceByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeviceUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.RevokedAt != nil {
+		return nil, ErrDeviceUnauthorized
+	}
+	return d, nil
+}
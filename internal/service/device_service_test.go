@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"path/filepath"
+	"rockbot-adserver/internal/store"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	db, err := store.NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDeviceKeyRotation(t *testing.T) {
+	s := NewDeviceService(newTestStore(t), 5*time.Minute)
+
+	old, err := s.Issue("Lobby screen", "Store 12", "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verr *ValidationError
+	if _, err := s.Issue("Second screen", "Store 12", "lobby"); !errors.As(err, &verr) {
+		t.Fatalf("second active device for the same client: got %v, want a ValidationError", err)
+	}
+
+	if err := s.Revoke(old.ID); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := s.Issue("Lobby screen", "Store 12", "lobby")
+	if err != nil {
+		t.Fatalf("issuing a new key after revoking the old one: %v", err)
+	}
+	if _, err := s.AuthenticateKey(old.ID + "." + old.Secret); !errors.Is(err, ErrDeviceUnauthorized) {
+		t.Errorf("revoked key: got %v, want ErrDeviceUnauthorized", err)
+	}
+	d, err := s.AuthenticateKey(rotated.ID + "." + rotated.Secret)
+	if err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	if d.ClientID != "lobby" {
+		t.Errorf("new key serves as %q, want lobby", d.ClientID)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	window := 5 * time.Minute
+	s := NewDeviceService(newTestStore(t), window)
+	d, err := s.Issue("Lobby screen", "Store 12", "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_800_000_000, 0)
+
+	// signed returns a query for d signed with secret at signedAt, with
+	// extra parameters added
+	signed := func(secret string, signedAt time.Time, extra url.Values) url.Values {
+		q := url.Values{"device_id": {d.ID}, "ts": {strconv.FormatInt(signedAt.Unix(), 10)}, "dma": {"501"}}
+		for k, v := range extra {
+			q[k] = v
+		}
+		q.Set("sig", SignRequest(secret, "GET", "/vast", q))
+		return q
+	}
+	verify := func(q url.Values) error {
+		_, err := s.VerifySignature("GET", "/vast", q, now)
+		return err
+	}
+
+	q := signed(d.Secret, now, nil)
+	if err := verify(q); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+	if err := verify(q); !errors.Is(err, ErrDeviceUnauthorized) {
+		t.Errorf("replayed signature: got %v, want ErrDeviceUnauthorized", err)
+	}
+	// A nonce makes a repeat of the same request a new signature
+	if err := verify(signed(d.Secret, now, url.Values{"nonce": {"a"}})); err != nil {
+		t.Errorf("same request with a nonce: %v", err)
+	}
+	if err := verify(signed(d.Secret, now.Add(-window+time.Second), nil)); err != nil {
+		t.Errorf("signed just inside the window: %v", err)
+	}
+
+	tampered := signed(d.Secret, now, url.Values{"nonce": {"b"}})
+	tampered.Set("dma", "803")
+	wrongPath := signed(d.Secret, now, url.Values{"nonce": {"c"}})
+	rejected := map[string]url.Values{
+		"tampered parameter": tampered,
+		"wrong secret":       signed("not-the-secret", now, url.Values{"nonce": {"d"}}),
+		"too old":            signed(d.Secret, now.Add(-window-time.Second), nil),
+		"too far ahead":      signed(d.Secret, now.Add(window+time.Second), nil),
+		"unknown device":     {"device_id": {"dev_unknown"}, "ts": {strconv.FormatInt(now.Unix(), 10)}, "sig": {"00"}},
+		"no timestamp":       {"device_id": {d.ID}, "sig": {SignRequest(d.Secret, "GET", "/vast", url.Values{"device_id": {d.ID}})}},
+	}
+	for name, q := range rejected {
+		if err := verify(q); !errors.Is(err, ErrDeviceUnauthorized) {
+			t.Errorf("%s: got %v, want ErrDeviceUnauthorized", name, err)
+		}
+	}
+	if _, err := s.VerifySignature("GET", "/vast/events", wrongPath, now); !errors.Is(err, ErrDeviceUnauthorized) {
+		t.Errorf("signature for another path: got %v, want ErrDeviceUnauthorized", err)
+	}
+
+	if err := s.Revoke(d.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(signed(d.Secret, now, url.Values{"nonce": {"e"}})); !errors.Is(err, ErrDeviceUnauthorized) {
+		t.Errorf("revoked device: got %v, want ErrDeviceUnauthorized", err)
+	}
+}
+
+func TestAuthenticateKey(t *testing.T) {
+	s := NewDeviceService(newTestStore(t), time.Minute)
+	d, err := s.Issue("Lobby screen", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ClientID != d.ID {
+		t.Errorf("client_id defaults to %q, want the device ID", d.ClientID)
+	}
+	if _, err := s.AuthenticateKey(d.ID + "." + d.Secret); err != nil {
+		t.Errorf("valid key: %v", err)
+	}
+	for _, key := range []string{"", d.ID, d.ID + ".", d.ID + ".wrong", "dev_unknown." + d.Secret, d.Secret} {
+		if _, err := s.AuthenticateKey(key); !errors.Is(err, ErrDeviceUnauthorized) {
+			t.Errorf("key %q: got %v, want ErrDeviceUnauthorized", key, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"math"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"time"
+)
+
+// ReportService answers delivery reporting queries
+type ReportService struct {
+	store *store.Store
+}
+
+func NewReportService(store *store.Store) *ReportService {
+	return &ReportService{store: store}
+}
+
+// Where a delivery report was read from
+const (
+	ReportSourceRollups     = "rollups"
+	ReportSourceImpressions = "impressions"
+)
+
+// Delivery aggregates the impressions of the tenants in scope. Reports are
+// read from the rollups, which trail serving by up to the aggregator
+// interval, unless they need clients or unique client counts, or a range
+// that isn't whole hours; those scan the impressions. It returns which
+// source answered.
+func (s *ReportService) Delivery(q models.DeliveryQuery, scope models.TenantScope) ([]models.DeliveryRow, string, error) {
+	seen := make(map[string]bool)
+	for _, dim := range q.GroupBy {
+		if !store.IsDeliveryDimension(dim) {
+			return nil, "", &ValidationError{Msg: "Unknown group_by dimension " + dim}
+		}
+		if seen[dim] {
+			return nil, "", &ValidationError{Msg: "Dimension " + dim + " is grouped twice"}
+		}
+		seen[dim] = true
+	}
+	for dim := range q.Filters {
+		if !store.IsDeliveryDimension(dim) || dim == models.DimensionHour || dim == models.DimensionDay {
+			return nil, "", &ValidationError{Msg: "Can't filter by " + dim}
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.To.After(q.From) {
+		return nil, "", &ValidationError{Msg: "to must be after from"}
+	}
+
+	var rows []models.DeliveryRow
+	var err error
+	source := ReportSourceRollups
+	if store.CanUseRollups(q) {
+		rows, err = s.store.GetRollupDeliveryReport(q, scope)
+	} else {
+		source = ReportSourceImpressions
+		rows, err = s.store.GetDeliveryReport(q, scope)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if rows == nil {
+		rows = []models.DeliveryRow{}
+	}
+	return rows, source, nil
+}
+
+// Pacing compares the lifetime delivery of each campaign in scope that has
+// an impression goal and runs during [from, to) with an even spread of the
+// goal over its flight, as of now
+func (s *ReportService) Pacing(from, to, now time.Time, scope models.TenantScope) ([]models.CampaignPacing, error) {
+	campaigns, err := s.store.GetAllCampaigns(scope, false)
+	if err != nil {
+		return nil, err
+	}
+	delivered, _, err := s.Delivery(models.DeliveryQuery{GroupBy: []string{models.DimensionCampaign}}, scope)
+	if err != nil {
+		return nil, err
+	}
+	byCampaign := make(map[string]int, len(delivered))
+	for _, row := range delivered {
+		byCampaign[row.Dimensions[models.DimensionCampaign]] = row.Impressions
+	}
+
+	pacing := []models.CampaignPacing{}
+	for _, c := range campaigns {
+		if c.ImpressionGoal == 0 || !c.StartTime.Before(to) || !c.EndTime.After(from) {
+			continue
+		}
+		elapsed := 1.0
+		if now.Before(c.EndTime) {
+			elapsed = math.Max(0, float64(now.Sub(c.StartTime))/float64(c.EndTime.Sub(c.StartTime)))
+		}
+		p := models.CampaignPacing{
+			Campaign:  c,
+			Delivered: byCampaign[c.ID],
+			Expected:  int(math.Round(float64(c.ImpressionGoal) * elapsed)),
+		}
+		if p.Expected > 0 {
+			p.Pace = float64(p.Delivered) / float64(p.Expected)
+		}
+		pacing = append(pacing, p)
+	}
+	return pacing, nil
+}
+
+// AdRequests summarises ad requests over the hours in [from, to). Requests
+// aren't owned by any tenant, so only platform users may see them.
+func (s *ReportService) AdRequests(from, to time.Time, scope models.TenantScope) (models.AdRequestSummary, error) {
+	var summary models.AdRequestSummary
+	if !scope.All {
+		return summary, ErrNotFound
+	}
+	counts, err := s.store.GetAdRequestCounts(from, to)
+	if err != nil {
+		return summary, err
+	}
+	summary.Filled = counts["filled"]
+	summary.Empty = counts["empty"]
+	summary.RateLimited = counts["rate_limited"]
+	summary.Errors = counts["error"]
+	for _, n := range counts {
+		summary.Requests += n
+	}
+	if summary.Requests > 0 {
+		summary.FillRate = float64(summary.Filled) / float64(summary.Requests)
+	}
+	return summary, nil
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"rockbot-adserver/internal/store"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TenantService struct {
+	store *store.Store
+}
+
+func NewTenantService(store *store.Store) *TenantService {
+	return &TenantService{store: store}
+}
+
+// Create adds an agency, or an advertiser optionally owned by an agency
+func (s *TenantService) Create(name, tenantType, parentID string) (*models.Tenant, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, &ValidationError{Msg: "Tenant name is required"}
+	}
+
+	switch tenantType {
+	case models.TenantTypeAgency:
+		if parentID != "" {
+			return nil, &ValidationError{Msg: "Agencies cannot have a parent"}
+		}
+	case models.TenantTypeAdvertiser:
+		if parentID != "" {
+			parent, err := s.store.GetTenantByID(parentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, &ValidationError{Msg: "Unknown parent agency"}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if parent.Type != models.TenantTypeAgency {
+				return nil, &ValidationError{Msg: "An advertiser's parent must be an agency"}
+			}
+		}
+	default:
+		return nil, &ValidationError{Msg: "Tenant type must be agency or advertiser"}
+	}
+
+	tenant := models.Tenant{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Type:      tenantType,
+		ParentID:  parentID,
+		CreatedAt: time.Now(),
+	}
+	if err := s.store.CreateTenant(tenant); err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// List returns the tenants in scope
+func (s *TenantService) List(scope models.TenantScope) ([]models.Tenant, error) {
+	return s.store.GetTenants(scope)
+}
+
+func (s *TenantService) Get(id string) (*models.Tenant, error) {
+	t, err := s.store.GetTenantByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+// Advertisers returns the advertisers in scope, the tenants that can own campaigns and creatives
+func (s *TenantService) Advertisers(scope models.TenantScope) ([]models.Tenant, error) {
+	tenants, err := s.store.GetTenants(scope)
+	if err != nil {
+		return nil, err
+	}
+	var advertisers []models.Tenant
+	for _, t := range tenants {
+		if t.Type == models.TenantTypeAdvertiser {
+			advertisers = append(advertisers, t)
+		}
+	}
+	return advertisers, nil
+}
+
+// ScopeFor returns the tenants a user may see. Platform users (no tenant)
+// see everything, agency users see the agency and its advertisers, and
+// advertiser users see only their own advertiser.
+func (s *TenantService) ScopeFor(user *models.User) (models.TenantScope, error) {
+	if user == nil {
+		return models.TenantScope{}, nil
+	}
+	if user.TenantID == "" {
+		return models.AllTenants, nil
+	}
+
+	tenant, err := s.store.GetTenantByID(user.TenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.TenantScope{}, nil
+	}
+	if err != nil {
+		return models.TenantScope{}, err
+	}
+
+	scope := models.TenantScope{TenantIDs: []string{tenant.ID}}
+	if tenant.Type == models.TenantTypeAgency {
+		children, err := s.store.GetChildTenantIDs(tenant.ID)
+		if err != nil {
+			return models.TenantScope{}, err
+		}
+		scope.TenantIDs = append(scope.TenantIDs, children...)
+	}
+	return scope, nil
+}
+
+// checkOwner verifies that the user whose scope this is may create rows
+// owned by tenantID. Only platform users may create house (untenanted) rows.
+func checkOwner(scope models.TenantScope, tenantID string) error {
+	if scope.Includes(tenantID) {
+		return nil
+	}
+	if tenantID == "" {
+		return &ValidationError{Msg: "An advertiser is required"}
+	}
+	return &ValidationError{Msg: "Unknown advertiser"}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"rockbot-adserver/internal/models"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestScopeFor(t *testing.T) {
+	s := NewTenantService(newTestStore(t))
+	create := func(name, tenantType, parentID string) string {
+		t.Helper()
+		tenant, err := s.Create(name, tenantType, parentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tenant.ID
+	}
+	agency := create("Agency", models.TenantTypeAgency, "")
+	client1 := create("Client 1", models.TenantTypeAdvertiser, agency)
+	client2 := create("Client 2", models.TenantTypeAdvertiser, agency)
+	other := create("Other agency", models.TenantTypeAgency, "")
+	direct := create("Direct", models.TenantTypeAdvertiser, "")
+
+	tests := []struct {
+		name string
+		user *models.User
+		all  bool
+		want []string
+	}{
+		{"platform user", &models.User{}, true, nil},
+		{"agency user sees its advertisers", &models.User{TenantID: agency}, false, []string{agency, client1, client2}},
+		{"advertiser user sees only itself", &models.User{TenantID: client1}, false, []string{client1}},
+		{"other agency sees none of them", &models.User{TenantID: other}, false, []string{other}},
+		{"direct advertiser", &models.User{TenantID: direct}, false, []string{direct}},
+		{"unknown tenant sees nothing", &models.User{TenantID: "gone"}, false, nil},
+		{"no user sees nothing", nil, false, nil},
+	}
+	for _, tt := range tests {
+		scope, err := s.ScopeFor(tt.user)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := append([]string(nil), scope.TenantIDs...)
+		want := append([]string(nil), tt.want...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if scope.All != tt.all || strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got %+v, want All=%v %v", tt.name, scope, tt.all, tt.want)
+		}
+	}
+}
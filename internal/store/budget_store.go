@@ -0,0 +1,60 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"time"
+)
+
+// CreateBudgetReset records a reset. Impressions served before it stop
+// counting against the client's rate limit.
+func (s *Store) CreateBudgetReset(r models.BudgetReset) error {
+	// Stored in the server's zone like impression timestamps, so the two
+	// compare correctly as text
+	_, err := s.db.Exec(`INSERT INTO budget_resets (id, client_id, cleared_seconds, reason, user_id, username, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.ClientID, r.ClearedSeconds, nullString(r.Reason), nullString(r.UserID), nullString(r.Username), r.CreatedAt.In(time.Local))
+	return err
+}
+
+// LatestBudgetReset returns the client's most recent reset, or nil if its
+// budget was never reset
+func (s *Store) LatestBudgetReset(clientID string) (*models.BudgetReset, error) {
+	var r models.BudgetReset
+	var reason, userID, username sql.NullString
+	err := s.db.QueryRow(`SELECT id, client_id, cleared_seconds, reason, user_id, username, created_at
+		FROM budget_resets WHERE client_id = ? ORDER BY created_at DESC LIMIT 1`, clientID).
+		Scan(&r.ID, &r.ClientID, &r.ClearedSeconds, &reason, &userID, &username, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Reason, r.UserID, r.Username = reason.String, userID.String, username.String
+	return &r, nil
+}
+
+// GetClientImpressions lists the client's impressions served after since,
+// oldest first
+func (s *Store) GetClientImpressions(clientID string, since time.Time) ([]models.Impression, error) {
+	rows, err := s.db.Query(`SELECT id, client_id, ad_id, COALESCE(campaign_id, ''), COALESCE(creative_id, ''), COALESCE(tenant_id, ''),
+		COALESCE(dma, ''), duration_seconds, timestamp
+		FROM impressions WHERE client_id = ? AND timestamp > ? ORDER BY timestamp`, clientID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var impressions []models.Impression
+	for rows.Next() {
+		var imp models.Impression
+		if err := rows.Scan(&imp.ID, &imp.ClientID, &imp.AdID, &imp.CampaignID, &imp.CreativeID, &imp.TenantID,
+			&imp.DMA, &imp.DurationSeconds, &imp.Timestamp); err != nil {
+			return nil, err
+		}
+		impressions = append(impressions, imp)
+	}
+	return impressions, rows.Err()
+}
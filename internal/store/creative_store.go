@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+	"rockbot-adserver/internal/models"
+	"time"
+)
+
+const creativeColumns = "id, name, media_url, storage_key, mime_type, size_bytes, duration_seconds, " +
+	"container, width, height, video_codec, audio_codec, bitrate_bps, COALESCE(tenant_id, ''), status, created_at, retired_at"
+
+func scanCreative(row interface{ Scan(...interface{}) error }) (*models.CreativeAsset, error) {
+	var c models.CreativeAsset
+	var storageKey, container, videoCodec, audioCodec sql.NullString
+	var retiredAt sql.NullTime
+	err := row.Scan(&c.ID, &c.Name, &c.MediaURL, &storageKey, &c.MimeType, &c.SizeBytes, &c.DurationSeconds,
+		&container, &c.Width, &c.Height, &videoCodec, &audioCodec, &c.BitrateBps, &c.TenantID, &c.Status, &c.CreatedAt, &retiredAt)
+	if err != nil {
+		return nil, err
+	}
+	c.StorageKey = storageKey.String
+	c.Container = container.String
+	c.VideoCodec = videoCodec.String
+	c.AudioCodec = audioCodec.String
+	if retiredAt.Valid {
+		c.RetiredAt = &retiredAt.Time
+	}
+	return &c, nil
+}
+
+// CreateCreative adds a creative to the library
+func (s *Store) CreateCreative(c models.CreativeAsset) error {
+	_, err := s.db.Exec(`INSERT INTO creatives (id, name, media_url, storage_key, mime_type, size_bytes, duration_seconds,
+			container, width, height, video_codec, audio_codec, bitrate_bps, tenant_id, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.ID, c.Name, c.MediaURL, nullString(c.StorageKey), c.MimeType, c.SizeBytes, c.DurationSeconds,
+		nullString(c.Container), c.Width, c.Height, nullString(c.VideoCodec), nullString(c.AudioCodec), c.BitrateBps,
+		nullString(c.TenantID), c.Status, c.CreatedAt)
+	return err
+}
+
+// GetCreatives lists library creatives in scope, newest first. An empty status returns all of them.
+func (s *Store) GetCreatives(status string, scope models.TenantScope) ([]models.CreativeAsset, error) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	query := "SELECT " + creativeColumns + " FROM creatives WHERE 1=1" + filter
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at DESC, name"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creatives []models.CreativeAsset
+	for rows.Next() {
+		c, err := scanCreative(rows)
+		if err != nil {
+			return nil, err
+		}
+		creatives = append(creatives, *c)
+	}
+	return creatives, rows.Err()
+}
+
+// GetCreativeByID retrieves a single creative in scope
+func (s *Store) GetCreativeByID(id string, scope models.TenantScope) (*models.CreativeAsset, error) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	return scanCreative(s.db.QueryRow("SELECT "+creativeColumns+" FROM creatives WHERE id = ?"+filter, append([]interface{}{id}, args...)...))
+}
+
+// GetCreativeByMediaURL retrieves the creative in scope hosted at mediaURL, preferring an active one
+func (s *Store) GetCreativeByMediaURL(mediaURL string, scope models.TenantScope) (*models.CreativeAsset, error) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	return scanCreative(s.db.QueryRow("SELECT "+creativeColumns+" FROM creatives WHERE media_url = ?"+filter+" ORDER BY status = 'active' DESC LIMIT 1",
+		append([]interface{}{mediaURL}, args...)...))
+}
+
+// RetireCreative takes a creative out of the library and out of rotation.
+// The row is kept so campaigns and impressions that reference it still resolve.
+func (s *Store) RetireCreative(id string, at time.Time, scope models.TenantScope) error {
+	filter, args := scopeFilter(scope, "tenant_id")
+	res, err := s.db.Exec("UPDATE creatives SET status = ?, retired_at = ? WHERE id = ? AND status = ?"+filter,
+		append([]interface{}{models.CreativeStatusRetired, at, id, models.CreativeStatusActive}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SeedCreatives adds creatives whose media_url is not in the library yet
+func (s *Store) SeedCreatives(creatives []models.CreativeAsset) error {
+	for _, c := range creatives {
+		var exists bool
+		err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM creatives WHERE media_url = ?)", c.MediaURL).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			if err := s.CreateCreative(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrClientIDTaken is returned when a device is created for a client_id that
+// another device, not revoked, is bound to
+var ErrClientIDTaken = errors.New("client_id is bound to an active device")
+
+const deviceColumns = "id, name, venue, client_id, secret, created_at, revoked_at"
+
+func scanDevice(row interface{ Scan(...interface{}) error }) (*models.Device, error) {
+	var d models.Device
+	var venue sql.NullString
+	var revokedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.Name, &venue, &d.ClientID, &d.Secret, &d.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	d.Venue = venue.String
+	if revokedAt.Valid {
+		d.RevokedAt = &revokedAt.Time
+	}
+	return &d, nil
+}
+
+// CreateDevice registers a device credential. It returns ErrClientIDTaken if
+// an active device already serves as d.ClientID.
+func (s *Store) CreateDevice(d models.Device) error {
+	_, err := s.db.Exec("INSERT INTO devices (id, name, venue, client_id, secret, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		d.ID, d.Name, nullString(d.Venue), d.ClientID, d.Secret, d.CreatedAt)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrClientIDTaken
+	}
+	return err
+}
+
+// GetDeviceByID retrieves a device, revoked or not
+func (s *Store) GetDeviceByID(id string) (*models.Device, error) {
+	defer s.timeQuery("get_device")()
+	return scanDevice(s.db.QueryRow("SELECT "+deviceColumns+" FROM devices WHERE id = ?", id))
+}
+
+// GetDevices lists all devices, newest first
+func (s *Store) GetDevices() ([]models.Device, error) {
+	rows, err := s.db.Query("SELECT " + deviceColumns + " FROM devices ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *d)
+	}
+	return devices, rows.Err()
+}
+
+// RevokeDevice disables a device's credentials
+func (s *Store) RevokeDevice(id string, at time.Time) error {
+	res, err := s.db.Exec("UPDATE devices SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order on top of the base schema in initSchema.
+// A migration's version is its index + 1. Only ever append to this list;
+// released migrations must not be edited.
+var migrations = []string{
+	// 1: creative library. Library rows used to live in ads with a NULL
+	// campaign_id; they move to creatives and ads reference them by ID.
+	`
+	CREATE TABLE IF NOT EXISTS creatives (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		media_url TEXT NOT NULL,
+		storage_key TEXT,
+		mime_type TEXT NOT NULL DEFAULT 'video/mp4',
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		duration_seconds INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		retired_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_creatives_status ON creatives(status);
+	INSERT OR IGNORE INTO creatives (id, name, media_url, duration_seconds)
+		SELECT creative_id, creative_id, media_url, duration_seconds FROM ads WHERE campaign_id IS NULL;
+	INSERT OR IGNORE INTO creatives (id, name, media_url, duration_seconds)
+		SELECT creative_id, creative_id, media_url, MAX(duration_seconds) FROM ads GROUP BY creative_id;
+	DELETE FROM ads WHERE campaign_id IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_ads_campaign ON ads(campaign_id);
+	`,
+	// 2: technical metadata read from uploaded files by the media inspector
+	`
+	ALTER TABLE creatives ADD COLUMN container TEXT;
+	ALTER TABLE creatives ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE creatives ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE creatives ADD COLUMN video_codec TEXT;
+	ALTER TABLE creatives ADD COLUMN audio_codec TEXT;
+	ALTER TABLE creatives ADD COLUMN bitrate_bps INTEGER NOT NULL DEFAULT 0;
+	`,
+	// 3: user accounts and server-side sessions. Only a hash of each session
+	// token is stored, so a leaked database can't be used to hijack sessions.
+	`
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+	`,
+	// 4: user roles. Accounts created before roles existed could do
+	// everything, so they become admins.
+	`
+	ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'read-only';
+	UPDATE users SET role = 'admin';
+	`,
+	// 5: device credentials for /vast. The secret is kept because HMAC
+	// verification needs it; client_id is the identity the device serves as.
+	`
+	CREATE TABLE IF NOT EXISTS devices (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		venue TEXT,
+		client_id TEXT NOT NULL UNIQUE,
+		secret TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+	`,
+	// 6: tenants. Advertisers optionally belong to an agency. Campaigns,
+	// creatives and users with a NULL tenant_id belong to the platform itself.
+	`
+	CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		parent_id TEXT REFERENCES tenants(id),
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_tenants_parent ON tenants(parent_id);
+	ALTER TABLE campaigns ADD COLUMN tenant_id TEXT REFERENCES tenants(id);
+	ALTER TABLE creatives ADD COLUMN tenant_id TEXT REFERENCES tenants(id);
+	ALTER TABLE users ADD COLUMN tenant_id TEXT REFERENCES tenants(id);
+	CREATE INDEX IF NOT EXISTS idx_campaigns_tenant ON campaigns(tenant_id);
+	CREATE INDEX IF NOT EXISTS idx_creatives_tenant ON creatives(tenant_id);
+	`,
+	// 7: scrub credentials logged before request log redaction existed:
+	// session cookies and device keys in headers, login and user passwords,
+	// and the device secrets returned when devices were issued.
+	`
+	UPDATE request_logs SET request_headers = '"[redacted]"'
+		WHERE request_headers LIKE '%"Cookie"%' OR request_headers LIKE '%"Authorization"%';
+	UPDATE request_logs SET response_headers = '"[redacted]"'
+		WHERE response_headers LIKE '%"Set-Cookie"%';
+	UPDATE request_logs SET request_body = '[redacted]'
+		WHERE path = '/login' OR path LIKE '/api/users%';
+	UPDATE request_logs SET response_body = '[redacted]'
+		WHERE path LIKE '/api/devices%' AND method = 'POST';
+	UPDATE request_logs SET query_params = '[redacted]'
+		WHERE query_params LIKE '%sig=%';
+	`,
+	// 8: request IDs, so a request log can be matched with the server's log lines
+	`
+	ALTER TABLE request_logs ADD COLUMN request_id TEXT;
+	CREATE INDEX IF NOT EXISTS idx_request_logs_request_id ON request_logs(request_id);
+	`,
+	// 9: impressions keep what they were served for, so delivery reports
+	// don't change when campaigns are edited or deleted. Older rows get
+	// what the current ads still tell us; their DMA is unknown.
+	`
+	ALTER TABLE impressions ADD COLUMN campaign_id TEXT;
+	ALTER TABLE impressions ADD COLUMN creative_id TEXT;
+	ALTER TABLE impressions ADD COLUMN tenant_id TEXT;
+	ALTER TABLE impressions ADD COLUMN dma TEXT;
+	ALTER TABLE impressions ADD COLUMN completed_at DATETIME;
+	UPDATE impressions SET
+		campaign_id = (SELECT a.campaign_id FROM ads a WHERE a.id = impressions.ad_id),
+		creative_id = (SELECT a.creative_id FROM ads a WHERE a.id = impressions.ad_id),
+		tenant_id = (SELECT c.tenant_id FROM ads a JOIN campaigns c ON c.id = a.campaign_id WHERE a.id = impressions.ad_id);
+	CREATE INDEX IF NOT EXISTS idx_impressions_timestamp ON impressions(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_impressions_campaign_ts ON impressions(campaign_id, timestamp);
+	`,
+	// 10: hourly and daily delivery rollups for reports. Buckets are UTC
+	// ('YYYY-MM-DDTHH:00:00Z' and 'YYYY-MM-DD') and are always recomputed
+	// whole from impressions, so refreshing one twice is harmless.
+	// rollup_dirty_hours lists past hours whose impressions changed after
+	// they were rolled up, e.g. by a late completion.
+	`
+	CREATE TABLE IF NOT EXISTS rollups_hourly (
+		bucket TEXT NOT NULL,
+		campaign_id TEXT NOT NULL,
+		ad_id TEXT NOT NULL,
+		dma TEXT NOT NULL,
+		creative_id TEXT NOT NULL,
+		tenant_id TEXT,
+		impressions INTEGER NOT NULL,
+		seconds_served INTEGER NOT NULL,
+		completions INTEGER NOT NULL,
+		PRIMARY KEY (bucket, campaign_id, ad_id, dma, creative_id)
+	);
+	CREATE TABLE IF NOT EXISTS rollups_daily (
+		bucket TEXT NOT NULL,
+		campaign_id TEXT NOT NULL,
+		ad_id TEXT NOT NULL,
+		dma TEXT NOT NULL,
+		creative_id TEXT NOT NULL,
+		tenant_id TEXT,
+		impressions INTEGER NOT NULL,
+		seconds_served INTEGER NOT NULL,
+		completions INTEGER NOT NULL,
+		PRIMARY KEY (bucket, campaign_id, ad_id, dma, creative_id)
+	);
+	CREATE TABLE IF NOT EXISTS rollup_dirty_hours (
+		bucket TEXT PRIMARY KEY
+	);
+	CREATE TABLE IF NOT EXISTS rollup_state (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`,
+	// 11: impression goals for pacing, and hourly counts of ad requests by
+	// result for fill rate. Requests aren't tenant-owned: one response can
+	// carry several advertisers' ads.
+	`
+	ALTER TABLE campaigns ADD COLUMN impression_goal INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS ad_request_counts (
+		bucket TEXT NOT NULL,
+		result TEXT NOT NULL,
+		requests INTEGER NOT NULL,
+		PRIMARY KEY (bucket, result)
+	);
+	`,
+	// 12: budget resets. Impressions served before a client's latest reset
+	// stop counting against its rate limit; the rows are the audit trail.
+	`
+	CREATE TABLE IF NOT EXISTS budget_resets (
+		id TEXT PRIMARY KEY,
+		client_id TEXT NOT NULL,
+		cleared_seconds INTEGER NOT NULL,
+		reason TEXT,
+		user_id TEXT,
+		username TEXT,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_budget_resets_client ON budget_resets(client_id, created_at);
+	`,
+	// 13: campaign history. Each change stores JSON snapshots of the campaign
+	// before and after; the triggers keep the table append-only.
+	`
+	CREATE TABLE IF NOT EXISTS campaign_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		campaign_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		before_json TEXT,
+		after_json TEXT,
+		user_id TEXT,
+		username TEXT,
+		rolled_back_to INTEGER,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_campaign_revisions_campaign ON campaign_revisions(campaign_id, id);
+	CREATE TRIGGER IF NOT EXISTS campaign_revisions_no_update BEFORE UPDATE ON campaign_revisions
+	BEGIN
+		SELECT RAISE(ABORT, 'campaign revisions are append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS campaign_revisions_no_delete BEFORE DELETE ON campaign_revisions
+	BEGIN
+		SELECT RAISE(ABORT, 'campaign revisions are append-only');
+	END;
+	`,
+	// 14: campaign lifecycle. Existing campaigns keep serving during their
+	// flight, so they start out scheduled.
+	`
+	ALTER TABLE campaigns ADD COLUMN status TEXT NOT NULL DEFAULT 'scheduled';
+	CREATE INDEX IF NOT EXISTS idx_campaigns_status ON campaigns(status);
+	`,
+	// 15: soft delete. Deleted campaigns and ads keep their rows, so the
+	// impressions pointing at them stay reportable until they are purged.
+	`
+	ALTER TABLE campaigns ADD COLUMN deleted_at DATETIME;
+	ALTER TABLE ads ADD COLUMN deleted_at DATETIME;
+	CREATE INDEX IF NOT EXISTS idx_campaigns_deleted_at ON campaigns(deleted_at);
+	CREATE INDEX IF NOT EXISTS idx_ads_deleted_at ON ads(deleted_at);
+	`,
+	// 16: campaign templates that pre-fill the create form. Templates
+	// without a tenant are shared with every advertiser.
+	`
+	CREATE TABLE IF NOT EXISTS campaign_templates (
+		id TEXT PRIMARY KEY,
+		tenant_id TEXT,
+		name TEXT NOT NULL,
+		duration_days INTEGER NOT NULL,
+		target_dma TEXT NOT NULL,
+		impression_goal INTEGER NOT NULL DEFAULT 0,
+		creative_id TEXT,
+		created_by TEXT,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_campaign_templates_tenant ON campaign_templates(tenant_id);
+	INSERT OR IGNORE INTO campaign_templates (id, name, duration_days, target_dma, created_at)
+		VALUES ('standard-30-day-national', 'Standard 30-day national', 30, '*', CURRENT_TIMESTAMP);
+	`,
+	// 17: foreign keys are enforced from here on. Sessions are the only
+	// rows that could already point at a missing parent, and they are
+	// cheap to lose.
+	`
+	DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);
+	`,
+	// 18: a client_id only has to be unique among devices that are not
+	// revoked, so a venue can be issued a new key after its old one is
+	// revoked. SQLite can't drop a column constraint, so the table is
+	// rebuilt; nothing references devices.
+	`
+	CREATE TABLE devices_new (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		venue TEXT,
+		client_id TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+	INSERT INTO devices_new (id, name, venue, client_id, secret, created_at, revoked_at)
+		SELECT id, name, venue, client_id, secret, created_at, revoked_at FROM devices;
+	DROP TABLE devices;
+	ALTER TABLE devices_new RENAME TO devices;
+	CREATE UNIQUE INDEX idx_devices_active_client ON devices(client_id) WHERE revoked_at IS NULL;
+	`,
+	// 19: shared templates can't name a creative: advertisers can only use
+	// their own, and the ID was shown to every tenant
+	`
+	UPDATE campaign_templates SET creative_id = NULL WHERE tenant_id IS NULL;
+	`,
+}
+
+// migrate brings the database up to the latest migration version
+func (s *Store) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for v := current + 1; v <= len(migrations); v++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[v-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", v, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", v); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkForeignKeys fails if any row points at a parent that does not exist.
+// Enforcement only covers writes, so such rows would otherwise go unnoticed
+// until a cascade or an update tripped over them.
+func (s *Store) checkForeignKeys() error {
+	var table, parent string
+	var rowid sql.NullInt64
+	var fkid int
+	err := s.db.QueryRow("PRAGMA foreign_key_check").Scan(&table, &rowid, &parent, &fkid)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("foreign key check failed: row %d of %s points at a missing %s", rowid.Int64, table, parent)
+}
+
+// SchemaVersion returns the highest migration version applied to the database
+func (s *Store) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// LatestSchemaVersion returns the migration version this build expects
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
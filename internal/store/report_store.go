@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"time"
+)
+
+// deliveryDimensions maps report dimensions to the impression columns they
+// group by. Hours and days are bucketed in UTC.
+var deliveryDimensions = map[string]string{
+	models.DimensionCampaign: "COALESCE(i.campaign_id, '')",
+	models.DimensionAd:       "i.ad_id",
+	models.DimensionCreative: "COALESCE(i.creative_id, '')",
+	models.DimensionDMA:      "COALESCE(i.dma, '')",
+	models.DimensionClient:   "i.client_id",
+	models.DimensionHour:     "strftime('%Y-%m-%dT%H:00:00Z', i.timestamp)",
+	models.DimensionDay:      "strftime('%Y-%m-%d', i.timestamp)",
+}
+
+// IsDeliveryDimension reports whether name can be used in a DeliveryQuery
+func IsDeliveryDimension(name string) bool {
+	_, ok := deliveryDimensions[name]
+	return ok
+}
+
+// GetDeliveryReport aggregates the impressions of the tenants in scope.
+// Impressions carry their own campaign, creative, tenant and DMA, so the
+// report doesn't change when campaigns are edited or deleted.
+func (s *Store) GetDeliveryReport(q models.DeliveryQuery, scope models.TenantScope) ([]models.DeliveryRow, error) {
+	columns := make([]string, len(q.GroupBy))
+	for i, dim := range q.GroupBy {
+		col, ok := deliveryDimensions[dim]
+		if !ok {
+			return nil, fmt.Errorf("unknown dimension %q", dim)
+		}
+		columns[i] = col
+	}
+
+	where, args := scopeFilter(scope, "i.tenant_id")
+	for dim, value := range q.Filters {
+		col, ok := deliveryDimensions[dim]
+		if !ok || dim == models.DimensionHour || dim == models.DimensionDay {
+			return nil, fmt.Errorf("can't filter by %q", dim)
+		}
+		where += " AND " + col + " = ?"
+		args = append(args, value)
+	}
+	// Timestamps are stored as text in the server's zone, so bounds are
+	// converted to it to compare correctly
+	if !q.From.IsZero() {
+		where += " AND i.timestamp >= ?"
+		args = append(args, q.From.In(time.Local))
+	}
+	if !q.To.IsZero() {
+		where += " AND i.timestamp < ?"
+		args = append(args, q.To.In(time.Local))
+	}
+
+	selectCols := append(append([]string{}, columns...),
+		"COUNT(*)", "COALESCE(SUM(i.duration_seconds), 0)", "COUNT(DISTINCT i.client_id)", "COUNT(i.completed_at)")
+	query := "SELECT " + strings.Join(selectCols, ", ") + " FROM impressions i WHERE 1=1" + where
+	if len(columns) > 0 {
+		positions := make([]string, len(columns))
+		for i := range columns {
+			positions[i] = fmt.Sprint(i + 1)
+		}
+		query += " GROUP BY " + strings.Join(positions, ", ") + " ORDER BY " + strings.Join(positions, ", ")
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []models.DeliveryRow
+	for rows.Next() {
+		values := make([]string, len(columns))
+		row := models.DeliveryRow{Dimensions: make(map[string]string, len(columns))}
+		dest := make([]interface{}, 0, len(columns)+4)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		var uniqueClients int
+		dest = append(dest, &row.Impressions, &row.SecondsServed, &uniqueClients, &row.Completions)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if row.Impressions == 0 {
+			// An ungrouped report over no impressions still returns one row
+			continue
+		}
+		for i, dim := range q.GroupBy {
+			row.Dimensions[dim] = values[i]
+		}
+		row.UniqueClients = &uniqueClients
+		row.CompletionRate = float64(row.Completions) / float64(row.Impressions)
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// CompleteImpression records that the player finished the ad and marks the
+// impression's hour for the rollup aggregator to recompute. It reports false
+// if the impression doesn't exist; repeated completions keep the first.
+func (s *Store) CompleteImpression(id string, at time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE impressions SET completed_at = ? WHERE id = ? AND completed_at IS NULL", at, id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		err := tx.QueryRow("SELECT COUNT(*) FROM impressions WHERE id = ?", id).Scan(&exists)
+		return exists > 0, err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO rollup_dirty_hours (bucket)
+		SELECT strftime('%Y-%m-%dT%H:00:00Z', timestamp) FROM impressions WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// AdRequestKey identifies an hourly ad request count: the UTC hour and the
+// result (filled, empty, rate_limited or error)
+type AdRequestKey struct {
+	Hour   time.Time
+	Result string
+}
+
+// AddAdRequestCounts adds requests tallied elsewhere to the hourly counts,
+// in one transaction
+func (s *Store) AddAdRequestCounts(counts map[AdRequestKey]int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for key, n := range counts {
+		_, err := tx.Exec(`INSERT INTO ad_request_counts (bucket, result, requests) VALUES (?, ?, ?)
+			ON CONFLICT(bucket, result) DO UPDATE SET requests = requests + excluded.requests`,
+			key.Hour.UTC().Format(rollupHourFormat), key.Result, n)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetAdRequestCounts totals the ad requests in the hours in [from, to) by
+// result. Zero times leave the range open.
+func (s *Store) GetAdRequestCounts(from, to time.Time) (map[string]int, error) {
+	where, args := "", []interface{}{}
+	if !from.IsZero() {
+		where += " AND bucket >= ?"
+		args = append(args, from.UTC().Format(rollupHourFormat))
+	}
+	if !to.IsZero() {
+		where += " AND bucket < ?"
+		args = append(args, to.UTC().Format(rollupHourFormat))
+	}
+	rows, err := s.db.Query("SELECT result, SUM(requests) FROM ad_request_counts WHERE 1=1"+where+" GROUP BY result", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var result string
+		var n int
+		if err := rows.Scan(&result, &n); err != nil {
+			return nil, err
+		}
+		counts[result] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"rockbot-adserver/internal/models"
+	"time"
+)
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// recordRevision appends rev to the campaign's history. After is read back
+// from the transaction unless the change deleted the campaign.
+func recordRevision(tx *sql.Tx, rev models.CampaignRevision) error {
+	if rev.Action != models.RevisionDelete {
+		after, err := getCampaign(tx, rev.CampaignID, models.AllTenants)
+		if err != nil {
+			return err
+		}
+		rev.After = after
+	}
+	before, err := snapshotJSON(rev.Before)
+	if err != nil {
+		return err
+	}
+	after, err := snapshotJSON(rev.After)
+	if err != nil {
+		return err
+	}
+	if rev.CreatedAt.IsZero() {
+		rev.CreatedAt = time.Now()
+	}
+	var rolledBackTo sql.NullInt64
+	if rev.RolledBackTo != 0 {
+		rolledBackTo = sql.NullInt64{Int64: rev.RolledBackTo, Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO campaign_revisions (campaign_id, action, before_json, after_json, user_id, username, rolled_back_to, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rev.CampaignID, rev.Action, before, after, nullString(rev.UserID), nullString(rev.Username), rolledBackTo, rev.CreatedAt)
+	return err
+}
+
+func snapshotJSON(c *models.Campaign) (sql.NullString, error) {
+	if c == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(c)
+	return sql.NullString{String: string(data), Valid: true}, err
+}
+
+const revisionColumns = "id, campaign_id, action, before_json, after_json, user_id, username, rolled_back_to, created_at"
+
+func scanRevision(row interface{ Scan(...interface{}) error }) (*models.CampaignRevision, error) {
+	var rev models.CampaignRevision
+	var before, after, userID, username sql.NullString
+	var rolledBackTo sql.NullInt64
+	if err := row.Scan(&rev.ID, &rev.CampaignID, &rev.Action, &before, &after, &userID, &username, &rolledBackTo, &rev.CreatedAt); err != nil {
+		return nil, err
+	}
+	rev.UserID, rev.Username, rev.RolledBackTo = userID.String, username.String, rolledBackTo.Int64
+	for _, snap := range []struct {
+		data sql.NullString
+		dest **models.Campaign
+	}{{before, &rev.Before}, {after, &rev.After}} {
+		if !snap.data.Valid {
+			continue
+		}
+		*snap.dest = &models.Campaign{}
+		if err := json.Unmarshal([]byte(snap.data.String), *snap.dest); err != nil {
+			return nil, err
+		}
+	}
+	return &rev, nil
+}
+
+// GetCampaignRevisions lists a campaign's history, newest first. Callers
+// check the campaign is in scope.
+func (s *Store) GetCampaignRevisions(campaignID string) ([]models.CampaignRevision, error) {
+	rows, err := s.db.Query("SELECT "+revisionColumns+" FROM campaign_revisions WHERE campaign_id = ? ORDER BY id DESC", campaignID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.CampaignRevision
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetCampaignRevision retrieves one revision of a campaign
+func (s *Store) GetCampaignRevision(campaignID string, id int64) (*models.CampaignRevision, error) {
+	return scanRevision(s.db.QueryRow("SELECT "+revisionColumns+" FROM campaign_revisions WHERE campaign_id = ? AND id = ?", campaignID, id))
+}
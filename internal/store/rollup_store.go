@@ -0,0 +1,254 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"rockbot-adserver/internal/models"
+	"strings"
+	"time"
+)
+
+// Rollup bucket formats, both UTC
+const (
+	rollupHourFormat = "2006-01-02T15:00:00Z"
+	rollupDayFormat  = "2006-01-02"
+)
+
+// rollupWatermark names the rollup_state row holding the start of the first
+// hour whose rollup isn't final yet
+const rollupWatermark = "hourly_watermark"
+
+// RollupWatermark returns the start of the first hour that isn't final, or
+// the zero time if rollups have never been built
+func (s *Store) RollupWatermark() (time.Time, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM rollup_state WHERE name = ?", rollupWatermark).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// SetRollupWatermark records that every hour before t is rolled up
+func (s *Store) SetRollupWatermark(t time.Time) error {
+	_, err := s.db.Exec("INSERT INTO rollup_state (name, value) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET value = excluded.value",
+		rollupWatermark, t.UTC().Format(time.RFC3339))
+	return err
+}
+
+// EarliestImpressionTime returns when the first impression was served, and
+// false if there are none
+func (s *Store) EarliestImpressionTime() (time.Time, bool, error) {
+	var t time.Time
+	err := s.db.QueryRow("SELECT timestamp FROM impressions ORDER BY timestamp LIMIT 1").Scan(&t)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	return t, err == nil, err
+}
+
+// DirtyRollupHours lists the hours changed after they were rolled up
+func (s *Store) DirtyRollupHours() ([]time.Time, error) {
+	rows, err := s.db.Query("SELECT bucket FROM rollup_dirty_hours ORDER BY bucket")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hours []time.Time
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		hour, err := time.Parse(rollupHourFormat, bucket)
+		if err != nil {
+			return nil, err
+		}
+		hours = append(hours, hour)
+	}
+	return hours, rows.Err()
+}
+
+// RefreshRollups recomputes the hourly rollups for the hours in [from, to)
+// from impressions, then the daily rollups of the days they fall in. Both
+// bounds must be whole UTC hours. It runs in one transaction, so a
+// completion arriving meanwhile either is included or marks its hour dirty
+// again.
+func (s *Store) RefreshRollups(from, to time.Time) error {
+	from, to = from.UTC(), to.UTC()
+	if from.Truncate(time.Hour) != from || to.Truncate(time.Hour) != to {
+		return fmt.Errorf("rollup range %s - %s is not whole hours", from, to)
+	}
+	if !to.After(from) {
+		return nil
+	}
+	dayFrom := from.Truncate(24 * time.Hour)
+	dayTo := to.Add(24*time.Hour - time.Nanosecond).Truncate(24 * time.Hour)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hourFrom, hourTo := from.Format(rollupHourFormat), to.Format(rollupHourFormat)
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM rollup_dirty_hours WHERE bucket >= ? AND bucket < ?", []interface{}{hourFrom, hourTo}},
+		{"DELETE FROM rollups_hourly WHERE bucket >= ? AND bucket < ?", []interface{}{hourFrom, hourTo}},
+		// Impression timestamps are text in the server's zone, so the
+		// bounds are converted to it to compare correctly
+		{`INSERT INTO rollups_hourly (bucket, campaign_id, ad_id, dma, creative_id, tenant_id, impressions, seconds_served, completions)
+			SELECT strftime('%Y-%m-%dT%H:00:00Z', timestamp), COALESCE(campaign_id, ''), ad_id, COALESCE(dma, ''), COALESCE(creative_id, ''),
+			       MAX(tenant_id), COUNT(*), SUM(duration_seconds), COUNT(completed_at)
+			FROM impressions
+			WHERE timestamp >= ? AND timestamp < ?
+			GROUP BY 1, 2, 3, 4, 5`, []interface{}{from.In(time.Local), to.In(time.Local)}},
+		{"DELETE FROM rollups_daily WHERE bucket >= ? AND bucket < ?", []interface{}{dayFrom.Format(rollupDayFormat), dayTo.Format(rollupDayFormat)}},
+		{`INSERT INTO rollups_daily (bucket, campaign_id, ad_id, dma, creative_id, tenant_id, impressions, seconds_served, completions)
+			SELECT substr(bucket, 1, 10), campaign_id, ad_id, dma, creative_id,
+			       MAX(tenant_id), SUM(impressions), SUM(seconds_served), SUM(completions)
+			FROM rollups_hourly
+			WHERE bucket >= ? AND bucket < ?
+			GROUP BY 1, 2, 3, 4, 5`, []interface{}{dayFrom.Format(rollupHourFormat), dayTo.Format(rollupHourFormat)}},
+	}
+	for _, st := range statements {
+		if _, err := tx.Exec(st.query, st.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ResetRollups empties the rollups and forgets the watermark, so the next
+// refresh rebuilds everything from impressions
+func (s *Store) ResetRollups() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"rollups_hourly", "rollups_daily", "rollup_dirty_hours", "rollup_state"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rollupDimensions maps report dimensions to rollup columns. Clients aren't
+// rolled up; hours exist only in the hourly table.
+var rollupDimensions = map[string]string{
+	models.DimensionCampaign: "campaign_id",
+	models.DimensionAd:       "ad_id",
+	models.DimensionCreative: "creative_id",
+	models.DimensionDMA:      "dma",
+	models.DimensionHour:     "bucket",
+	models.DimensionDay:      "substr(bucket, 1, 10)",
+}
+
+// CanUseRollups reports whether q can be answered from the rollups: it
+// doesn't involve clients or unique clients, and its range is whole hours
+func CanUseRollups(q models.DeliveryQuery) bool {
+	if q.UniqueClients {
+		return false
+	}
+	for _, dim := range q.GroupBy {
+		if _, ok := rollupDimensions[dim]; !ok {
+			return false
+		}
+	}
+	for dim := range q.Filters {
+		if _, ok := rollupDimensions[dim]; !ok {
+			return false
+		}
+	}
+	return q.From.Truncate(time.Hour).Equal(q.From) && q.To.Truncate(time.Hour).Equal(q.To)
+}
+
+// GetRollupDeliveryReport answers q from the daily rollups when its range is
+// whole days and it isn't grouped by hour, and from the hourly ones
+// otherwise. Unique clients are left out. Callers check CanUseRollups first.
+func (s *Store) GetRollupDeliveryReport(q models.DeliveryQuery, scope models.TenantScope) ([]models.DeliveryRow, error) {
+	daily := q.From.UTC().Truncate(24*time.Hour).Equal(q.From) && q.To.UTC().Truncate(24*time.Hour).Equal(q.To)
+	table, bucketFormat := "rollups_daily", rollupDayFormat
+	columns := make([]string, len(q.GroupBy))
+	for i, dim := range q.GroupBy {
+		if dim == models.DimensionHour {
+			daily = false
+		}
+		col, ok := rollupDimensions[dim]
+		if !ok {
+			return nil, fmt.Errorf("dimension %q is not rolled up", dim)
+		}
+		columns[i] = col
+	}
+	if !daily {
+		table, bucketFormat = "rollups_hourly", rollupHourFormat
+	}
+
+	where, args := scopeFilter(scope, "tenant_id")
+	for dim, value := range q.Filters {
+		col, ok := rollupDimensions[dim]
+		if !ok || dim == models.DimensionHour || dim == models.DimensionDay {
+			return nil, fmt.Errorf("can't filter rollups by %q", dim)
+		}
+		where += " AND " + col + " = ?"
+		args = append(args, value)
+	}
+	if !q.From.IsZero() {
+		where += " AND bucket >= ?"
+		args = append(args, q.From.UTC().Format(bucketFormat))
+	}
+	if !q.To.IsZero() {
+		where += " AND bucket < ?"
+		args = append(args, q.To.UTC().Format(bucketFormat))
+	}
+
+	selectCols := append(append([]string{}, columns...),
+		"COALESCE(SUM(impressions), 0)", "COALESCE(SUM(seconds_served), 0)", "COALESCE(SUM(completions), 0)")
+	query := "SELECT " + strings.Join(selectCols, ", ") + " FROM " + table + " WHERE 1=1" + where
+	if len(columns) > 0 {
+		positions := make([]string, len(columns))
+		for i := range columns {
+			positions[i] = fmt.Sprint(i + 1)
+		}
+		query += " GROUP BY " + strings.Join(positions, ", ") + " ORDER BY " + strings.Join(positions, ", ")
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []models.DeliveryRow
+	for rows.Next() {
+		values := make([]string, len(columns))
+		row := models.DeliveryRow{Dimensions: make(map[string]string, len(columns))}
+		dest := make([]interface{}, 0, len(columns)+3)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &row.Impressions, &row.SecondsServed, &row.Completions)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if row.Impressions == 0 {
+			continue
+		}
+		for i, dim := range q.GroupBy {
+			row.Dimensions[dim] = values[i]
+		}
+		row.CompletionRate = float64(row.Completions) / float64(row.Impressions)
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
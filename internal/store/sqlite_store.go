@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -9,10 +10,55 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("rockbot-adserver/internal/store")
+
 type Store struct {
 	db *sql.DB
+
+	// observeQuery, if set, is told how long each timed query took
+	observeQuery func(query string, d time.Duration)
+}
+
+// SetQueryObserver registers fn to receive the latency of the queries on the
+// ad serving and authentication paths, labelled by query name
+func (s *Store) SetQueryObserver(fn func(query string, d time.Duration)) {
+	s.observeQuery = fn
+}
+
+// timeQuery starts timing a query; call the returned func when it is done
+func (s *Store) timeQuery(query string) func() {
+	if s.observeQuery == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { s.observeQuery(query, time.Since(start)) }
+}
+
+// traceQuery times a query like timeQuery and also records it as a span
+// under ctx, so traces show how much of a request was spent in SQLite
+func (s *Store) traceQuery(ctx context.Context, query string) func() {
+	_, span := tracer.Start(ctx, "sqlite "+query, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite"), attribute.String("db.operation.name", query)))
+	done := s.timeQuery(query)
+	return func() {
+		done()
+		span.End()
+	}
+}
+
+// Ping checks that the database can still be reached
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database once nothing will use it again
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
 func NewStore(dbPath string) (*Store, error) {
@@ -22,7 +68,8 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("failed to create db directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// SQLite leaves foreign keys unenforced unless each connection asks
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +82,12 @@ func NewStore(dbPath string) (*Store, error) {
 	if err := s.initSchema(); err != nil {
 		return nil, err
 	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	if err := s.checkForeignKeys(); err != nil {
+		return nil, err
+	}
 
 	return s, nil
 }
@@ -88,15 +141,24 @@ func (s *Store) initSchema() error {
 	return err
 }
 
-func (s *Store) CreateCampaign(c models.Campaign) error {
+// CreateCampaign stores a new campaign with its ads and records rev, the
+// campaign's first revision
+func (s *Store) CreateCampaign(c models.Campaign, rev models.CampaignRevision) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("INSERT INTO campaigns (id, name, start_time, end_time, target_dma) VALUES (?, ?, ?, ?, ?)",
-		c.ID, c.Name, c.StartTime, c.EndTime, c.TargetDMA)
+	if err := createCampaign(tx, c, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createCampaign(tx *sql.Tx, c models.Campaign, rev models.CampaignRevision) error {
+	_, err := tx.Exec("INSERT INTO campaigns (id, name, start_time, end_time, target_dma, tenant_id, impression_goal, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.Name, c.StartTime, c.EndTime, c.TargetDMA, nullString(c.TenantID), c.ImpressionGoal, c.Status)
 	if err != nil {
 		return err
 	}
@@ -109,19 +171,46 @@ func (s *Store) CreateCampaign(c models.Campaign) error {
 		}
 	}
 
+	rev.CampaignID = c.ID
+	return recordRevision(tx, rev)
+}
+
+// ImportCampaigns stores a bulk import in one transaction: each campaign is
+// created or updated according to the action of its revision in revs
+func (s *Store) ImportCampaigns(campaigns []models.Campaign, revs []models.CampaignRevision, scope models.TenantScope) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, c := range campaigns {
+		if revs[i].Action == models.RevisionCreate {
+			err = createCampaign(tx, c, revs[i])
+		} else {
+			err = updateCampaign(tx, c, scope, revs[i])
+		}
+		if err != nil {
+			return fmt.Errorf("campaign %s: %w", c.ID, err)
+		}
+	}
 	return tx.Commit()
 }
 
-func (s *Store) GetActiveCampaigns(dma string, now time.Time) ([]models.Campaign, error) {
+func (s *Store) GetActiveCampaigns(ctx context.Context, dma string, now time.Time) ([]models.Campaign, error) {
+	defer s.traceQuery(ctx, "get_active_campaigns")()
 	query := `
-		SELECT c.id, c.name, c.start_time, c.end_time, c.target_dma,
-		       a.id, a.media_url, a.duration_seconds, a.creative_id
+		SELECT c.id, c.name, c.start_time, c.end_time, c.target_dma, COALESCE(c.tenant_id, ''),
+		       a.id, cr.media_url, cr.duration_seconds, a.creative_id,
+		       cr.mime_type, cr.width, cr.height, cr.bitrate_bps
 		FROM campaigns c
 		JOIN ads a ON c.id = a.campaign_id
-		WHERE ? BETWEEN c.start_time AND c.end_time
+		JOIN creatives cr ON cr.id = a.creative_id AND cr.status = 'active'
+		WHERE c.status = 'scheduled' AND c.deleted_at IS NULL AND a.deleted_at IS NULL
+		AND ? BETWEEN c.start_time AND c.end_time
 		AND (c.target_dma = '*' OR c.target_dma = ?)
 	`
-	rows, err := s.db.Query(query, now, dma)
+	rows, err := s.db.QueryContext(ctx, query, now, dma)
 	if err != nil {
 		return nil, err
 	}
@@ -130,12 +219,14 @@ func (s *Store) GetActiveCampaigns(dma string, now time.Time) ([]models.Campaign
 	campaignMap := make(map[string]*models.Campaign)
 
 	for rows.Next() {
-		var cID, cName, cDMA string
+		var cID, cName, cDMA, cTenant string
 		var cStart, cEnd time.Time
-		var aID, aUrl, aCreative string
-		var aDur int
+		var aID, aUrl, aCreative, aMime string
+		var aDur, aWidth, aHeight int
+		var aBitrate int64
 
-		err := rows.Scan(&cID, &cName, &cStart, &cEnd, &cDMA, &aID, &aUrl, &aDur, &aCreative)
+		err := rows.Scan(&cID, &cName, &cStart, &cEnd, &cDMA, &cTenant, &aID, &aUrl, &aDur, &aCreative,
+			&aMime, &aWidth, &aHeight, &aBitrate)
 		if err != nil {
 			return nil, err
 		}
@@ -147,6 +238,8 @@ func (s *Store) GetActiveCampaigns(dma string, now time.Time) ([]models.Campaign
 				StartTime: cStart,
 				EndTime:   cEnd,
 				TargetDMA: cDMA,
+				TenantID:  cTenant,
+				Status:    models.CampaignStatusLive,
 				Ads:       []models.Ad{},
 			}
 		}
@@ -157,6 +250,10 @@ func (s *Store) GetActiveCampaigns(dma string, now time.Time) ([]models.Campaign
 			MediaURL:        aUrl,
 			DurationSeconds: aDur,
 			CreativeID:      aCreative,
+			MimeType:        aMime,
+			Width:           aWidth,
+			Height:          aHeight,
+			BitrateBps:      aBitrate,
 		})
 	}
 
@@ -167,49 +264,81 @@ func (s *Store) GetActiveCampaigns(dma string, now time.Time) ([]models.Campaign
 	return result, nil
 }
 
-func (s *Store) GetClientImpressionsDuration(clientID string, since time.Time) (int, error) {
+// GetClientImpressionsDuration sums the seconds served to the client after
+// since and after its latest budget reset
+func (s *Store) GetClientImpressionsDuration(ctx context.Context, clientID string, since time.Time) (int, error) {
+	defer s.traceQuery(ctx, "get_client_impressions_duration")()
 	var total int
-	err := s.db.QueryRow("SELECT COALESCE(SUM(duration_seconds), 0) FROM impressions WHERE client_id = ? AND timestamp > ?", clientID, since).Scan(&total)
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(duration_seconds), 0) FROM impressions
+		WHERE client_id = ? AND timestamp > ?
+		AND timestamp > COALESCE((SELECT MAX(created_at) FROM budget_resets WHERE client_id = ?), '')`,
+		clientID, since, clientID).Scan(&total)
 	return total, err
 }
 
-func (s *Store) RecordImpression(imp models.Impression) error {
-	_, err := s.db.Exec("INSERT INTO impressions (id, client_id, ad_id, duration_seconds, timestamp) VALUES (?, ?, ?, ?, ?)",
-		imp.ID, imp.ClientID, imp.AdID, imp.DurationSeconds, imp.Timestamp)
+func (s *Store) RecordImpression(ctx context.Context, imp models.Impression) error {
+	defer s.traceQuery(ctx, "record_impression")()
+	// Not tied to ctx: an ad that was served counts against the rate limit
+	// even if the client hung up before reading the response
+	_, err := s.db.Exec(`INSERT INTO impressions (id, client_id, ad_id, campaign_id, creative_id, tenant_id, dma, duration_seconds, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		imp.ID, imp.ClientID, imp.AdID, imp.CampaignID, imp.CreativeID, nullString(imp.TenantID), imp.DMA, imp.DurationSeconds, imp.Timestamp)
 	return err
 }
 
-// GetAllCampaigns for UI
-func (s *Store) GetAllCampaigns() ([]models.Campaign, error) {
-	rows, err := s.db.Query("SELECT id, name, start_time, end_time, target_dma FROM campaigns ORDER BY start_time DESC")
+// GetAllCampaigns for UI, limited to the tenants in scope. Deleted
+// campaigns are left out, and archived ones unless includeArchived is set.
+func (s *Store) GetAllCampaigns(scope models.TenantScope, includeArchived bool) ([]models.Campaign, error) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	filter += " AND deleted_at IS NULL"
+	if !includeArchived {
+		filter += " AND status != 'archived'"
+	}
+	rows, err := s.db.Query("SELECT id, name, start_time, end_time, target_dma, COALESCE(tenant_id, ''), impression_goal, status FROM campaigns WHERE 1=1"+filter+" ORDER BY start_time DESC", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var campaigns []models.Campaign
+	now := time.Now()
 	for rows.Next() {
 		var c models.Campaign
-		if err := rows.Scan(&c.ID, &c.Name, &c.StartTime, &c.EndTime, &c.TargetDMA); err != nil {
+		if err := rows.Scan(&c.ID, &c.Name, &c.StartTime, &c.EndTime, &c.TargetDMA, &c.TenantID, &c.ImpressionGoal, &c.Status); err != nil {
 			return nil, err
 		}
+		c.Status = models.CampaignStatus(c.Status, c.StartTime, c.EndTime, now)
 		campaigns = append(campaigns, c)
 	}
 	return campaigns, nil
 }
 
-// GetCampaignByID retrieves a campaign by ID with its ads
-func (s *Store) GetCampaignByID(id string) (*models.Campaign, error) {
+// GetCampaignByID retrieves a campaign by ID with its ads. Campaigns outside
+// scope or deleted are reported as sql.ErrNoRows, like missing ones.
+func (s *Store) GetCampaignByID(id string, scope models.TenantScope) (*models.Campaign, error) {
+	return getCampaign(s.db, id, scope)
+}
+
+// getCampaign reads a campaign with its ads through q, so changes can
+// snapshot the campaign inside their transaction
+func getCampaign(q queryer, id string, scope models.TenantScope) (*models.Campaign, error) {
 	// Get campaign
 	var c models.Campaign
-	err := s.db.QueryRow("SELECT id, name, start_time, end_time, target_dma FROM campaigns WHERE id = ?", id).
-		Scan(&c.ID, &c.Name, &c.StartTime, &c.EndTime, &c.TargetDMA)
+	filter, args := scopeFilter(scope, "tenant_id")
+	err := q.QueryRow("SELECT id, name, start_time, end_time, target_dma, COALESCE(tenant_id, ''), impression_goal, status FROM campaigns WHERE id = ? AND deleted_at IS NULL"+filter, append([]interface{}{id}, args...)...).
+		Scan(&c.ID, &c.Name, &c.StartTime, &c.EndTime, &c.TargetDMA, &c.TenantID, &c.ImpressionGoal, &c.Status)
 	if err != nil {
 		return nil, err
 	}
+	c.Status = models.CampaignStatus(c.Status, c.StartTime, c.EndTime, time.Now())
 
-	// Get ads for this campaign
-	rows, err := s.db.Query("SELECT id, campaign_id, media_url, duration_seconds, creative_id FROM ads WHERE campaign_id = ?", id)
+	// Get ads for this campaign, with media details from the creative library
+	rows, err := q.Query(`
+		SELECT a.id, a.campaign_id, COALESCE(cr.media_url, a.media_url), COALESCE(cr.duration_seconds, a.duration_seconds), a.creative_id
+		FROM ads a
+		LEFT JOIN creatives cr ON cr.id = a.creative_id
+		WHERE a.campaign_id = ? AND a.deleted_at IS NULL
+		ORDER BY a.rowid`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -225,118 +354,327 @@ func (s *Store) GetCampaignByID(id string) (*models.Campaign, error) {
 	}
 	c.Ads = ads
 
-	return &c, nil
+	return &c, rows.Err()
 }
 
-// UpdateCampaign updates a campaign and its ads
-func (s *Store) UpdateCampaign(c models.Campaign) error {
+// UpdateCampaign updates a campaign and reconciles its ads in place. Ads are
+// matched by ID so existing rows (and the impressions pointing at them) are
+// kept; ads missing from c.Ads are soft-deleted, deleted ads named again are
+// restored and ads with new IDs are inserted.
+// The owning tenant never changes here. rev is recorded with the campaign as
+// it was before and after.
+func (s *Store) UpdateCampaign(c models.Campaign, scope models.TenantScope, rev models.CampaignRevision) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := updateCampaign(tx, c, scope, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateCampaign(tx *sql.Tx, c models.Campaign, scope models.TenantScope, rev models.CampaignRevision) error {
+	var err error
+	if rev.Before, err = getCampaign(tx, c.ID, scope); err != nil {
+		return err
+	}
+
 	// Update campaign
-	_, err = tx.Exec("UPDATE campaigns SET name = ?, start_time = ?, end_time = ?, target_dma = ? WHERE id = ?",
-		c.Name, c.StartTime, c.EndTime, c.TargetDMA, c.ID)
+	filter, args := scopeFilter(scope, "tenant_id")
+	res, err := tx.Exec("UPDATE campaigns SET name = ?, start_time = ?, end_time = ?, target_dma = ?, impression_goal = ? WHERE id = ?"+filter,
+		append([]interface{}{c.Name, c.StartTime, c.EndTime, c.TargetDMA, c.ImpressionGoal, c.ID}, args...)...)
 	if err != nil {
 		return err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
 
-	// Delete existing ads for this campaign
-	_, err = tx.Exec("DELETE FROM ads WHERE campaign_id = ?", c.ID)
+	// Collect existing ad IDs, deleted ones included, so we can update
+	// rather than re-insert them. The value is whether the ad is live.
+	rows, err := tx.Query("SELECT id, deleted_at IS NULL FROM ads WHERE campaign_id = ?", c.ID)
 	if err != nil {
 		return err
 	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		var live bool
+		if err := rows.Scan(&id, &live); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[id] = live
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	// Insert new ads
 	for _, ad := range c.Ads {
-		_, err = tx.Exec("INSERT INTO ads (id, campaign_id, media_url, duration_seconds, creative_id) VALUES (?, ?, ?, ?, ?)",
-			ad.ID, c.ID, ad.MediaURL, ad.DurationSeconds, ad.CreativeID)
+		if _, ok := existing[ad.ID]; ok {
+			_, err = tx.Exec("UPDATE ads SET media_url = ?, duration_seconds = ?, creative_id = ?, deleted_at = NULL WHERE id = ?",
+				ad.MediaURL, ad.DurationSeconds, ad.CreativeID, ad.ID)
+			delete(existing, ad.ID)
+		} else {
+			_, err = tx.Exec("INSERT INTO ads (id, campaign_id, media_url, duration_seconds, creative_id) VALUES (?, ?, ?, ?, ?)",
+				ad.ID, c.ID, ad.MediaURL, ad.DurationSeconds, ad.CreativeID)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
+	// Whatever is left and still live was dropped from the campaign
+	for id, live := range existing {
+		if !live {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE ads SET deleted_at = ? WHERE id = ?", rev.CreatedAt, id); err != nil {
+			return err
+		}
+	}
+
+	rev.CampaignID = c.ID
+	return recordRevision(tx, rev)
+}
+
+// GetAdCampaignID returns the campaign an ad belongs to, deleted ads
+// included, or sql.ErrNoRows for an unknown ad
+func (s *Store) GetAdCampaignID(adID string) (string, error) {
+	var campaignID string
+	err := s.db.QueryRow("SELECT campaign_id FROM ads WHERE id = ?", adID).Scan(&campaignID)
+	return campaignID, err
+}
+
+// AddAd attaches a single ad to an existing campaign and records rev
+func (s *Store) AddAd(campaignID string, ad models.Ad, scope models.TenantScope, rev models.CampaignRevision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if rev.Before, err = getCampaign(tx, campaignID, scope); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO ads (id, campaign_id, media_url, duration_seconds, creative_id) VALUES (?, ?, ?, ?, ?)",
+		ad.ID, campaignID, ad.MediaURL, ad.DurationSeconds, ad.CreativeID)
+	if err != nil {
+		return err
+	}
+
+	rev.CampaignID = campaignID
+	if err := recordRevision(tx, rev); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-// SeedAvailableAds seeds available ads (with NULL campaign_id) if they don't exist
-func (s *Store) SeedAvailableAds(ads []models.Ad) error {
-	for _, ad := range ads {
-		// Check if ad with this media_url already exists
-		var exists bool
-		err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM ads WHERE media_url = ? AND campaign_id IS NULL)", ad.MediaURL).Scan(&exists)
-		if err != nil {
-			return err
-		}
-		
-		if !exists {
-			_, err = s.db.Exec("INSERT INTO ads (id, campaign_id, media_url, duration_seconds, creative_id) VALUES (?, NULL, ?, ?, ?)",
-				ad.ID, ad.MediaURL, ad.DurationSeconds, ad.CreativeID)
-			if err != nil {
-				return err
-			}
-		}
+// DeleteAd soft-deletes a single ad of a campaign, leaving its other ads
+// untouched, and records rev
+func (s *Store) DeleteAd(campaignID, adID string, scope models.TenantScope, rev models.CampaignRevision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if rev.Before, err = getCampaign(tx, campaignID, scope); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE ads SET deleted_at = ? WHERE id = ? AND campaign_id = ? AND deleted_at IS NULL", rev.CreatedAt, adID, campaignID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	rev.CampaignID = campaignID
+	if err := recordRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// GetAvailableAds returns all ads that are not linked to any campaign (available for selection)
-func (s *Store) GetAvailableAds() ([]models.Ad, error) {
-	rows, err := s.db.Query("SELECT id, campaign_id, media_url, duration_seconds, creative_id FROM ads WHERE campaign_id IS NULL ORDER BY media_url")
+// SetCampaignStatus stores a campaign's lifecycle status and records rev
+func (s *Store) SetCampaignStatus(id, status string, scope models.TenantScope, rev models.CampaignRevision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if rev.Before, err = getCampaign(tx, id, scope); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE campaigns SET status = ? WHERE id = ?", status, id); err != nil {
+		return err
+	}
+
+	rev.CampaignID = id
+	if err := recordRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteCampaign soft-deletes a campaign and its ads and records rev. The
+// rows stay until PurgeDeleted removes them.
+func (s *Store) DeleteCampaign(id string, scope models.TenantScope, rev models.CampaignRevision) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if rev.Before, err = getCampaign(tx, id, scope); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE campaigns SET deleted_at = ? WHERE id = ?", rev.CreatedAt, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE ads SET deleted_at = ? WHERE campaign_id = ? AND deleted_at IS NULL", rev.CreatedAt, id); err != nil {
+		return err
+	}
+
+	rev.CampaignID = id
+	if err := recordRevision(tx, rev); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeleted hard-deletes campaigns and ads that were deleted before
+// cutoff. Impressions and rollups carry their own campaign and creative IDs
+// and are left alone, as is the campaigns' history.
+func (s *Store) PurgeDeleted(cutoff time.Time) (campaigns, ads int64, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM ads WHERE deleted_at < ?
+		OR campaign_id IN (SELECT id FROM campaigns WHERE deleted_at < ?)`, cutoff, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	ads, _ = res.RowsAffected()
+	res, err = tx.Exec("DELETE FROM campaigns WHERE deleted_at < ?", cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	campaigns, _ = res.RowsAffected()
+	return campaigns, ads, tx.Commit()
+}
+
+// GetCampaignNames maps the IDs of the campaigns in scope to their names,
+// deleted and archived campaigns included, for labelling reports. Deleted
+// campaigns are marked as such.
+func (s *Store) GetCampaignNames(scope models.TenantScope) (map[string]string, error) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	rows, err := s.db.Query("SELECT id, name, deleted_at IS NOT NULL FROM campaigns WHERE 1=1"+filter, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ads []models.Ad
+	names := make(map[string]string)
 	for rows.Next() {
-		var ad models.Ad
-		var campaignID sql.NullString
-		if err := rows.Scan(&ad.ID, &campaignID, &ad.MediaURL, &ad.DurationSeconds, &ad.CreativeID); err != nil {
+		var id, name string
+		var deleted bool
+		if err := rows.Scan(&id, &name, &deleted); err != nil {
 			return nil, err
 		}
-		if campaignID.Valid {
-			ad.CampaignID = campaignID.String
+		if deleted {
+			name += " (deleted)"
 		}
-		ads = append(ads, ad)
+		names[id] = name
 	}
-	return ads, nil
+	return names, rows.Err()
 }
 
-// GetAvailableAdByMediaURL returns an available ad (with NULL campaign_id) by media_url
-func (s *Store) GetAvailableAdByMediaURL(mediaURL string) (*models.Ad, error) {
-	var ad models.Ad
-	var campaignID sql.NullString
-	err := s.db.QueryRow("SELECT id, campaign_id, media_url, duration_seconds, creative_id FROM ads WHERE media_url = ? AND campaign_id IS NULL", mediaURL).
-		Scan(&ad.ID, &campaignID, &ad.MediaURL, &ad.DurationSeconds, &ad.CreativeID)
+// GetCampaignDelivery totals impressions per campaign for the tenants in scope
+func (s *Store) GetCampaignDelivery(scope models.TenantScope) (map[string]models.CampaignDelivery, error) {
+	filter, args := scopeFilter(scope, "c.tenant_id")
+	rows, err := s.db.Query(`
+		SELECT c.id, COUNT(i.id), COALESCE(SUM(i.duration_seconds), 0), COUNT(DISTINCT i.client_id)
+		FROM campaigns c
+		JOIN ads a ON a.campaign_id = c.id
+		JOIN impressions i ON i.ad_id = a.id
+		WHERE 1=1`+filter+`
+		GROUP BY c.id`, args...)
 	if err != nil {
 		return nil, err
 	}
-	if campaignID.Valid {
-		ad.CampaignID = campaignID.String
+	defer rows.Close()
+
+	delivery := make(map[string]models.CampaignDelivery)
+	for rows.Next() {
+		var id string
+		var d models.CampaignDelivery
+		if err := rows.Scan(&id, &d.Impressions, &d.SecondsServed, &d.UniqueClients); err != nil {
+			return nil, err
+		}
+		delivery[id] = d
 	}
-	return &ad, nil
+	return delivery, rows.Err()
 }
 
 // SaveRequestLog saves a request/response log to the database
 func (s *Store) SaveRequestLog(log models.RequestLog) error {
 	_, err := s.db.Exec(`
 		INSERT INTO request_logs (
-			id, method, path, query_params, request_headers, request_body,
+			id, request_id, method, path, query_params, request_headers, request_body,
 			response_status, response_headers, response_body, duration_ms,
 			timestamp, remote_addr, user_agent
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		log.ID, log.Method, log.Path, log.QueryParams, log.RequestHeaders, log.RequestBody,
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		log.ID, nullString(log.RequestID), log.Method, log.Path, log.QueryParams, log.RequestHeaders, log.RequestBody,
 		log.ResponseStatus, log.ResponseHeaders, log.ResponseBody, log.DurationMs,
 		log.Timestamp, log.RemoteAddr, log.UserAgent)
 	return err
 }
 
+// SaveRequestLogs saves a batch of request logs in one transaction
+func (s *Store) SaveRequestLogs(logs []models.RequestLog) error {
+	defer s.timeQuery("save_request_logs")()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO request_logs (
+			id, request_id, method, path, query_params, request_headers, request_body,
+			response_status, response_headers, response_body, duration_ms,
+			timestamp, remote_addr, user_agent
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, log := range logs {
+		_, err := stmt.Exec(
+			log.ID, nullString(log.RequestID), log.Method, log.Path, log.QueryParams, log.RequestHeaders, log.RequestBody,
+			log.ResponseStatus, log.ResponseHeaders, log.ResponseBody, log.DurationMs,
+			log.Timestamp, log.RemoteAddr, log.UserAgent)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // GetRequestLogs retrieves request logs with optional filters
-func (s *Store) GetRequestLogs(limit int, offset int, methodFilter string, pathFilter string, startTime *time.Time, endTime *time.Time) ([]models.RequestLog, error) {
-	query := "SELECT id, method, path, query_params, request_headers, request_body, response_status, response_headers, response_body, duration_ms, timestamp, remote_addr, user_agent FROM request_logs WHERE 1=1"
+func (s *Store) GetRequestLogs(limit int, offset int, methodFilter string, pathFilter string, requestIDFilter string, startTime *time.Time, endTime *time.Time) ([]models.RequestLog, error) {
+	query := "SELECT id, COALESCE(request_id, ''), method, path, query_params, request_headers, request_body, response_status, response_headers, response_body, duration_ms, timestamp, remote_addr, user_agent FROM request_logs WHERE 1=1"
 	args := []interface{}{}
 
 	if methodFilter != "" {
@@ -347,6 +685,10 @@ func (s *Store) GetRequestLogs(limit int, offset int, methodFilter string, pathF
 		query += " AND path LIKE ?"
 		args = append(args, "%"+pathFilter+"%")
 	}
+	if requestIDFilter != "" {
+		query += " AND request_id = ?"
+		args = append(args, requestIDFilter)
+	}
 	if startTime != nil {
 		query += " AND timestamp >= ?"
 		args = append(args, *startTime)
@@ -369,7 +711,7 @@ func (s *Store) GetRequestLogs(limit int, offset int, methodFilter string, pathF
 	for rows.Next() {
 		var log models.RequestLog
 		err := rows.Scan(
-			&log.ID, &log.Method, &log.Path, &log.QueryParams, &log.RequestHeaders, &log.RequestBody,
+			&log.ID, &log.RequestID, &log.Method, &log.Path, &log.QueryParams, &log.RequestHeaders, &log.RequestBody,
 			&log.ResponseStatus, &log.ResponseHeaders, &log.ResponseBody, &log.DurationMs,
 			&log.Timestamp, &log.RemoteAddr, &log.UserAgent)
 		if err != nil {
@@ -380,8 +722,52 @@ func (s *Store) GetRequestLogs(limit int, offset int, methodFilter string, pathF
 	return logs, nil
 }
 
+// EachRequestLogBefore calls fn for every request log older than cutoff, oldest first
+func (s *Store) EachRequestLogBefore(cutoff time.Time, fn func(models.RequestLog) error) error {
+	rows, err := s.db.Query("SELECT id, COALESCE(request_id, ''), method, path, query_params, request_headers, request_body, response_status, response_headers, response_body, duration_ms, timestamp, remote_addr, user_agent FROM request_logs WHERE timestamp < ? ORDER BY timestamp", cutoff)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var log models.RequestLog
+		err := rows.Scan(
+			&log.ID, &log.RequestID, &log.Method, &log.Path, &log.QueryParams, &log.RequestHeaders, &log.RequestBody,
+			&log.ResponseStatus, &log.ResponseHeaders, &log.ResponseBody, &log.DurationMs,
+			&log.Timestamp, &log.RemoteAddr, &log.UserAgent)
+		if err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteRequestLogsBefore removes request logs older than cutoff. It deletes
+// in small batches so ad requests aren't locked out of the database for long.
+func (s *Store) DeleteRequestLogsBefore(cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		res, err := s.db.Exec("DELETE FROM request_logs WHERE id IN (SELECT id FROM request_logs WHERE timestamp < ? LIMIT 1000)", cutoff)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n == 0 {
+			return total, nil
+		}
+	}
+}
+
 // GetRequestLogCount returns the total count of request logs matching filters
-func (s *Store) GetRequestLogCount(methodFilter string, pathFilter string, startTime *time.Time, endTime *time.Time) (int, error) {
+func (s *Store) GetRequestLogCount(methodFilter string, pathFilter string, requestIDFilter string, startTime *time.Time, endTime *time.Time) (int, error) {
 	query := "SELECT COUNT(*) FROM request_logs WHERE 1=1"
 	args := []interface{}{}
 
@@ -393,6 +779,10 @@ func (s *Store) GetRequestLogCount(methodFilter string, pathFilter string, start
 		query += " AND path LIKE ?"
 		args = append(args, "%"+pathFilter+"%")
 	}
+	if requestIDFilter != "" {
+		query += " AND request_id = ?"
+		args = append(args, requestIDFilter)
+	}
 	if startTime != nil {
 		query += " AND timestamp >= ?"
 		args = append(args, *startTime)
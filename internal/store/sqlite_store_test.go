@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"rockbot-adserver/internal/models"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testCampaign(id string, adIDs ...string) models.Campaign {
+	c := models.Campaign{
+		ID:        id,
+		Name:      id,
+		StartTime: time.Now().Add(-time.Hour),
+		EndTime:   time.Now().Add(time.Hour),
+		TargetDMA: "*",
+		Status:    models.CampaignStatusScheduled,
+	}
+	for _, adID := range adIDs {
+		c.Ads = append(c.Ads, models.Ad{ID: adID, MediaURL: "http://example.com/" + adID + ".mp4", DurationSeconds: 15, CreativeID: "cr-" + adID})
+	}
+	return c
+}
+
+func TestForeignKeysEnforced(t *testing.T) {
+	s := openTestStore(t)
+
+	if _, err := s.db.Exec("INSERT INTO ads (id, campaign_id, media_url, duration_seconds, creative_id) VALUES ('a', 'missing', 'u', 15, 'c')"); err == nil {
+		t.Error("inserted an ad for a campaign that does not exist")
+	}
+
+	u := models.User{ID: "u1", Username: "u1", PasswordHash: "x", Role: "admin", CreatedAt: time.Now()}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	sess := models.Session{TokenHash: "h", UserID: u.ID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("DELETE FROM users WHERE id = ?", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	var sessions int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 0 {
+		t.Errorf("%d sessions left after deleting their user; ON DELETE CASCADE is not enforced", sessions)
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	s := openTestStore(t)
+	deletedAt := time.Now().Add(-48 * time.Hour)
+	created := models.CampaignRevision{Action: models.RevisionCreate, CreatedAt: deletedAt}
+	rev := models.CampaignRevision{Action: models.RevisionDelete, CreatedAt: deletedAt}
+
+	// gone: a deleted campaign, whose ad was deleted with it
+	if err := s.CreateCampaign(testCampaign("gone", "gone-ad"), created); err != nil {
+		t.Fatal(err)
+	}
+	// kept: a live campaign with one deleted ad, and one deleted since the cutoff
+	if err := s.CreateCampaign(testCampaign("kept", "old-ad", "new-ad", "live-ad"), created); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteCampaign("gone", models.AllTenants, rev); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAd("kept", "old-ad", models.AllTenants, models.CampaignRevision{Action: models.RevisionUpdate, CreatedAt: deletedAt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAd("kept", "new-ad", models.AllTenants, models.CampaignRevision{Action: models.RevisionUpdate, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	imp := models.Impression{ID: "imp", ClientID: "c", AdID: "gone-ad", CampaignID: "gone", DMA: "501", DurationSeconds: 15, Timestamp: deletedAt}
+	if err := s.RecordImpression(context.Background(), imp); err != nil {
+		t.Fatal(err)
+	}
+
+	campaigns, ads, err := s.PurgeDeleted(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeDeleted: %v", err)
+	}
+	if campaigns != 1 || ads != 2 {
+		t.Errorf("purged %d campaigns and %d ads, want 1 and 2", campaigns, ads)
+	}
+
+	var left []string
+	rows, err := s.db.Query("SELECT id FROM ads ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		left = append(left, id)
+	}
+	if len(left) != 2 || left[0] != "live-ad" || left[1] != "new-ad" {
+		t.Errorf("ads left after purge: %v, want [live-ad new-ad]", left)
+	}
+	var impressions int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM impressions").Scan(&impressions); err != nil {
+		t.Fatal(err)
+	}
+	if impressions != 1 {
+		t.Errorf("%d impressions left after purge, want 1", impressions)
+	}
+	if err := s.checkForeignKeys(); err != nil {
+		t.Error(err)
+	}
+}
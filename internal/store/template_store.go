@@ -0,0 +1,76 @@
+package store
+
+import (
+	"database/sql"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+const templateColumns = "id, tenant_id, name, duration_days, target_dma, impression_goal, creative_id, created_by, created_at"
+
+func scanTemplate(row interface{ Scan(...interface{}) error }) (*models.CampaignTemplate, error) {
+	var t models.CampaignTemplate
+	var tenantID, creativeID, createdBy sql.NullString
+	if err := row.Scan(&t.ID, &tenantID, &t.Name, &t.DurationDays, &t.TargetDMA, &t.ImpressionGoal, &creativeID, &createdBy, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.TenantID, t.CreativeID, t.CreatedBy = tenantID.String, creativeID.String, createdBy.String
+	return &t, nil
+}
+
+// templateFilter limits templates to the shared ones and those of the
+// tenants in scope
+func templateFilter(scope models.TenantScope) (string, []interface{}) {
+	filter, args := scopeFilter(scope, "tenant_id")
+	if filter == "" {
+		return "", nil
+	}
+	return " AND (tenant_id IS NULL OR " + strings.TrimPrefix(filter, " AND ") + ")", args
+}
+
+// CreateCampaignTemplate stores a new template
+func (s *Store) CreateCampaignTemplate(t models.CampaignTemplate) error {
+	_, err := s.db.Exec(`INSERT INTO campaign_templates (`+templateColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, nullString(t.TenantID), t.Name, t.DurationDays, t.TargetDMA, t.ImpressionGoal, nullString(t.CreativeID), nullString(t.CreatedBy), t.CreatedAt)
+	return err
+}
+
+// GetCampaignTemplates lists the templates usable in scope, by name
+func (s *Store) GetCampaignTemplates(scope models.TenantScope) ([]models.CampaignTemplate, error) {
+	filter, args := templateFilter(scope)
+	rows, err := s.db.Query("SELECT "+templateColumns+" FROM campaign_templates WHERE 1=1"+filter+" ORDER BY name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []models.CampaignTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+// GetCampaignTemplate returns a template usable in scope
+func (s *Store) GetCampaignTemplate(id string, scope models.TenantScope) (*models.CampaignTemplate, error) {
+	filter, args := templateFilter(scope)
+	return scanTemplate(s.db.QueryRow("SELECT "+templateColumns+" FROM campaign_templates WHERE id = ?"+filter, append([]interface{}{id}, args...)...))
+}
+
+// DeleteCampaignTemplate removes a template owned by a tenant in scope.
+// Shared templates can only be removed with every tenant in scope.
+func (s *Store) DeleteCampaignTemplate(id string, scope models.TenantScope) error {
+	filter, args := scopeFilter(scope, "tenant_id")
+	res, err := s.db.Exec("DELETE FROM campaign_templates WHERE id = ?"+filter, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
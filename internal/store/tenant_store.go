@@ -0,0 +1,88 @@
+package store
+
+import (
+	"database/sql"
+	"rockbot-adserver/internal/models"
+	"strings"
+)
+
+// scopeFilter returns an SQL condition, starting with " AND", that limits
+// column to the tenants in scope, plus its arguments. Every query over
+// tenant-owned rows that serves a user must include it.
+func scopeFilter(scope models.TenantScope, column string) (string, []interface{}) {
+	if scope.All {
+		return "", nil
+	}
+	if len(scope.TenantIDs) == 0 {
+		return " AND 1 = 0", nil
+	}
+	args := make([]interface{}, len(scope.TenantIDs))
+	for i, id := range scope.TenantIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	return " AND " + column + " IN (" + placeholders + ")", args
+}
+
+const tenantColumns = "id, name, type, parent_id, created_at"
+
+func scanTenant(row interface{ Scan(...interface{}) error }) (*models.Tenant, error) {
+	var t models.Tenant
+	var parentID sql.NullString
+	if err := row.Scan(&t.ID, &t.Name, &t.Type, &parentID, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.ParentID = parentID.String
+	return &t, nil
+}
+
+// CreateTenant adds an agency or advertiser
+func (s *Store) CreateTenant(t models.Tenant) error {
+	_, err := s.db.Exec("INSERT INTO tenants (id, name, type, parent_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		t.ID, t.Name, t.Type, nullString(t.ParentID), t.CreatedAt)
+	return err
+}
+
+// GetTenantByID retrieves a single tenant
+func (s *Store) GetTenantByID(id string) (*models.Tenant, error) {
+	return scanTenant(s.db.QueryRow("SELECT "+tenantColumns+" FROM tenants WHERE id = ?", id))
+}
+
+// GetTenants lists the tenants in scope, agencies first
+func (s *Store) GetTenants(scope models.TenantScope) ([]models.Tenant, error) {
+	filter, args := scopeFilter(scope, "id")
+	rows, err := s.db.Query("SELECT "+tenantColumns+" FROM tenants WHERE 1=1"+filter+" ORDER BY type, name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []models.Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// GetChildTenantIDs returns the IDs of the advertisers under an agency
+func (s *Store) GetChildTenantIDs(parentID string) ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM tenants WHERE parent_id = ?", parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"rockbot-adserver/internal/models"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tenantFixture has two advertisers, each with a campaign, a creative and
+// an impression, plus a house campaign
+type tenantFixture struct {
+	s          *Store
+	acme, beta string
+}
+
+func newTenantFixture(t *testing.T) *tenantFixture {
+	t.Helper()
+	f := &tenantFixture{s: openTestStore(t), acme: "acme", beta: "beta"}
+	for _, id := range []string{f.acme, f.beta} {
+		if err := f.s.CreateTenant(models.Tenant{ID: id, Name: id, Type: models.TenantTypeAdvertiser, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	for _, owner := range []string{f.acme, f.beta, ""} {
+		name := owner
+		if name == "" {
+			name = "house"
+		}
+		c := testCampaign(name+"-campaign", name+"-ad")
+		c.TenantID = owner
+		if err := f.s.CreateCampaign(c, models.CampaignRevision{Action: models.RevisionCreate}); err != nil {
+			t.Fatal(err)
+		}
+		creative := models.CreativeAsset{ID: "cr-" + name + "-ad", Name: name, MediaURL: "http://example.com/" + name + ".mp4",
+			DurationSeconds: 15, TenantID: owner, Status: models.CreativeStatusActive, CreatedAt: time.Now()}
+		if err := f.s.CreateCreative(creative); err != nil {
+			t.Fatal(err)
+		}
+		imp := models.Impression{ID: name + "-imp", ClientID: "lobby", AdID: name + "-ad", CampaignID: c.ID, CreativeID: creative.ID,
+			TenantID: owner, DMA: "501", DurationSeconds: 15, Timestamp: hour.Add(10 * time.Minute)}
+		if err := f.s.RecordImpression(context.Background(), imp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.s.RefreshRollups(hour, hour.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func campaignIDs(campaigns []models.Campaign) string {
+	var ids []string
+	for _, c := range campaigns {
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func reportCampaigns(rows []models.DeliveryRow) string {
+	var ids []string
+	for _, r := range rows {
+		ids = append(ids, r.Dimensions[models.DimensionCampaign])
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestTenantScopedReads(t *testing.T) {
+	f := newTenantFixture(t)
+	byCampaign := models.DeliveryQuery{GroupBy: []string{models.DimensionCampaign}}
+
+	tests := []struct {
+		name  string
+		scope models.TenantScope
+		want  string
+	}{
+		{"platform", models.AllTenants, "acme-campaign,beta-campaign,house-campaign"},
+		{"one advertiser", models.TenantScope{TenantIDs: []string{f.acme}}, "acme-campaign"},
+		{"agency of both", models.TenantScope{TenantIDs: []string{f.acme, f.beta}}, "acme-campaign,beta-campaign"},
+		{"no tenant", models.TenantScope{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			campaigns, err := f.s.GetAllCampaigns(tt.scope, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := campaignIDs(campaigns); got != tt.want {
+				t.Errorf("campaigns: got %q, want %q", got, tt.want)
+			}
+
+			names, err := f.s.GetCampaignNames(tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var named []string
+			for id := range names {
+				named = append(named, id)
+			}
+			sort.Strings(named)
+			if got := strings.Join(named, ","); got != tt.want {
+				t.Errorf("campaign names: got %q, want %q", got, tt.want)
+			}
+
+			raw, err := f.s.GetDeliveryReport(byCampaign, tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reportCampaigns(raw); got != tt.want {
+				t.Errorf("delivery report: got %q, want %q", got, tt.want)
+			}
+			rolled, err := f.s.GetRollupDeliveryReport(byCampaign, tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reportCampaigns(rolled); got != tt.want {
+				t.Errorf("rollup delivery report: got %q, want %q", got, tt.want)
+			}
+
+			creatives, err := f.s.GetCreatives("", tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(creatives) != len(campaigns) {
+				t.Errorf("got %d creatives for %d campaigns", len(creatives), len(campaigns))
+			}
+			for _, c := range creatives {
+				if !tt.scope.Includes(c.TenantID) {
+					t.Errorf("creative %s of tenant %q is out of scope", c.ID, c.TenantID)
+				}
+			}
+		})
+	}
+}
+
+func TestTenantScopedSingleRows(t *testing.T) {
+	f := newTenantFixture(t)
+	acme := models.TenantScope{TenantIDs: []string{f.acme}}
+	rev := models.CampaignRevision{Action: models.RevisionUpdate}
+
+	if _, err := f.s.GetCampaignByID("acme-campaign", acme); err != nil {
+		t.Errorf("own campaign: %v", err)
+	}
+	for _, id := range []string{"beta-campaign", "house-campaign"} {
+		if _, err := f.s.GetCampaignByID(id, acme); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetCampaignByID(%s): got %v, want ErrNoRows", id, err)
+		}
+	}
+	if _, err := f.s.GetCreativeByID("cr-beta-ad", acme); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetCreativeByID of another advertiser: got %v, want ErrNoRows", err)
+	}
+
+	// Writes through a foreign scope fail and leave the row alone
+	beta, err := f.s.GetCampaignByID("beta-campaign", models.AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := *beta
+	renamed.Name = "taken over"
+	if err := f.s.UpdateCampaign(renamed, acme, rev); err == nil {
+		t.Error("updated another advertiser's campaign")
+	}
+	if err := f.s.SetCampaignStatus(beta.ID, models.CampaignStatusPaused, acme, rev); err == nil {
+		t.Error("paused another advertiser's campaign")
+	}
+	if err := f.s.DeleteAd(beta.ID, "beta-ad", acme, rev); err == nil {
+		t.Error("deleted another advertiser's ad")
+	}
+	if err := f.s.DeleteCampaign(beta.ID, acme, models.CampaignRevision{Action: models.RevisionDelete}); err == nil {
+		t.Error("deleted another advertiser's campaign")
+	}
+	if err := f.s.RetireCreative("cr-beta-ad", time.Now(), acme); err == nil {
+		t.Error("retired another advertiser's creative")
+	}
+
+	after, err := f.s.GetCampaignByID(beta.ID, models.AllTenants)
+	if err != nil {
+		t.Fatalf("the campaign is gone: %v", err)
+	}
+	if after.Name != beta.Name || after.Status != beta.Status || len(after.Ads) != len(beta.Ads) {
+		t.Errorf("campaign changed through another tenant's scope: %+v", after)
+	}
+	creative, err := f.s.GetCreativeByID("cr-beta-ad", models.AllTenants)
+	if err != nil || creative.Status != models.CreativeStatusActive {
+		t.Errorf("creative changed through another tenant's scope: %+v, %v", creative, err)
+	}
+}
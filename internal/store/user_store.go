@@ -0,0 +1,120 @@
+package store
+
+import (
+	"database/sql"
+	"rockbot-adserver/internal/models"
+	"time"
+)
+
+// CreateUser adds a user account
+func (s *Store) CreateUser(u models.User) error {
+	_, err := s.db.Exec("INSERT INTO users (id, username, password_hash, role, tenant_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		u.ID, u.Username, u.PasswordHash, u.Role, nullString(u.TenantID), u.CreatedAt)
+	return err
+}
+
+// GetUserByUsername looks a user up case-insensitively
+func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+	var u models.User
+	err := s.db.QueryRow("SELECT id, username, password_hash, role, COALESCE(tenant_id, ''), created_at FROM users WHERE username = ?", username).
+		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.TenantID, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUsers lists all user accounts
+func (s *Store) GetUsers() ([]models.User, error) {
+	rows, err := s.db.Query("SELECT id, username, password_hash, role, COALESCE(tenant_id, ''), created_at FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.TenantID, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole changes a user's role
+func (s *Store) SetUserRole(userID, role string) error {
+	res, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetUserTenant moves a user to another tenant; an empty tenantID makes them a platform user
+func (s *Store) SetUserTenant(userID, tenantID string) error {
+	res, err := s.db.Exec("UPDATE users SET tenant_id = ? WHERE id = ?", nullString(tenantID), userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetUserPassword replaces a user's password hash and ends all of their sessions
+func (s *Store) SetUserPassword(userID, passwordHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateSession stores a new login session
+func (s *Store) CreateSession(sess models.Session) error {
+	_, err := s.db.Exec("INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		sess.TokenHash, sess.UserID, sess.CreatedAt, sess.ExpiresAt)
+	return err
+}
+
+// GetSessionUser returns the user owning an unexpired session
+func (s *Store) GetSessionUser(tokenHash string, now time.Time) (*models.User, error) {
+	defer s.timeQuery("get_session_user")()
+	var u models.User
+	err := s.db.QueryRow(`
+		SELECT u.id, u.username, u.password_hash, u.role, COALESCE(u.tenant_id, ''), u.created_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, tokenHash, now).
+		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.TenantID, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DeleteSession ends a session
+func (s *Store) DeleteSession(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that expired before now
+func (s *Store) DeleteExpiredSessions(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", now)
+	return err
+}
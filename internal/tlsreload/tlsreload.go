@@ -0,0 +1,87 @@
+// Package tlsreload serves a TLS certificate from files that may be replaced
+// while the server runs, e.g. by certbot or a mounted Kubernetes secret, so
+// renewing a certificate doesn't need a restart.
+package tlsreload
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often handshakes look at the files for changes
+const checkInterval = 10 * time.Second
+
+// Reloader loads a certificate and key pair and reloads it when either file
+// changes. A broken replacement is logged and the previous pair kept.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// New loads the pair, failing if it can't be used
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.modTime, r.checked = &cert, modTime, time.Now()
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= checkInterval {
+		r.checked = time.Now()
+		r.reload()
+	}
+	return r.cert, nil
+}
+
+// reload loads the files again if they changed since the last load
+func (r *Reloader) reload() {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		slog.Error("Failed to check TLS certificate", "cert_file", r.certFile, "error", err)
+		return
+	}
+	if !modTime.After(r.modTime) {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		// Often the certificate was written before the key; try again later
+		slog.Error("Failed to reload TLS certificate, keeping the current one", "cert_file", r.certFile, "error", err)
+		return
+	}
+	r.cert, r.modTime = &cert, modTime
+	slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+}
+
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}